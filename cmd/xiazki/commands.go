@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/exporter"
+	"xiazki/internal/handler"
+	"xiazki/internal/importer"
+	"xiazki/internal/model"
+)
+
+func runCommand(cfg *config.Config, db *database.DB, args []string) error {
+	switch args[0] {
+	case "openapi":
+		return openAPICommand(cfg, db, args[1:])
+	case "import":
+		return importCommand(db, args[1:])
+	case "export":
+		return exportCommand(db, args[1:])
+	case "search":
+		return searchCommand(db, args[1:])
+	case "migrate":
+		return migrateCommand(db, args[1:])
+	default:
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+}
+
+func importCommand(db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	username := fs.String("user", "", "user to import reviews and events for (default: the admin)")
+	dryRun := fs.Bool("dry-run", false, "only report what would be imported")
+	columns := fs.String("map", "", "column mapping for csv and json, e.g. \"title=Book Title,authors=Writer\"")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xiazki import [flags] <source> <file>\n\nsources: %v\n\nflags:\n", importer.Sources())
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	user, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Close() }()
+
+	records, err := importRecords(fs.Arg(0), f, *columns)
+	if err != nil {
+		return err
+	}
+
+	results := importer.Import(ctx, db, user, records, *dryRun)
+	for _, result := range results {
+		title := ""
+		if result.Record.Book != nil {
+			title = result.Record.Book.Title
+		}
+		if result.Err != nil {
+			fmt.Printf("line %d: %s: %s: %v\n", result.Record.Line, title, result.Status, result.Err)
+		} else {
+			fmt.Printf("line %d: %s: %s\n", result.Record.Line, title, result.Status)
+		}
+	}
+
+	summary := importer.Summary(results)
+	fmt.Printf("%d new, %d existing, %d failed\n", summary[importer.StatusNew], summary[importer.StatusExisting], summary[importer.StatusFailed])
+	return nil
+}
+
+func exportCommand(db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	username := fs.String("user", "", "user to export reviews, events and quotes of (default: the admin)")
+	format := fs.String("format", string(exporter.FormatJSON), fmt.Sprintf("export format, one of %v", exporter.Formats))
+	output := fs.String("o", "", "output file (default: stdout)")
+	_ = fs.Parse(args)
+
+	ctx := context.Background()
+	user, err := commandUser(ctx, db, *username)
+	if err != nil {
+		return err
+	}
+
+	w := os.Stdout
+	if *output != "" {
+		if w, err = os.Create(*output); err != nil {
+			return err
+		}
+		defer func() { _ = w.Close() }()
+	}
+
+	return exporter.Export(ctx, db, user.ID, exporter.Format(*format), w)
+}
+
+// importRecords parses the import file. Generic formats use the columns
+// matching the field names unless mapped otherwise.
+func importRecords(source string, r io.Reader, columns string) ([]*importer.Record, error) {
+	if !importer.IsMapped(source) {
+		return importer.Parse(source, r)
+	}
+
+	table, err := importer.ReadTable(source, r)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := importer.DefaultMapping(table.Columns)
+	for pair := range strings.SplitSeq(columns, ",") {
+		if pair == "" {
+			continue
+		}
+		field, column, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid mapping: %q", pair)
+		}
+		mapping[strings.TrimSpace(field)] = strings.TrimSpace(column)
+	}
+
+	return table.Records(mapping), nil
+}
+
+// commandUser finds the user a command acts on behalf of. Without a username
+// the first admin is used.
+func commandUser(ctx context.Context, db *database.DB, username string) (*model.User, error) {
+	var user model.User
+	q := db.NewSelect().Model(&user)
+	if username != "" {
+		q = q.Where("username = ?", username)
+	} else {
+		q = q.Where("role = ?", model.RoleAdmin).Order("created_at ASC")
+	}
+	if err := q.Limit(1).Scan(ctx); err != nil {
+		if username != "" {
+			return nil, fmt.Errorf("user %q not found: %w", username, err)
+		}
+		return nil, fmt.Errorf("no admin user found: %w", err)
+	}
+	return &user, nil
+}
+
+func searchCommand(db *database.DB, args []string) error {
+	if len(args) != 1 || args[0] != "rebuild" {
+		fmt.Fprintln(os.Stderr, "usage: xiazki search rebuild")
+		os.Exit(2)
+	}
+
+	if err := db.RebuildSearchIndex(context.Background()); err != nil {
+		return err
+	}
+	fmt.Println("search index rebuilt")
+	return nil
+}
+
+// openAPICommand prints the OpenAPI document of the API or checks that it
+// lists exactly the routes of the API.
+func openAPICommand(cfg *config.Config, db *database.DB, args []string) error {
+	if len(args) != 1 || args[0] != "print" && args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: xiazki openapi print|check")
+		os.Exit(2)
+	}
+
+	prefix := cfg.BasePath + "/api/" + api.Version
+	if args[0] == "print" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(api.NewDocument(prefix, handler.APIEndpoints))
+	}
+
+	e := newServer(cfg, handler.NewHandler(db, cfg))
+	problems := handler.CheckOpenAPI(e.Routes(), prefix)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the OpenAPI document does not match the routes")
+	}
+	fmt.Printf("%d endpoints match the OpenAPI document\n", len(handler.APIEndpoints))
+	return nil
+}
+
+func migrateCommand(db *database.DB, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	yes := fs.Bool("yes", false, "confirm reverting the last migration with down, which may drop data")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xiazki migrate status|up|down [flags]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if len(args) == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	_ = fs.Parse(args[1:])
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	ctx := context.Background()
+	switch args[0] {
+	case "status":
+		ms, err := db.Migrations(ctx)
+		if err != nil {
+			return err
+		}
+		for _, m := range ms {
+			if m.IsApplied() {
+				fmt.Printf("%s\tapplied in group %d at %s\n", m, m.GroupID, m.MigratedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("%s\tpending\n", m)
+			}
+		}
+	case "up":
+		group, err := db.Migrate(ctx)
+		if err != nil {
+			return err
+		}
+		if group.IsZero() {
+			fmt.Println("database is up to date")
+		} else {
+			fmt.Printf("applied %s\n", group)
+		}
+	case "down":
+		if !*yes {
+			return fmt.Errorf("reverting a migration may drop data, back up the database and confirm with -yes")
+		}
+		m, err := db.Rollback(ctx)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			fmt.Println("there is nothing to roll back")
+		} else {
+			fmt.Printf("rolled back %s\n", m)
+		}
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+	return nil
+}
@@ -1,97 +1,79 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
 
+	"xiazki/internal/config"
 	"xiazki/internal/database"
 	"xiazki/internal/handler"
-
-	"github.com/gorilla/sessions"
-	"github.com/joho/godotenv"
-	"github.com/labstack/echo-contrib/session"
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"xiazki/internal/webhook"
 )
 
 func main() {
-	if os.Getenv("APP_ENV") != "prod" {
-		if err := godotenv.Load(); err != nil {
-			log.Printf("Warning: .env file not found: %v", err)
-		}
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		log.Fatal(err)
 	}
 
-	database, err := database.InitDB()
+	database, err := database.InitDB(cfg.Database.URL)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer func() { _ = database.Close() }()
 
-	h := handler.NewHandler(database, os.Getenv("GOOGLE_BOOKS_API_KEY"))
-	e := echo.New()
+	if len(args) == 0 || args[0] != "migrate" {
+		if err := migrateOnStart(database, cfg.Database.AutoMigrate); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if len(args) > 0 {
+		if err := runCommand(cfg, database, args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
 
-	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
-		LogStatus: true,
-		LogURI:    true,
-		Skipper: func(c echo.Context) bool {
-			return c.Request().RequestURI == "/favicon.ico"
-		},
-		BeforeNextFunc: func(c echo.Context) {},
-		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
-			fmt.Printf("[%v %v: %v]\n", c.Request().Method, c.Request().RequestURI, v.Status)
-			return nil
-		},
-	}))
-	e.Use(middleware.Recover())
-	e.Use(middleware.Secure())
-	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
-		TokenLookup:    "header:X-CSRF-Token",
-		CookiePath:     "/",
-		CookieHTTPOnly: true,
-		CookieSecure:   os.Getenv("APP_ENV") == "prod",
-	}))
-	sessionSecret := os.Getenv("SESSION_SECRET")
-	if sessionSecret == "" {
-		log.Fatal("SESSION_SECRET environment variable is required")
+	if err := cfg.ValidateServer(); err != nil {
+		log.Fatal(err)
 	}
-	e.Use(session.Middleware(sessions.NewCookieStore([]byte(sessionSecret))))
 
-	e.Static("/static", "web/static")
-	e.File("/static/img/cover.jpeg", "assets/img/cover.jpeg")
+	go webhook.NewDispatcher(database, cfg.Webhooks).Run(context.Background())
 
-	e.GET("/login", h.GetLogin)
-	e.POST("/login", h.PostLogin)
-	e.GET("/register", h.GetRegister)
-	e.POST("/register", h.PostRegister)
+	h := handler.NewHandler(database, cfg)
+	e := newServer(cfg, h)
+	e.Logger.Debug(e.Start(cfg.Listen))
+}
 
-	protected := e.Group("")
-	protected.Use(h.RequireAuth)
-	protected.GET("/", h.GetBooks)
-	protected.GET("/books", h.GetBooks)
-	protected.GET("/author/:id", h.GetAuthor)
-	protected.GET("/add_book", h.GetAddBook)
-	protected.GET("/book/:id", h.GetBook)
-	protected.GET("/book/:id/opinions", h.GetBookOpinions)
-	protected.GET("/book/:id/edit", h.GetBookEdit)
-	protected.GET("/profile", h.GetProfile)
+// migrateOnStart applies pending migrations if auto is set and refuses to run
+// against an outdated database otherwise.
+func migrateOnStart(db *database.DB, auto bool) error {
+	ctx := context.Background()
 
-	protectedHX := protected.Group("")
-	protectedHX.Use(h.RequireAuthHTMX)
-	protectedHX.POST("/logout", h.PostLogout)
-	protectedHX.POST("/user/change_password", h.PostUserChangePassword)
-	protectedHX.POST("/add_book", h.PostAddBook)
-	protectedHX.GET("/add_book/autofill", h.GetAddBookAutofill)
-	protected.GET("/add_book/autofill/sse", h.GetAddBookAutofillSSE)
-	protectedHX.POST("/add_book/autofill/select", h.PostAddBookAutofillSelect)
-	protectedHX.DELETE("/book/:id", h.DeleteBook)
-	protectedHX.GET("/book/:id/stats", h.GetBookStats)
-	protectedHX.POST("/book/:id/rate", h.PostBookRate)
-	protectedHX.POST("/book/:id/review", h.PostBookReview)
-	protectedHX.PUT("/book/:id/edit", h.PutBookEdit)
-	protectedHX.GET("/book/:id/add_event", h.GetBookAddEvent)
-	protectedHX.POST("/book/:id/add_event", h.PostBookAddEvent)
-	protectedHX.DELETE("/event/:id", h.DeleteEvent)
+	if auto {
+		group, err := db.Migrate(ctx)
+		if err != nil {
+			return fmt.Errorf("migrate database: %w", err)
+		}
+		if !group.IsZero() {
+			log.Printf("Applied migrations %s", group.Migrations)
+		}
+		return nil
+	}
 
-	e.Logger.Debug(e.Start(":8080"))
+	ms, err := db.Migrations(ctx)
+	if err != nil {
+		return fmt.Errorf("check migrations: %w", err)
+	}
+	if pending := ms.Unapplied(); len(pending) > 0 {
+		return fmt.Errorf("database has pending migrations %s, run `xiazki migrate up`", pending)
+	}
+	return nil
 }
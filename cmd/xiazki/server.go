@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/internal/config"
+	"xiazki/internal/handler"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+// newServer sets up the middleware and routes of the application.
+func newServer(cfg *config.Config, h *handler.Handler) *echo.Echo {
+	e := echo.New()
+	if cfg.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+
+	e.Use(middleware.RequestLoggerWithConfig(middleware.RequestLoggerConfig{
+		LogStatus: true,
+		LogURI:    true,
+		Skipper: func(c echo.Context) bool {
+			return c.Request().RequestURI == "/favicon.ico"
+		},
+		BeforeNextFunc: func(c echo.Context) {},
+		LogValuesFunc: func(c echo.Context, v middleware.RequestLoggerValues) error {
+			fmt.Printf("[%v %v: %v]\n", c.Request().Method, c.Request().RequestURI, v.Status)
+			return nil
+		},
+	}))
+	e.Use(middleware.Recover())
+	e.Use(middleware.Secure())
+	e.Use(middleware.CSRFWithConfig(middleware.CSRFConfig{
+		// browsers do not send the Authorization header on their own, so
+		// requests with an API token cannot be forged
+		Skipper: func(c echo.Context) bool {
+			return strings.HasPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+		},
+		TokenLookup:    "header:X-CSRF-Token",
+		CookiePath:     cfg.CookiePath(),
+		CookieHTTPOnly: true,
+		CookieSecure:   cfg.Secure(),
+	}))
+	e.Use(session.Middleware(sessions.NewCookieStore([]byte(cfg.Session.Secret))))
+	e.Use(handler.BasePath(cfg.BasePath))
+
+	root := e.Group(cfg.BasePath)
+	root.Static("/static", "web/static")
+	root.File("/static/img/cover.jpeg", "assets/img/cover.jpeg")
+
+	root.GET("/setup", h.GetSetup)
+	root.POST("/setup", h.PostSetup)
+	root.GET("/login", h.GetLogin)
+	root.POST("/login", h.PostLogin)
+	root.GET("/login/totp", h.GetLoginTOTP)
+	root.POST("/login/totp", h.PostLoginTOTP)
+	root.POST("/logout", h.PostLogout)
+	root.GET("/login/oidc/:provider", h.GetOIDCLogin)
+	root.GET("/login/oidc/:provider/callback", h.GetOIDCCallback)
+	root.GET("/register", h.GetRegister)
+	root.POST("/register", h.PostRegister)
+
+	protected := root.Group("")
+	protected.Use(h.RequireAuth)
+	protected.GET("/", h.GetBooks)
+	protected.GET("/books", h.GetBooks)
+	protected.GET("/author/:id", h.GetAuthor)
+	protected.GET("/add_book", h.GetAddBook)
+	protected.GET("/book/:id", h.GetBook)
+	protected.GET("/book/:id/opinions", h.GetBookOpinions)
+	protected.GET("/book/:id/quotes", h.GetBookQuotes)
+	protected.GET("/book/:id/edit", h.GetBookEdit)
+	protected.GET("/quotes", h.GetQuotes)
+	protected.GET("/search", h.GetSearch)
+	protected.GET("/shelves", h.GetShelves)
+	protected.GET("/shelf/:id", h.GetShelf)
+	protected.GET("/profile", h.GetProfile)
+	protected.GET("/profile/export", h.GetProfileExport)
+	protected.GET("/import", h.GetImport)
+	protected.GET("/webhooks", h.GetWebhooks)
+	protected.GET("/webhooks/:id", h.GetWebhook)
+
+	protectedHX := protected.Group("")
+	protectedHX.Use(h.RequireAuthHTMX)
+	protectedHX.POST("/user/change_password", h.PostUserChangePassword)
+	protectedHX.DELETE("/profile/identities/:id", h.DeleteProfileIdentity)
+	protectedHX.DELETE("/profile/sessions", h.DeleteProfileSessions)
+	protectedHX.DELETE("/profile/sessions/:id", h.DeleteProfileSession)
+	protectedHX.POST("/profile/api_tokens", h.PostProfileAPIToken)
+	protectedHX.DELETE("/profile/api_tokens/:id", h.DeleteProfileAPIToken)
+	protectedHX.POST("/profile/totp", h.PostProfileTOTP)
+	protectedHX.POST("/profile/totp/confirm", h.PostProfileTOTPConfirm)
+	protectedHX.POST("/profile/totp/disable", h.PostProfileTOTPDisable)
+	protectedHX.POST("/import", h.PostImport)
+	protectedHX.POST("/add_book", h.PostAddBook)
+	protectedHX.GET("/add_book/autofill", h.GetAddBookAutofill)
+	protected.GET("/add_book/autofill/sse", h.GetAddBookAutofillSSE)
+	protectedHX.POST("/add_book/autofill/select", h.PostAddBookAutofillSelect)
+	protectedHX.GET("/book/:id/stats", h.GetBookStats)
+	protectedHX.POST("/book/:id/rate", h.PostBookRate)
+	protectedHX.POST("/book/:id/review", h.PostBookReview)
+	protectedHX.PUT("/book/:id/edit", h.PutBookEdit)
+	protectedHX.GET("/book/:id/add_event", h.GetBookAddEvent)
+	protectedHX.POST("/book/:id/add_event", h.PostBookAddEvent)
+	protectedHX.DELETE("/event/:id", h.DeleteEvent)
+	protectedHX.POST("/book/:id/quotes", h.PostBookQuote)
+	protectedHX.GET("/book/:id/quotes/:quote_id/edit", h.GetBookQuoteEdit)
+	protectedHX.PUT("/book/:id/quotes/:quote_id", h.PutBookQuote)
+	protectedHX.DELETE("/book/:id/quotes/:quote_id", h.DeleteBookQuote)
+	protectedHX.POST("/book/:id/library", h.PostBookLibrary)
+	protectedHX.PUT("/book/:id/library", h.PutBookLibrary)
+	protectedHX.DELETE("/book/:id/library", h.DeleteBookLibrary)
+	protectedHX.GET("/book/:id/shelves", h.GetBookShelves)
+	protectedHX.POST("/book/:id/shelves/:shelf_id", h.PostBookShelf)
+	protectedHX.DELETE("/book/:id/shelves/:shelf_id", h.DeleteBookShelf)
+	protectedHX.POST("/shelves", h.PostShelf)
+	protectedHX.PUT("/shelf/:id", h.PutShelf)
+	protectedHX.DELETE("/shelf/:id", h.DeleteShelf)
+	protectedHX.DELETE("/shelf/:id/books/:book_id", h.DeleteShelfBook)
+	protectedHX.POST("/shelf/:id/books/:book_id/move", h.PostShelfBookMove)
+	protectedHX.POST("/webhooks", h.PostWebhook)
+	protectedHX.DELETE("/webhooks/:id", h.DeleteWebhook)
+	protectedHX.POST("/webhooks/:id/ping", h.PostWebhookPing)
+	protectedHX.POST("/webhooks/:id/deliveries/:delivery_id/retry", h.PostWebhookRetry)
+
+	root.GET("/api/openapi.json", h.GetOpenAPI)
+	root.GET("/api/docs", h.GetAPIDocs)
+
+	v1 := root.Group("/api/" + api.Version)
+	v1.Use(handler.APIErrors, h.TokenAuth, h.RequireAuthAPI)
+	v1.GET("/books", h.GetAPIBooks)
+	v1.POST("/books", h.PostAPIBooks)
+	v1.GET("/books/:id", h.GetAPIBook)
+	v1.PUT("/books/:id", h.PutAPIBook)
+	v1.DELETE("/books/:id", h.DeleteAPIBook)
+	v1.GET("/books/:id/events", h.GetAPIBookEvents)
+	v1.POST("/books/:id/events", h.PostAPIBookEvents)
+	v1.GET("/books/:id/reviews", h.GetAPIBookReviews)
+	v1.GET("/books/:id/review", h.GetAPIBookReview)
+	v1.PUT("/books/:id/review", h.PutAPIBookReview)
+	v1.DELETE("/books/:id/review", h.DeleteAPIBookReview)
+	v1.GET("/books/:id/quotes", h.GetAPIBookQuotes)
+	v1.POST("/books/:id/quotes", h.PostAPIBookQuotes)
+	v1.GET("/books/:id/quotes/:quote_id", h.GetAPIBookQuote)
+	v1.PUT("/books/:id/quotes/:quote_id", h.PutAPIBookQuote)
+	v1.DELETE("/books/:id/quotes/:quote_id", h.DeleteAPIBookQuote)
+	v1.DELETE("/events/:id", h.DeleteAPIEvent)
+	v1.GET("/authors", h.GetAPIAuthors)
+	v1.GET("/authors/:id", h.GetAPIAuthor)
+	v1.GET("/tags", h.GetAPITags)
+	v1.GET("/quotes", h.GetAPIQuotes)
+	v1.GET("/search", h.GetAPISearch)
+
+	admin := protected.Group("/admin")
+	admin.Use(h.RequireAdmin)
+	admin.GET("", h.GetAdmin)
+
+	adminHX := protectedHX.Group("/admin")
+	adminHX.Use(h.RequireAdmin)
+	adminHX.POST("/registration", h.PostAdminRegistration)
+	adminHX.POST("/invites", h.PostAdminInvite)
+	adminHX.DELETE("/invites/:id", h.DeleteAdminInvite)
+	adminHX.PUT("/users/:id/role", h.PutAdminUserRole)
+	adminHX.POST("/users/:id/disabled", h.PostAdminUserDisabled)
+	adminHX.GET("/users/:id/password", h.GetAdminUserPassword)
+	adminHX.POST("/users/:id/password", h.PostAdminUserPassword)
+	adminHX.POST("/users/:id/totp/reset", h.PostAdminUserTOTPReset)
+	adminHX.DELETE("/books/:id", h.DeleteAdminBook)
+
+	return e
+}
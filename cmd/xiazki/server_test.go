@@ -0,0 +1,25 @@
+package main
+
+import (
+	"cmp"
+	"testing"
+
+	"xiazki/internal/api"
+	"xiazki/internal/config"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/handler"
+)
+
+func TestOpenAPIMatchesRoutes(t *testing.T) {
+	for _, basePath := range []string{"", "/xiazki"} {
+		t.Run(cmp.Or(basePath, "/"), func(t *testing.T) {
+			cfg := config.Default()
+			cfg.BasePath = basePath
+			e := newServer(cfg, handler.NewHandler(dbtest.New(t), cfg))
+
+			for _, p := range handler.CheckOpenAPI(e.Routes(), basePath+"/api/"+api.Version) {
+				t.Error(p)
+			}
+		})
+	}
+}
@@ -0,0 +1,177 @@
+// Package api holds the types of the JSON API served under /api/v1. They are
+// kept apart from the database models so that the API stays the same when
+// the models change, and so that nothing is sent which is not meant to be,
+// like the password hashes and secrets of users.
+package api
+
+import (
+	"time"
+
+	"xiazki/internal/model"
+)
+
+// Version is the version of the API, part of its path.
+const Version = "v1"
+
+// MaxPageSize is the largest page size lists may be asked for.
+const MaxPageSize = 100
+
+// DateFormat is the format of dates without a time.
+const DateFormat = "2006-01-02"
+
+// Error is the body of every error response.
+type Error struct {
+	Error ErrorDetail `json:"error"`
+}
+
+type ErrorDetail struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+	// Fields maps the invalid fields of a request body or query to what is
+	// wrong with them.
+	Fields map[string]string `json:"fields,omitempty"`
+}
+
+// Page is a page of a list. Next is the cursor to pass as after to get the
+// next page, it is left out on the last page.
+type Page[T any] struct {
+	Items []T   `json:"items"`
+	Next  int64 `json:"next,omitempty"`
+}
+
+// Name is an author, tag, translator or narrator.
+type Name struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+}
+
+type Book struct {
+	ID           int64  `json:"id"`
+	Title        string `json:"title"`
+	Authors      []Name `json:"authors"`
+	Tags         []Name `json:"tags"`
+	Translators  []Name `json:"translators"`
+	Narrators    []Name `json:"narrators"`
+	Summary      string `json:"summary,omitempty"`
+	ISBN10       string `json:"isbn10,omitempty"`
+	ISBN13       string `json:"isbn13,omitempty"`
+	Language     string `json:"language,omitempty"`
+	Publisher    string `json:"publisher,omitempty"`
+	PublishDate  string `json:"publish_date,omitempty" format:"date"`
+	PageCount    int64  `json:"page_count,omitempty"`
+	SeriesName   string `json:"series_name,omitempty"`
+	SeriesNumber int64  `json:"series_number,omitempty"`
+	CoverURL     string `json:"cover_url,omitempty"`
+	// Editable tells whether the user may change the book.
+	Editable  bool      `json:"editable"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Events are those of the user, they are only sent when loaded.
+	Events []Event `json:"events,omitempty"`
+}
+
+// BookInput is the body of requests adding or changing a book. Books are
+// changed as a whole, fields left out are cleared.
+type BookInput struct {
+	Title        string   `json:"title"`
+	Authors      []string `json:"authors"`
+	Tags         []string `json:"tags"`
+	Translators  []string `json:"translators"`
+	Narrators    []string `json:"narrators"`
+	Summary      string   `json:"summary"`
+	ISBN10       string   `json:"isbn10"`
+	ISBN13       string   `json:"isbn13"`
+	Language     string   `json:"language"`
+	Publisher    string   `json:"publisher"`
+	PublishDate  string   `json:"publish_date" format:"date"`
+	PageCount    int64    `json:"page_count"`
+	SeriesName   string   `json:"series_name"`
+	SeriesNumber int64    `json:"series_number"`
+	CoverURL     string   `json:"cover_url"`
+}
+
+type Author struct {
+	ID   int64  `json:"id"`
+	Name string `json:"name"`
+	// Books are only sent for a single author.
+	Books []BookSummary `json:"books,omitempty"`
+}
+
+// BookSummary is a book in a list of books of something else.
+type BookSummary struct {
+	ID    int64  `json:"id"`
+	Title string `json:"title"`
+}
+
+type Event struct {
+	ID        int64           `json:"id"`
+	BookID    int64           `json:"book_id"`
+	Type      model.EventType `json:"type"`
+	Date      string          `json:"date" format:"date"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type EventInput struct {
+	Type model.EventType `json:"type"`
+	Date string          `json:"date" format:"date"`
+}
+
+type Review struct {
+	ID       int64  `json:"id"`
+	BookID   int64  `json:"book_id"`
+	Username string `json:"username"`
+	// Rating is from 1 to 10, 0 if the book was not rated.
+	Rating    int64     `json:"rating,omitempty"`
+	Opinion   string    `json:"opinion,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type ReviewInput struct {
+	Rating  int64  `json:"rating"`
+	Opinion string `json:"opinion"`
+}
+
+type Quote struct {
+	ID        int64     `json:"id"`
+	BookID    int64     `json:"book_id"`
+	Quote     string    `json:"quote"`
+	Page      int64     `json:"page,omitempty"`
+	Note      string    `json:"note,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type QuoteInput struct {
+	Quote string `json:"quote"`
+	Page  int64  `json:"page"`
+	Note  string `json:"note"`
+}
+
+// SearchResults are the matches of a search, the snippets are plain text.
+type SearchResults struct {
+	Books   []SearchMatch `json:"books"`
+	Reviews []SearchMatch `json:"reviews"`
+	Quotes  []SearchMatch `json:"quotes"`
+}
+
+// SearchMatch is a matched book, review or quote. ID is the ID of the review
+// or quote, it is left out for books.
+type SearchMatch struct {
+	ID        int64  `json:"id,omitempty"`
+	BookID    int64  `json:"book_id"`
+	BookTitle string `json:"book_title"`
+	Username  string `json:"username,omitempty"`
+	Snippet   string `json:"snippet"`
+}
+
+// WebhookPayload is the body posted to webhooks. Book is left out of pings,
+// Event is set for event.created and Review for review.saved.
+type WebhookPayload struct {
+	Type      model.WebhookEvent `json:"type"`
+	CreatedAt time.Time          `json:"created_at"`
+	Username  string             `json:"username"`
+	Book      *BookSummary       `json:"book,omitempty"`
+	Event     *Event             `json:"event,omitempty"`
+	Review    *Review            `json:"review,omitempty"`
+}
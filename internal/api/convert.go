@@ -0,0 +1,108 @@
+package api
+
+import (
+	"strings"
+
+	"xiazki/internal/model"
+)
+
+// NewBook converts a book with its relations loaded, for user.
+func NewBook(b *model.Book, user *model.User) Book {
+	book := Book{
+		ID:           b.ID,
+		Title:        b.Title,
+		Authors:      names(b.Authors, func(a *model.Author) (int64, string) { return a.ID, a.Name }),
+		Tags:         names(b.Tags, func(t *model.Tag) (int64, string) { return t.ID, t.Name }),
+		Translators:  names(b.Translators, func(t *model.Translator) (int64, string) { return t.ID, t.Name }),
+		Narrators:    names(b.Narrators, func(n *model.Narrator) (int64, string) { return n.ID, n.Name }),
+		Summary:      b.Summary,
+		ISBN10:       b.ISBN10,
+		ISBN13:       b.ISBN13,
+		Language:     b.Language,
+		Publisher:    b.Publisher,
+		PageCount:    b.PageCount,
+		SeriesName:   b.SeriesName,
+		SeriesNumber: b.SeriesNumber,
+		CoverURL:     b.CoverURL,
+		Editable:     b.EditableBy(user),
+		CreatedAt:    b.CreatedAt,
+		UpdatedAt:    b.UpdatedAt,
+	}
+	if !b.PublishDate.IsZero() {
+		book.PublishDate = b.PublishDate.Format(DateFormat)
+	}
+	for _, e := range b.Events {
+		book.Events = append(book.Events, NewEvent(e))
+	}
+	return book
+}
+
+func names[T any](items []*T, name func(*T) (int64, string)) []Name {
+	list := make([]Name, 0, len(items))
+	for _, item := range items {
+		id, n := name(item)
+		list = append(list, Name{ID: id, Name: n})
+	}
+	return list
+}
+
+func NewEvent(e *model.Event) Event {
+	return Event{
+		ID:        e.ID,
+		BookID:    e.BookID,
+		Type:      e.Type,
+		Date:      e.Date.Format(DateFormat),
+		CreatedAt: e.CreatedAt,
+	}
+}
+
+// NewReview converts a review with its user loaded.
+func NewReview(r *model.Review) Review {
+	review := Review{
+		ID:        r.ID,
+		BookID:    r.BookID,
+		Rating:    r.Rating,
+		Opinion:   r.Opinion,
+		CreatedAt: r.CreatedAt,
+		UpdatedAt: r.UpdatedAt,
+	}
+	if r.User != nil {
+		review.Username = r.User.Username
+	}
+	return review
+}
+
+func NewQuote(q *model.Quote) Quote {
+	return Quote{
+		ID:        q.ID,
+		BookID:    q.BookID,
+		Quote:     q.Quote,
+		Page:      q.Page,
+		Note:      q.Note,
+		CreatedAt: q.CreatedAt,
+		UpdatedAt: q.UpdatedAt,
+	}
+}
+
+func NewSearchResults(r *model.SearchResults) SearchResults {
+	results := SearchResults{
+		Books:   make([]SearchMatch, 0, len(r.Books)),
+		Reviews: make([]SearchMatch, 0, len(r.Reviews)),
+		Quotes:  make([]SearchMatch, 0, len(r.Quotes)),
+	}
+	for _, m := range r.Books {
+		results.Books = append(results.Books, SearchMatch{BookID: m.BookID, BookTitle: plainSnippet(m.Title), Snippet: plainSnippet(m.Snippet)})
+	}
+	for _, m := range r.Reviews {
+		results.Reviews = append(results.Reviews, SearchMatch{ID: m.ReviewID, BookID: m.BookID, BookTitle: m.BookTitle, Username: m.Username, Snippet: plainSnippet(m.Snippet)})
+	}
+	for _, m := range r.Quotes {
+		results.Quotes = append(results.Quotes, SearchMatch{ID: m.QuoteID, BookID: m.BookID, BookTitle: m.BookTitle, Snippet: plainSnippet(m.Snippet)})
+	}
+	return results
+}
+
+// plainSnippet removes the marks around the matched terms.
+func plainSnippet(s string) string {
+	return strings.NewReplacer(model.SnippetStart, "", model.SnippetEnd, "").Replace(s)
+}
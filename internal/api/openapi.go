@@ -0,0 +1,366 @@
+package api
+
+import (
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"xiazki/internal/model"
+)
+
+// OpenAPIVersion is the version of the OpenAPI specification the document
+// follows.
+const OpenAPIVersion = "3.0.3"
+
+// Document is an OpenAPI document, only with the parts the API needs.
+type Document struct {
+	OpenAPI    string                `json:"openapi"`
+	Info       Info                  `json:"info"`
+	Servers    []Server              `json:"servers"`
+	Paths      map[string]PathItem   `json:"paths"`
+	Components Components            `json:"components"`
+	Security   []map[string][]string `json:"security"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type Server struct {
+	URL string `json:"url"`
+}
+
+// PathItem maps the lower case HTTP methods of a path to their operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationID string              `json:"operationId"`
+	Summary     string              `json:"summary"`
+	Tags        []string            `json:"tags"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Minimum              *int               `json:"minimum,omitempty"`
+	Maximum              *int               `json:"maximum,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type        string `json:"type"`
+	Scheme      string `json:"scheme,omitempty"`
+	In          string `json:"in,omitempty"`
+	Name        string `json:"name,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// Endpoint describes an operation of the API, from which the document is
+// generated. Path uses the echo syntax for parameters, like /books/:id.
+type Endpoint struct {
+	Method  string
+	Path    string
+	Summary string
+	Tag     string
+	// Query is a struct whose query tags are the query parameters.
+	Query any
+	// Paged endpoints take the after and limit parameters and respond with a
+	// Page of Response.
+	Paged bool
+	// Body is the request body, nil if there is none.
+	Body any
+	// Status is the status of a successful response, Response its body or nil
+	// if there is none.
+	Status   int
+	Response any
+	// Errors are the statuses of errors specific to the operation, besides
+	// those every operation may respond with.
+	Errors []int
+}
+
+var paramPattern = regexp.MustCompile(`:(\w+)`)
+
+// OpenAPIPath converts an echo route path to an OpenAPI path.
+func OpenAPIPath(path string) string {
+	return paramPattern.ReplaceAllString(path, "{$1}")
+}
+
+// enums are the named string types whose values are known.
+var enums = map[reflect.Type][]string{
+	reflect.TypeFor[model.EventType](): enumValues(model.EventTypes),
+	reflect.TypeFor[model.BookSort]():  enumValues(model.BookSorts),
+}
+
+func enumValues[T ~string](values []T) []string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		list = append(list, string(v))
+	}
+	return list
+}
+
+// queryEnums are the query parameters whose values are known although they
+// are plain strings.
+var queryEnums = map[string][]string{
+	"status": enumValues(model.EventTypes),
+	"sort":   enumValues(model.BookSorts),
+}
+
+// statusDescriptions are the descriptions of the responses by status.
+var statusDescriptions = map[int]string{
+	200: "OK",
+	201: "Created",
+	204: "No content",
+	400: "The request is malformed",
+	401: "Not authenticated",
+	403: "Not allowed",
+	404: "Not found",
+	409: "Conflicts with the current state",
+	422: "Invalid input, the fields tell what is wrong",
+	500: "Internal server error",
+}
+
+// commonErrors are the statuses every operation may respond with.
+var commonErrors = []int{401, 500}
+
+// NewDocument generates the document of the endpoints, which are served under
+// server.
+func NewDocument(server string, endpoints []Endpoint) *Document {
+	g := generator{schemas: make(map[string]*Schema)}
+	doc := &Document{
+		OpenAPI: OpenAPIVersion,
+		Info: Info{
+			Title:       "xiazki API",
+			Version:     Version,
+			Description: "Lists are paged, pass the next cursor of a page as after to get the following one.",
+		},
+		Servers: []Server{{URL: server}},
+		Paths:   make(map[string]PathItem),
+		Components: Components{
+			Schemas: g.schemas,
+			SecuritySchemes: map[string]SecurityScheme{
+				"token": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "A personal API token created on the profile page.",
+				},
+				"session": {
+					Type:        "apiKey",
+					In:          "cookie",
+					Name:        "session",
+					Description: "The session of the web interface, changes need the X-CSRF-Token header.",
+				},
+			},
+		},
+		Security: []map[string][]string{{"token": {}}, {"session": {}}},
+	}
+	g.schema(reflect.TypeFor[Error]())
+
+	for _, e := range endpoints {
+		path := OpenAPIPath(e.Path)
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(PathItem)
+		}
+		doc.Paths[path][strings.ToLower(e.Method)] = g.operation(e)
+	}
+	return doc
+}
+
+type generator struct {
+	schemas map[string]*Schema
+}
+
+func (g *generator) operation(e Endpoint) *Operation {
+	op := &Operation{
+		OperationID: operationID(e.Method, e.Path),
+		Summary:     e.Summary,
+		Tags:        []string{e.Tag},
+		Responses:   make(map[string]Response),
+	}
+
+	for _, m := range paramPattern.FindAllStringSubmatch(e.Path, -1) {
+		op.Parameters = append(op.Parameters, Parameter{
+			Name:     m[1],
+			In:       "path",
+			Required: true,
+			Schema:   &Schema{Type: "integer", Format: "int64"},
+		})
+	}
+	if e.Query != nil {
+		t := reflect.TypeOf(e.Query)
+		for i := range t.NumField() {
+			name := t.Field(i).Tag.Get("query")
+			if name == "" {
+				continue
+			}
+			op.Parameters = append(op.Parameters, Parameter{
+				Name:   name,
+				In:     "query",
+				Schema: &Schema{Type: "string", Enum: queryEnums[name]},
+			})
+		}
+	}
+	if e.Paged {
+		one, maxLimit := 1, MaxPageSize
+		op.Parameters = append(op.Parameters,
+			Parameter{Name: "after", In: "query", Schema: &Schema{Type: "integer", Format: "int64", Minimum: &one}},
+			Parameter{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: &one, Maximum: &maxLimit}},
+		)
+	}
+
+	if e.Body != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{"application/json": {Schema: g.schema(reflect.TypeOf(e.Body))}},
+		}
+	}
+
+	success := Response{Description: statusDescriptions[e.Status]}
+	if e.Response != nil {
+		schema := g.schema(reflect.TypeOf(e.Response))
+		if e.Paged {
+			schema = &Schema{
+				Type: "object",
+				Properties: map[string]*Schema{
+					"items": {Type: "array", Items: schema},
+					"next":  {Type: "integer", Format: "int64"},
+				},
+				Required: []string{"items"},
+			}
+		}
+		success.Content = map[string]MediaType{"application/json": {Schema: schema}}
+	}
+	op.Responses[statusKey(e.Status)] = success
+
+	errorBody := map[string]MediaType{"application/json": {Schema: &Schema{Ref: "#/components/schemas/Error"}}}
+	statuses := slices.Concat(e.Errors, commonErrors)
+	if e.Body != nil || e.Query != nil || e.Paged {
+		statuses = append(statuses, 422)
+	}
+	if e.Body != nil || paramPattern.MatchString(e.Path) {
+		statuses = append(statuses, 400)
+	}
+	for _, status := range statuses {
+		op.Responses[statusKey(status)] = Response{Description: statusDescriptions[status], Content: errorBody}
+	}
+	return op
+}
+
+// schema returns the schema of t, structs are added to the components and
+// referenced.
+func (g *generator) schema(t reflect.Type) *Schema {
+	if values, ok := enums[t]; ok {
+		return &Schema{Type: "string", Enum: values}
+	}
+	if t == reflect.TypeFor[time.Time]() {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return g.schema(t.Elem())
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Slice:
+		return &Schema{Type: "array", Items: g.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schema(t.Elem())}
+	case reflect.Struct:
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			// the placeholder ends the recursion of types containing themselves
+			g.schemas[name] = nil
+			g.schemas[name] = g.object(t)
+		}
+		return &Schema{Ref: "#/components/schemas/" + name}
+	}
+	panic("openapi: unsupported type " + t.String())
+}
+
+// object returns the schema of the fields of a struct, those without
+// omitempty are required. The format tag sets the format of a field.
+func (g *generator) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	for i := range t.NumField() {
+		f := t.Field(i)
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" {
+			continue
+		} else if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = g.schema(f.Type)
+		if format := f.Tag.Get("format"); format != "" {
+			s.Properties[name].Format = format
+		}
+		if !slices.Contains(strings.Split(opts, ","), "omitempty") {
+			s.Required = append(s.Required, name)
+		}
+	}
+	return s
+}
+
+// operationID derives a unique name of an operation, like getBooksEvents for
+// GET /books/:id/events.
+func operationID(method, path string) string {
+	id := strings.ToLower(method)
+	for part := range strings.SplitSeq(path, "/") {
+		if part == "" || strings.HasPrefix(part, ":") {
+			continue
+		}
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	if strings.HasSuffix(path, "/:id") || strings.HasSuffix(path, "/:quote_id") {
+		id += "ByID"
+	}
+	return id
+}
+
+func statusKey(status int) string {
+	return strconv.Itoa(status)
+}
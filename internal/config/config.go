@@ -0,0 +1,423 @@
+// Package config loads the settings of xiazki. Every setting has a default
+// which can be overridden by a TOML file, then by environment variables and
+// finally by command line flags.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+)
+
+type Config struct {
+	// Env is "dev" or "prod", cookies are only sent over HTTPS in prod.
+	Env string `toml:"env"`
+	// Listen is the address the server listens on.
+	Listen string `toml:"listen"`
+	// BasePath is the path prefix xiazki is served under, e.g. "/xiazki",
+	// empty when it is served from the root.
+	BasePath string `toml:"base_path"`
+	// PublicURL is the URL xiazki is reached at, including the base path,
+	// e.g. "https://example.com/xiazki". It is used for the redirects of login
+	// providers and the links of invites, and taken from the request when
+	// empty.
+	PublicURL string `toml:"public_url"`
+	// TrustProxy takes the client IP address from the X-Forwarded-For header
+	// set by a reverse proxy on a private network.
+	TrustProxy bool `toml:"trust_proxy"`
+
+	Database Database `toml:"database"`
+	Session  Session  `toml:"session"`
+	Auth     Auth     `toml:"auth"`
+	Metadata Metadata `toml:"metadata"`
+	Webhooks Webhooks `toml:"webhooks"`
+}
+
+type Database struct {
+	// URL is a SQLite file path or a postgres:// URL, see database.InitDB.
+	URL         string `toml:"url"`
+	AutoMigrate bool   `toml:"auto_migrate"`
+}
+
+type Session struct {
+	Secret string        `toml:"secret"`
+	MaxAge time.Duration `toml:"max_age"`
+}
+
+type Auth struct {
+	// PasswordLogin allows signing in and registering with a password stored
+	// by xiazki.
+	PasswordLogin bool `toml:"password_login"`
+	// MaxFailures is how many failed logins with a username lock it out for
+	// Lockout, see login.Throttle.
+	MaxFailures int            `toml:"max_failures"`
+	Lockout     time.Duration  `toml:"lockout"`
+	LDAP        LDAP           `toml:"ldap"`
+	OIDC        []OIDCProvider `toml:"oidc"`
+}
+
+// LDAP is a directory users sign in to with the login form. It is used if URL
+// is set.
+type LDAP struct {
+	// URL is an ldap:// or ldaps:// URL, StartTLS upgrades ldap:// connections.
+	URL      string `toml:"url"`
+	StartTLS bool   `toml:"start_tls"`
+	// BindDN and BindPassword are the account users are searched with, the
+	// search is anonymous when BindDN is empty.
+	BindDN       string `toml:"bind_dn"`
+	BindPassword string `toml:"bind_password"`
+	BaseDN       string `toml:"base_dn"`
+	// UserFilter finds the entry of a user, {username} is replaced with the
+	// escaped username.
+	UserFilter        string `toml:"user_filter"`
+	UsernameAttribute string `toml:"username_attribute"`
+	// GroupAttribute lists the DNs of the groups of a user.
+	GroupAttribute string `toml:"group_attribute"`
+	// UserGroups restricts signing in to the members of these groups and of
+	// AdminGroups if not empty.
+	UserGroups []string `toml:"user_groups"`
+	// AdminGroups makes their members admins and everyone else users if not
+	// empty, roles are updated on every login then.
+	AdminGroups []string `toml:"admin_groups"`
+	// AutoProvision and LinkByUsername are like those of OIDCProvider.
+	AutoProvision  bool `toml:"auto_provision"`
+	LinkByUsername bool `toml:"link_by_username"`
+}
+
+// Enabled reports whether the directory is configured.
+func (l LDAP) Enabled() bool {
+	return l.URL != ""
+}
+
+// OIDCProvider is an OpenID Connect provider users can sign in with. Users
+// are linked to the subject of their identity at the provider.
+type OIDCProvider struct {
+	// Name identifies the provider in URLs and linked identities, so it must
+	// not change once users signed in with it.
+	Name         string   `toml:"name"`
+	DisplayName  string   `toml:"display_name"`
+	Issuer       string   `toml:"issuer"`
+	ClientID     string   `toml:"client_id"`
+	ClientSecret string   `toml:"client_secret"`
+	Scopes       []string `toml:"scopes"`
+	// UsernameClaim is the ID token claim naming the user.
+	UsernameClaim string `toml:"username_claim"`
+	// AutoProvision creates accounts for identities which are not linked to
+	// a user yet.
+	AutoProvision bool `toml:"auto_provision"`
+	// LinkByUsername links identities which are not linked to a user yet to
+	// the user with the same name. Only enable it for providers which control
+	// the usernames of their users.
+	LinkByUsername bool `toml:"link_by_username"`
+}
+
+// SecretEnv is the environment variable overriding the client secret.
+func (p OIDCProvider) SecretEnv() string {
+	return "OIDC_" + strings.ToUpper(strings.ReplaceAll(p.Name, "-", "_")) + "_CLIENT_SECRET"
+}
+
+type Metadata struct {
+	GoogleBooksAPIKey string        `toml:"google_books_api_key"`
+	Timeout           time.Duration `toml:"timeout"`
+}
+
+type Webhooks struct {
+	// Timeout limits each delivery attempt.
+	Timeout time.Duration `toml:"timeout"`
+	// AllowPrivate lets webhooks post to loopback and private addresses, which
+	// are refused by default so that users cannot reach the internal network
+	// of the server.
+	AllowPrivate bool `toml:"allow_private"`
+}
+
+func Default() *Config {
+	return &Config{
+		Env:    "dev",
+		Listen: ":8080",
+		Database: Database{
+			AutoMigrate: true,
+		},
+		Session: Session{
+			MaxAge: 7 * 24 * time.Hour,
+		},
+		Auth: Auth{
+			PasswordLogin: true,
+			MaxFailures:   10,
+			Lockout:       15 * time.Minute,
+			LDAP: LDAP{
+				UserFilter:        "(uid={username})",
+				UsernameAttribute: "uid",
+				GroupAttribute:    "memberOf",
+				AutoProvision:     true,
+			},
+		},
+		Metadata: Metadata{
+			Timeout: 10 * time.Second,
+		},
+		Webhooks: Webhooks{
+			Timeout: 10 * time.Second,
+		},
+	}
+}
+
+// Load builds the configuration from the command line arguments, without the
+// program name, and returns it together with the arguments left after the
+// flags. The file is read from the -config flag or XIAZKI_CONFIG if set. In
+// dev, variables from a .env file are loaded into the environment first.
+func Load(args []string) (*Config, []string, error) {
+	if os.Getenv("APP_ENV") != "prod" {
+		if err := godotenv.Load(); err != nil {
+			log.Printf("Warning: .env file not found: %v", err)
+		}
+	}
+
+	cfg := Default()
+
+	fs := flag.NewFlagSet("xiazki", flag.ContinueOnError)
+	path := fs.String("config", os.Getenv("XIAZKI_CONFIG"), "path to a TOML configuration file")
+	flags := map[string]func(string) error{}
+	for _, s := range settings(cfg) {
+		if s.flag != "" {
+			fs.String(s.flag, "", s.usage)
+			flags[s.flag] = s.set
+		}
+	}
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: xiazki [flags] [import|export|search|migrate|openapi ...]\n\nflags:\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	if *path != "" {
+		if _, err := toml.DecodeFile(*path, cfg); err != nil {
+			return nil, nil, fmt.Errorf("config file: %w", err)
+		}
+	}
+
+	for _, s := range settings(cfg) {
+		if value, ok := os.LookupEnv(s.env); ok && s.env != "" {
+			if err := s.set(value); err != nil {
+				return nil, nil, fmt.Errorf("%s: %w", s.env, err)
+			}
+		}
+	}
+
+	for i := range cfg.Auth.OIDC {
+		p := &cfg.Auth.OIDC[i]
+		if p.DisplayName == "" {
+			p.DisplayName = p.Name
+		}
+		if len(p.Scopes) == 0 {
+			p.Scopes = []string{"openid", "profile", "email"}
+		}
+		if p.UsernameClaim == "" {
+			p.UsernameClaim = "preferred_username"
+		}
+		if secret, ok := os.LookupEnv(p.SecretEnv()); ok {
+			p.ClientSecret = secret
+		}
+	}
+
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if set, ok := flags[f.Name]; ok && err == nil {
+			if e := set(f.Value.String()); e != nil {
+				err = fmt.Errorf("-%s: %w", f.Name, e)
+			}
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return cfg, fs.Args(), cfg.Validate()
+}
+
+// Validate checks the settings needed by every command, see also
+// ValidateServer.
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Env != "dev" && c.Env != "prod" {
+		errs = append(errs, fmt.Errorf("env must be dev or prod, not %q", c.Env))
+	}
+	if c.BasePath != "" && (!strings.HasPrefix(c.BasePath, "/") || strings.HasSuffix(c.BasePath, "/")) {
+		errs = append(errs, fmt.Errorf("base path must start and must not end with a slash, not %q", c.BasePath))
+	}
+	if u, err := url.Parse(c.PublicURL); c.PublicURL != "" &&
+		(err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" || strings.HasSuffix(c.PublicURL, "/")) {
+		errs = append(errs, fmt.Errorf("public URL must be an http(s) URL without a trailing slash, not %q", c.PublicURL))
+	}
+	if c.Session.MaxAge <= 0 {
+		errs = append(errs, errors.New("session max age must be positive"))
+	}
+	if c.Auth.MaxFailures <= 0 {
+		errs = append(errs, errors.New("login max failures must be positive"))
+	}
+	if c.Auth.Lockout <= 0 {
+		errs = append(errs, errors.New("login lockout must be positive"))
+	}
+	if c.Metadata.Timeout <= 0 {
+		errs = append(errs, errors.New("metadata timeout must be positive"))
+	}
+	if c.Webhooks.Timeout <= 0 {
+		errs = append(errs, errors.New("webhook timeout must be positive"))
+	}
+	return errors.Join(errs...)
+}
+
+// placeholderSecrets are session secrets from examples, which anyone could
+// sign sessions with.
+var placeholderSecrets = []string{"change-me", "changeme", "secret", "session-secret"}
+
+// ValidateServer checks the settings needed to run the web server.
+func (c *Config) ValidateServer() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen address is required"))
+	}
+	if c.Session.Secret == "" {
+		errs = append(errs, errors.New("session secret is required, set SESSION_SECRET"))
+	} else if c.Env == "prod" && slices.Contains(placeholderSecrets, c.Session.Secret) {
+		errs = append(errs, errors.New("session secret is a placeholder, set SESSION_SECRET to a long random string"))
+	}
+	if !c.Auth.PasswordLogin && !c.Auth.LDAP.Enabled() && len(c.Auth.OIDC) == 0 {
+		errs = append(errs, errors.New("password login can only be disabled with LDAP or an OIDC provider"))
+	}
+	if l := c.Auth.LDAP; l.Enabled() {
+		if u, err := url.Parse(l.URL); err != nil || u.Scheme != "ldap" && u.Scheme != "ldaps" {
+			errs = append(errs, fmt.Errorf("LDAP URL must start with ldap:// or ldaps://, not %q", l.URL))
+		}
+		if l.BaseDN == "" {
+			errs = append(errs, errors.New("LDAP base DN is required"))
+		}
+		if !strings.Contains(l.UserFilter, "{username}") {
+			errs = append(errs, errors.New("LDAP user filter must contain {username}"))
+		}
+	}
+	names := map[string]bool{}
+	for _, p := range c.Auth.OIDC {
+		if !validProviderName(p.Name) {
+			errs = append(errs, fmt.Errorf("OIDC provider name must consist of a-z, 0-9 and -, not %q", p.Name))
+		} else if names[p.Name] {
+			errs = append(errs, fmt.Errorf("OIDC provider %q is configured twice", p.Name))
+		}
+		names[p.Name] = true
+		if p.Issuer == "" || p.ClientID == "" {
+			errs = append(errs, fmt.Errorf("OIDC provider %q needs an issuer and a client ID", p.Name))
+		}
+		if !slices.Contains(p.Scopes, "openid") {
+			errs = append(errs, fmt.Errorf("OIDC provider %q must request the openid scope", p.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func validProviderName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// Secure reports whether cookies should only be sent over HTTPS.
+func (c *Config) Secure() bool {
+	return c.Env == "prod"
+}
+
+// CookiePath is the path cookies are scoped to.
+func (c *Config) CookiePath() string {
+	if c.BasePath == "" {
+		return "/"
+	}
+	return c.BasePath
+}
+
+// setting is a value which can be set from the environment and, if flag is
+// not empty, from the command line. Secrets have no flags, as the command line
+// is visible to other users of the machine.
+type setting struct {
+	env   string
+	flag  string
+	usage string
+	set   func(string) error
+}
+
+func settings(c *Config) []setting {
+	return []setting{
+		{"APP_ENV", "env", "dev or prod", str(&c.Env)},
+		{"LISTEN_ADDR", "listen", "address to listen on (default :8080)", str(&c.Listen)},
+		{"BASE_PATH", "base-path", "path prefix to serve under, e.g. /xiazki", str(&c.BasePath)},
+		{"PUBLIC_URL", "public-url", "URL xiazki is reached at, e.g. https://example.com/xiazki", str(&c.PublicURL)},
+		{"TRUST_PROXY", "trust-proxy", "take client addresses from X-Forwarded-For of a reverse proxy (default false)", boolean(&c.TrustProxy)},
+		{"DATABASE_URL", "database-url", "SQLite file or postgres:// URL (default xiazki.db)", str(&c.Database.URL)},
+		{"AUTO_MIGRATE", "auto-migrate", "apply pending migrations on start (default true)", boolean(&c.Database.AutoMigrate)},
+		{"SESSION_SECRET", "", "", str(&c.Session.Secret)},
+		{"SESSION_MAX_AGE", "session-max-age", "how long sessions last (default 168h)", duration(&c.Session.MaxAge)},
+		{"LOGIN_MAX_FAILURES", "login-max-failures", "failed logins locking a username out (default 10)", integer(&c.Auth.MaxFailures)},
+		{"LOGIN_LOCKOUT", "login-lockout", "how long usernames are locked out (default 15m)", duration(&c.Auth.Lockout)},
+		{"LDAP_URL", "ldap-url", "ldap:// or ldaps:// URL of the directory to sign in with", str(&c.Auth.LDAP.URL)},
+		{"LDAP_BIND_PASSWORD", "", "", str(&c.Auth.LDAP.BindPassword)},
+		{"PASSWORD_LOGIN", "password-login", "allow signing in with a password (default true)", boolean(&c.Auth.PasswordLogin)},
+		{"GOOGLE_BOOKS_API_KEY", "", "", str(&c.Metadata.GoogleBooksAPIKey)},
+		{"METADATA_TIMEOUT", "metadata-timeout", "timeout of metadata provider requests (default 10s)", duration(&c.Metadata.Timeout)},
+		{"WEBHOOK_TIMEOUT", "webhook-timeout", "timeout of webhook deliveries (default 10s)", duration(&c.Webhooks.Timeout)},
+		{"WEBHOOK_ALLOW_PRIVATE", "webhook-allow-private", "allow webhooks to private and loopback addresses (default false)", boolean(&c.Webhooks.AllowPrivate)},
+	}
+}
+
+func str(p *string) func(string) error {
+	return func(s string) error {
+		*p = s
+		return nil
+	}
+}
+
+func boolean(p *bool) func(string) error {
+	return func(s string) error {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", s)
+		}
+		*p = b
+		return nil
+	}
+}
+
+func integer(p *int) func(string) error {
+	return func(s string) error {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", s)
+		}
+		*p = n
+		return nil
+	}
+}
+
+func duration(p *time.Duration) func(string) error {
+	return func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q", s)
+		}
+		*p = d
+		return nil
+	}
+}
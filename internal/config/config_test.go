@@ -0,0 +1,22 @@
+package config
+
+import "testing"
+
+func TestValidateServerSecret(t *testing.T) {
+	for _, tt := range []struct {
+		env, secret string
+		ok          bool
+	}{
+		{"prod", "", false},
+		{"prod", "change-me", false},
+		{"prod", "3f9a0c41d2b87e65", true},
+		{"dev", "", false},
+		{"dev", "change-me", true},
+	} {
+		c := Default()
+		c.Env, c.Session.Secret = tt.env, tt.secret
+		if err := c.ValidateServer(); (err == nil) != tt.ok {
+			t.Errorf("%s with secret %q: err = %v, want ok = %v", tt.env, tt.secret, err, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+func (db *DB) InsertAPIToken(ctx context.Context, token *model.APIToken) error {
+	_, err := db.NewInsert().Model(token).Exec(ctx)
+	return err
+}
+
+// APITokenByToken returns the unexpired API token of a token, sql.ErrNoRows
+// is returned if there is none.
+func (db *DB) APITokenByToken(ctx context.Context, token string) (*model.APIToken, error) {
+	var t model.APIToken
+	err := db.NewSelect().
+		Model(&t).
+		Where("token_hash = ?", model.HashAPIToken(token)).
+		WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("expires_at IS NULL").WhereOr("expires_at > ?", time.Now())
+		}).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// TouchAPIToken records that a token was used. Like sessions, only uses after
+// interval since the last recorded one are written.
+func (db *DB) TouchAPIToken(ctx context.Context, token *model.APIToken, interval time.Duration) error {
+	now := time.Now()
+	if now.Sub(token.LastUsedAt) < interval {
+		return nil
+	}
+
+	_, err := db.NewUpdate().
+		Model((*model.APIToken)(nil)).
+		Set("last_used_at = ?", now).
+		Where("id = ?", token.ID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	token.LastUsedAt = now
+	return nil
+}
+
+// UserAPITokens returns the API tokens of a user, the newest first. Expired
+// tokens are included so that users see why they stopped working.
+func (db *DB) UserAPITokens(ctx context.Context, userID uuid.UUID) ([]*model.APIToken, error) {
+	var tokens []*model.APIToken
+	err := db.NewSelect().
+		Model(&tokens).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Scan(ctx)
+	return tokens, err
+}
+
+// DeleteAPIToken revokes an API token of a user, sql.ErrNoRows is returned if
+// the user has no such token.
+func (db *DB) DeleteAPIToken(ctx context.Context, userID uuid.UUID, id int64) error {
+	res, err := db.NewDelete().
+		Model((*model.APIToken)(nil)).
+		Where("id = ? AND user_id = ?", id, userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
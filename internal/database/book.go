@@ -2,19 +2,18 @@ package database
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"reflect"
 	"time"
 
 	"xiazki/internal/model"
 
-	"github.com/labstack/echo/v4"
 	"github.com/uptrace/bun"
 )
 
-func (db *DB) InsertBook(c echo.Context, book *model.Book) error {
-	ctx := c.Request().Context()
-
+func (db *DB) InsertBook(ctx context.Context, book *model.Book) error {
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		if _, err := tx.NewInsert().Model(book).Exec(ctx); err != nil {
 			return err
@@ -26,8 +25,10 @@ func (db *DB) InsertBook(c echo.Context, book *model.Book) error {
 			return err
 		} else if err := insertBookRelation(ctx, tx, book.ID, book.Narrators, newBookNarrator); err != nil {
 			return err
+		} else if err := reindex(ctx, tx, booksIndex, book.ID); err != nil {
+			return err
 		}
-		return nil
+		return queueWebhooks(ctx, tx, &model.WebhookOutbox{Type: model.WebhookBookCreated, UserID: book.AddedByID, BookID: book.ID})
 	})
 }
 
@@ -49,16 +50,14 @@ func insertBookRelation[T any](ctx context.Context, tx bun.Tx, bookID int64, ite
 	return nil
 }
 
-func (db *DB) UpdateBook(c echo.Context, id int64, book *model.Book) error {
-	ctx := c.Request().Context()
-
+func (db *DB) UpdateBook(ctx context.Context, id int64, book *model.Book) error {
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		book.ID = id
 		book.UpdatedAt = time.Now()
 
 		_, err := tx.NewUpdate().
 			Model(book).
-			ExcludeColumn("created_at").
+			ExcludeColumn("created_at", "added_by_id").
 			WherePK().
 			Exec(ctx)
 		if err != nil {
@@ -75,6 +74,60 @@ func (db *DB) UpdateBook(c echo.Context, id int64, book *model.Book) error {
 			return fmt.Errorf("update relationships: %w", err)
 		}
 
+		if err := reindex(ctx, tx, booksIndex, book.ID); err != nil {
+			return fmt.Errorf("update search index: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// DeleteBook deletes a book from the catalog with everything referring to it:
+// its names, the libraries and shelves it is in, and the events, reviews and
+// quotes of all users. sql.ErrNoRows is returned if there is no such book.
+func (db *DB) DeleteBook(ctx context.Context, id int64) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.Book)(nil)).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+
+		// reviews and quotes are indexed by their own ids
+		var reviewIDs, quoteIDs []int64
+		if err := tx.NewSelect().Model((*model.Review)(nil)).Column("id").Where("book_id = ?", id).Scan(ctx, &reviewIDs); err != nil {
+			return err
+		}
+		if err := tx.NewSelect().Model((*model.Quote)(nil)).Column("id").Where("book_id = ?", id).Scan(ctx, &quoteIDs); err != nil {
+			return err
+		}
+
+		for _, table := range []any{
+			(*model.BookAuthor)(nil), (*model.BookTag)(nil), (*model.BookTranslator)(nil), (*model.BookNarrator)(nil),
+			(*model.UserBook)(nil), (*model.ShelfBook)(nil), (*model.Event)(nil), (*model.Review)(nil), (*model.Quote)(nil),
+		} {
+			if _, err := tx.NewDelete().Model(table).Where("book_id = ?", id).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		if err := reindex(ctx, tx, booksIndex, id); err != nil {
+			return err
+		}
+		for _, reviewID := range reviewIDs {
+			if err := reindex(ctx, tx, reviewsIndex, reviewID); err != nil {
+				return err
+			}
+		}
+		for _, quoteID := range quoteIDs {
+			if err := reindex(ctx, tx, quotesIndex, quoteID); err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 }
@@ -106,3 +159,46 @@ func newBookTranslator(bookID, id int64) any {
 func newBookNarrator(bookID, id int64) any {
 	return &model.BookNarrator{BookID: bookID, NarratorID: id}
 }
+
+// FindDuplicateBook looks for a book already in the catalog which is the same
+// as the given one. Books are matched by ISBN first and by title together with
+// the first author otherwise. sql.ErrNoRows is returned if there is no match.
+func (db *DB) FindDuplicateBook(ctx context.Context, book *model.Book) (*model.Book, error) {
+	var existing model.Book
+
+	if book.ISBN13 != "" || book.ISBN10 != "" {
+		err := db.conn(ctx).NewSelect().
+			Model(&existing).
+			WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				if book.ISBN13 != "" {
+					q = q.WhereOr("isbn13 = ?", book.ISBN13)
+				}
+				if book.ISBN10 != "" {
+					q = q.WhereOr("isbn10 = ?", book.ISBN10)
+				}
+				return q
+			}).
+			Limit(1).
+			Scan(ctx)
+		if err == nil || !errors.Is(err, sql.ErrNoRows) {
+			return &existing, err
+		}
+	}
+
+	if len(book.Authors) == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	err := db.conn(ctx).NewSelect().
+		Model(&existing).
+		Join("JOIN book_authors AS ba ON ba.book_id = book.id").
+		Join("JOIN authors AS a ON a.id = ba.author_id").
+		Where("LOWER(book.title) = LOWER(?)", book.Title).
+		Where("LOWER(a.name) = LOWER(?)", book.Authors[0].Name).
+		Limit(1).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
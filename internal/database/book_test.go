@@ -0,0 +1,230 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+// addBook inserts a book added by user and puts it in the library of user.
+func addBook(t *testing.T, db *database.DB, user *model.User, book *model.Book) *model.Book {
+	t.Helper()
+
+	ctx := context.Background()
+	book.AddedByID = user.ID
+	if err := db.InsertBook(ctx, book); err != nil {
+		t.Fatalf("insert book %q: %v", book.Title, err)
+	}
+	if err := db.AddLibraryBook(ctx, user.ID, book.ID); err != nil {
+		t.Fatalf("add book %q to library: %v", book.Title, err)
+	}
+	return book
+}
+
+func bookTitles(books []*model.Book) []string {
+	titles := make([]string, len(books))
+	for i, b := range books {
+		titles[i] = b.Title
+	}
+	return titles
+}
+
+func TestInsertAndUpdateBook(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+
+		b := addBook(t, db, alice, &model.Book{
+			Title:   "Dune",
+			Authors: []*model.Author{{Name: "Frank Herbert"}},
+			Tags:    []*model.Tag{{Name: "sf"}, {Name: "classic"}},
+		})
+		// the author exists now and must be reused
+		other := addBook(t, db, alice, &model.Book{
+			Title:   "Children of Dune",
+			Authors: []*model.Author{{Name: "Frank Herbert"}},
+		})
+		if b.Authors[0].ID != other.Authors[0].ID {
+			t.Errorf("author inserted twice: ids %d and %d", b.Authors[0].ID, other.Authors[0].ID)
+		}
+
+		err := db.UpdateBook(ctx, b.ID, &model.Book{
+			Title: "Dune (1965)",
+			Tags:  []*model.Tag{{Name: "sf"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got model.Book
+		err = db.NewSelect().Model(&got).Relation("Authors").Relation("Tags").Where("book.id = ?", b.ID).Scan(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != "Dune (1965)" {
+			t.Errorf("title = %q", got.Title)
+		}
+		if got.AddedByID != alice.ID {
+			t.Errorf("added_by_id = %v, want %v", got.AddedByID, alice.ID)
+		}
+		if len(got.Authors) != 0 {
+			t.Errorf("authors = %d, want none", len(got.Authors))
+		}
+		if len(got.Tags) != 1 || got.Tags[0].Name != "sf" {
+			t.Errorf("tags = %v, want [sf]", got.Tags)
+		}
+
+		if dup, err := db.FindDuplicateBook(ctx, &model.Book{Title: "children of dune", Authors: []*model.Author{{Name: "Frank Herbert"}}}); err != nil {
+			t.Fatal(err)
+		} else if dup == nil || dup.ID != other.ID {
+			t.Errorf("duplicate = %v, want book %d", dup, other.ID)
+		}
+
+		if err := db.DeleteBook(ctx, b.ID); err != nil {
+			t.Fatal(err)
+		}
+		if exists, err := db.NewSelect().Model((*model.Book)(nil)).Where("id = ?", b.ID).Exists(ctx); err != nil {
+			t.Fatal(err)
+		} else if exists {
+			t.Error("book still exists after delete")
+		}
+	})
+}
+
+func TestListBooks(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		bob := dbtest.User(t, db, "bob", model.RoleUser)
+
+		date := func(year int) time.Time { return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC) }
+		addBook(t, db, alice, &model.Book{Title: "Dune", PublishDate: date(1965), PageCount: 412, Authors: []*model.Author{{Name: "Frank Herbert"}}})
+		addBook(t, db, alice, &model.Book{Title: "anathem", PublishDate: date(2008), PageCount: 937, Authors: []*model.Author{{Name: "Neal Stephenson"}}})
+		addBook(t, db, alice, &model.Book{Title: "Solaris", PublishDate: date(1961), PageCount: 204, Authors: []*model.Author{{Name: "Stanisław Lem"}}})
+		addBook(t, db, bob, &model.Book{Title: "Emma"})
+
+		books, next, err := db.ListBooks(ctx, alice.ID, model.BookFilter{}, model.SortTitle, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := bookTitles(books), []string{"anathem", "Dune", "Solaris"}; !slices.Equal(got, want) {
+			t.Errorf("by title = %q, want %q", got, want)
+		}
+		if next != 0 {
+			t.Errorf("next = %d on the last page", next)
+		}
+
+		// page through by publish date, newest first
+		var titles []string
+		var after int64
+		for {
+			books, next, err := db.ListBooks(ctx, alice.ID, model.BookFilter{}, model.SortPublished, after, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			titles = append(titles, bookTitles(books)...)
+			if next == 0 {
+				break
+			}
+			after = next
+		}
+		if want := []string{"anathem", "Dune", "Solaris"}; !slices.Equal(titles, want) {
+			t.Errorf("by publish date = %q, want %q", titles, want)
+		}
+
+		books, _, err = db.ListBooks(ctx, alice.ID, model.BookFilter{Author: "lem", PagesMax: 300}, model.SortAdded, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := bookTitles(books), []string{"Solaris"}; !slices.Equal(got, want) {
+			t.Errorf("filtered = %q, want %q", got, want)
+		}
+
+		books, _, err = db.ListBooks(ctx, bob.ID, model.BookFilter{}, model.SortAdded, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := bookTitles(books), []string{"Emma"}; !slices.Equal(got, want) {
+			t.Errorf("library of bob = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestDeleteBook(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		bob := dbtest.User(t, db, "bob", model.RoleUser)
+
+		dune := addBook(t, db, alice, &model.Book{Title: "Dune", Authors: []*model.Author{{Name: "Frank Herbert"}}, Tags: []*model.Tag{{Name: "sf"}}})
+		emma := addBook(t, db, alice, &model.Book{Title: "Emma"})
+		for _, user := range []*model.User{alice, bob} {
+			if err := db.InsertEvent(ctx, dune, user, &model.Event{Type: model.EventReading, Date: time.Now()}); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.InsertOrUpdateReview(ctx, &model.Review{UserID: user.ID, BookID: dune.ID, Rating: 8, Opinion: "spice"}, true, true); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.InsertQuote(ctx, &model.Quote{UserID: user.ID, BookID: dune.ID, Quote: "Fear is the mind-killer."}); err != nil {
+				t.Fatal(err)
+			}
+			shelf := &model.Shelf{UserID: user.ID, Name: "favourites"}
+			if err := db.InsertShelf(ctx, shelf); err != nil {
+				t.Fatal(err)
+			}
+			if err := db.AddShelfBook(ctx, shelf.ID, dune.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// removing the book from a library leaves it to the others
+		if err := db.RemoveLibraryBook(ctx, bob.ID, dune.ID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.LibraryBook(ctx, alice.ID, dune.ID); err != nil {
+			t.Errorf("book left the library of alice: %v", err)
+		}
+
+		if err := db.DeleteBook(ctx, dune.ID); err != nil {
+			t.Fatal(err)
+		}
+		for _, table := range []any{
+			(*model.Book)(nil), (*model.BookAuthor)(nil), (*model.BookTag)(nil), (*model.UserBook)(nil),
+			(*model.ShelfBook)(nil), (*model.Event)(nil), (*model.Review)(nil), (*model.Quote)(nil),
+		} {
+			q := db.NewSelect().Model(table)
+			if _, ok := table.(*model.Book); ok {
+				q = q.Where("id = ?", dune.ID)
+			} else {
+				q = q.Where("book_id = ?", dune.ID)
+			}
+			if n, err := q.Count(ctx); err != nil {
+				t.Fatal(err)
+			} else if n != 0 {
+				t.Errorf("%T: %d rows left of the deleted book", table, n)
+			}
+		}
+
+		results, err := db.Search(ctx, alice.ID, "spice", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results.Reviews) != 0 {
+			t.Errorf("reviews of the deleted book found: %+v", results.Reviews)
+		}
+
+		if _, err := db.LibraryBook(ctx, alice.ID, emma.ID); err != nil {
+			t.Errorf("other book left the library: %v", err)
+		}
+		if err := db.DeleteBook(ctx, dune.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("deleting again: err = %v, want sql.ErrNoRows", err)
+		}
+	})
+}
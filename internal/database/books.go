@@ -0,0 +1,121 @@
+package database
+
+import (
+	"context"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// bookSortKey returns the expression books are ordered by, followed by their
+// id. The expression never evaluates to NULL, so that it can be compared when
+// paginating.
+func bookSortKey(sort model.BookSort, userID uuid.UUID) (expr string, args []any, desc bool) {
+	switch sort {
+	case model.SortTitle:
+		return "LOWER(book.title)", nil, false
+	case model.SortAuthor:
+		return "COALESCE((SELECT MIN(LOWER(a.name)) FROM book_authors AS ba JOIN authors AS a ON a.id = ba.author_id WHERE ba.book_id = book.id), '')", nil, false
+	case model.SortPublished:
+		return "COALESCE(book.publish_date, '0001-01-01')", nil, true
+	case model.SortRating:
+		return "COALESCE((SELECT r.rating FROM reviews AS r WHERE r.book_id = book.id AND r.user_id = ?), 0)", []any{userID}, true
+	case model.SortLastRead:
+		return "COALESCE((SELECT MAX(e.date) FROM events AS e WHERE e.book_id = book.id AND e.user_id = ? AND e.type != ?), '0001-01-01')", []any{userID, model.EventToRead}, true
+	default:
+		return "COALESCE((SELECT ub.created_at FROM user_books AS ub WHERE ub.book_id = book.id AND ub.user_id = ?), book.created_at)", []any{userID}, true
+	}
+}
+
+// ListBooks returns a page of at most limit books matching the filter, in the
+// given order. The page starts right after the book with id after, or at the
+// beginning if it is 0. The returned cursor is the id to pass as after to get
+// the next page, it is 0 on the last page.
+//
+// Only books in the library of userID are listed, and only the events of
+// userID are loaded with them.
+func (db *DB) ListBooks(ctx context.Context, userID uuid.UUID, filter model.BookFilter, sort model.BookSort, after int64, limit int) ([]*model.Book, int64, error) {
+	key, keyArgs, desc := bookSortKey(sort, userID)
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	var books []*model.Book
+	q := db.NewSelect().
+		Model(&books).
+		Relation("Authors").
+		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.
+				Where("user_id = ?", userID).
+				OrderExpr("CASE WHEN type = ? THEN 3 WHEN type = ? THEN 2 WHEN type = ? THEN 1 ELSE 0 END ASC", model.EventFinished, model.EventDropped, model.EventReading).
+				OrderExpr("date ASC")
+		}).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return WhereBookFilter(WhereInLibrary(q, userID), userID, filter)
+		})
+
+	if after != 0 {
+		cursor := db.NewSelect().
+			TableExpr("books AS book").
+			ColumnExpr(key, keyArgs...).
+			Where("book.id = ?", after)
+		args := append(append(append([]any{}, keyArgs...), cursor), keyArgs...)
+		args = append(args, cursor, after)
+		q = q.Where("("+key+" "+cmp+" (?) OR ("+key+" = (?) AND book.id "+cmp+" ?))", args...)
+	}
+
+	err := q.
+		OrderExpr(key+" "+dir, keyArgs...).
+		OrderExpr("book.id " + dir).
+		Limit(limit + 1).
+		Scan(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var next int64
+	if len(books) > limit {
+		books = books[:limit]
+		next = books[limit-1].ID
+	}
+	return books, next, nil
+}
+
+// LoadBookNames loads the tags, translators and narrators of books, which
+// ListBooks leaves out.
+func (db *DB) LoadBookNames(ctx context.Context, books []*model.Book) error {
+	if len(books) == 0 {
+		return nil
+	}
+	ids := make([]int64, len(books))
+	for i, b := range books {
+		ids[i] = b.ID
+	}
+
+	var loaded []*model.Book
+	err := db.NewSelect().
+		Model(&loaded).
+		Column("book.id").
+		Relation("Tags").
+		Relation("Translators").
+		Relation("Narrators").
+		Where("book.id IN (?)", bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		return err
+	}
+
+	byID := make(map[int64]*model.Book, len(loaded))
+	for _, b := range loaded {
+		byID[b.ID] = b
+	}
+	for _, b := range books {
+		if l, ok := byID[b.ID]; ok {
+			b.Tags, b.Translators, b.Narrators = l.Tags, l.Translators, l.Narrators
+		}
+	}
+	return nil
+}
@@ -4,40 +4,44 @@ import (
 	"context"
 	"database/sql"
 	"log"
+	"strings"
 
 	"xiazki/internal/model"
 
 	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
 	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/driver/pgdriver"
 	"github.com/uptrace/bun/driver/sqliteshim"
 )
 
+const defaultSQLite = "file:xiazki.db?cache=shared"
+
 type DB struct {
 	*bun.DB
 }
 
-func InitDB() (*DB, error) {
-	sqldb, err := sql.Open(sqliteshim.ShimName, "file:xiazki.db?cache=shared")
-	if err != nil {
-		return nil, err
+// InitDB opens the database at url. PostgreSQL is used for postgres:// and
+// postgresql:// URLs, anything else is an SQLite database: either a file path,
+// optionally prefixed with sqlite://, or a file: URI. An empty url opens
+// xiazki.db in the working directory.
+func InitDB(url string) (*DB, error) {
+	var db *bun.DB
+
+	if strings.HasPrefix(url, "postgres://") || strings.HasPrefix(url, "postgresql://") {
+		sqldb := sql.OpenDB(pgdriver.NewConnector(pgdriver.WithDSN(url)))
+		db = bun.NewDB(sqldb, pgdialect.New())
+	} else {
+		sqldb, err := sql.Open(sqliteshim.ShimName, sqliteDSN(url))
+		if err != nil {
+			return nil, err
+		}
+		db = bun.NewDB(sqldb, sqlitedialect.New())
 	}
 
-	db := bun.NewDB(sqldb, sqlitedialect.New())
-
-	models := []any{
-		(*model.User)(nil),
-		(*model.Book)(nil),
-		(*model.Author)(nil),
-		(*model.Tag)(nil),
-		(*model.Translator)(nil),
-		(*model.Narrator)(nil),
-		(*model.Review)(nil),
-		(*model.Event)(nil),
-		(*model.Quote)(nil),
-		(*model.BookAuthor)(nil),
-		(*model.BookTag)(nil),
-		(*model.BookTranslator)(nil),
-		(*model.BookNarrator)(nil),
+	if err := db.Ping(); err != nil {
+		return nil, err
 	}
 
 	db.RegisterModel(
@@ -45,16 +49,48 @@ func InitDB() (*DB, error) {
 		(*model.BookTag)(nil),
 		(*model.BookTranslator)(nil),
 		(*model.BookNarrator)(nil),
+		(*model.ShelfBook)(nil),
 	)
 
-	ctx := context.Background()
-	for _, model := range models {
-		_, err = db.NewCreateTable().Model(model).IfNotExists().Exec(ctx)
-		if err != nil {
-			return nil, err
-		}
-	}
-
 	log.Println("Database initialized successfully")
 	return &DB{db}, nil
 }
+
+type txKey struct{}
+
+// RunInTx runs fn in a transaction. Calls made within fn join that
+// transaction rather than starting their own, so a caller can make several
+// changes through the methods of DB at once.
+func (db *DB) RunInTx(ctx context.Context, opts *sql.TxOptions, fn func(ctx context.Context, tx bun.Tx) error) error {
+	if tx, ok := ctx.Value(txKey{}).(bun.Tx); ok {
+		return fn(ctx, tx)
+	}
+	return db.DB.RunInTx(ctx, opts, func(ctx context.Context, tx bun.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx), tx)
+	})
+}
+
+// conn returns the transaction RunInTx runs the call in, or the database
+// outside of one.
+func (db *DB) conn(ctx context.Context) bun.IDB {
+	if tx, ok := ctx.Value(txKey{}).(bun.Tx); ok {
+		return tx
+	}
+	return db.DB
+}
+
+func sqliteDSN(url string) string {
+	switch {
+	case url == "":
+		return defaultSQLite
+	case strings.HasPrefix(url, "file:"):
+		return url
+	default:
+		path := strings.TrimPrefix(strings.TrimPrefix(url, "sqlite://"), "sqlite:")
+		return "file:" + path + "?cache=shared"
+	}
+}
+
+func (db *DB) isSQLite() bool {
+	return db.Dialect().Name() == dialect.SQLite
+}
@@ -0,0 +1,136 @@
+// Package dbtest provides migrated databases for tests. SQLite databases are
+// created in a temporary directory, PostgreSQL is only tested when
+// XIAZKI_TEST_POSTGRES_URL points to a database the tests may create schemas
+// in. Every test gets a schema of its own, which is dropped afterwards. With
+// the variable set, the tests using New run on PostgreSQL only, and those
+// using Each on both.
+package dbtest
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+)
+
+// PostgresEnv is the environment variable with the URL of the PostgreSQL
+// database to test against.
+const PostgresEnv = "XIAZKI_TEST_POSTGRES_URL"
+
+const (
+	SQLite   = "sqlite"
+	Postgres = "postgres"
+)
+
+// Dialects are the database dialects tests run on.
+var Dialects = []string{SQLite, Postgres}
+
+// Open returns a migrated database of the dialect, which is closed when the
+// test ends. The test is skipped if the dialect is not available.
+func Open(t testing.TB, dialect string) *database.DB {
+	t.Helper()
+
+	var dsn string
+	switch dialect {
+	case SQLite:
+		dsn = filepath.Join(t.TempDir(), "xiazki.db")
+	case Postgres:
+		dsn = postgresSchema(t)
+	default:
+		t.Fatalf("unknown dialect %q", dialect)
+	}
+
+	db, err := database.InitDB(dsn)
+	if err != nil {
+		t.Fatalf("open %s database: %v", dialect, err)
+	}
+	t.Cleanup(func() { _ = db.Close() })
+
+	if _, err := db.Migrate(context.Background()); err != nil {
+		t.Fatalf("migrate %s database: %v", dialect, err)
+	}
+	return db
+}
+
+// New returns a migrated database for tests which do not compare the
+// dialects: PostgreSQL if PostgresEnv is set, SQLite otherwise.
+func New(t testing.TB) *database.DB {
+	t.Helper()
+	if os.Getenv(PostgresEnv) != "" {
+		return Open(t, Postgres)
+	}
+	return Open(t, SQLite)
+}
+
+// Each runs fn as a subtest on a fresh database of every dialect.
+func Each(t *testing.T, fn func(t *testing.T, db *database.DB)) {
+	for _, dialect := range Dialects {
+		t.Run(dialect, func(t *testing.T) {
+			fn(t, Open(t, dialect))
+		})
+	}
+}
+
+// User inserts a user with the role, whose password is the username followed
+// by "-password".
+func User(t testing.TB, db *database.DB, username string, role model.Role) *model.User {
+	t.Helper()
+
+	user := &model.User{ID: uuid.New(), Username: username, Role: role}
+	if err := user.SetPassword(username + "-password"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertUser(context.Background(), user, ""); err != nil {
+		t.Fatalf("insert user %s: %v", username, err)
+	}
+	return user
+}
+
+// postgresSchema creates a schema in the database of PostgresEnv and returns
+// the URL of the database with the schema as search path.
+func postgresSchema(t testing.TB) string {
+	t.Helper()
+
+	base := os.Getenv(PostgresEnv)
+	if base == "" {
+		t.Skipf("%s is not set", PostgresEnv)
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("parse %s: %v", PostgresEnv, err)
+	}
+
+	admin, err := database.InitDB(base)
+	if err != nil {
+		t.Fatalf("open postgres database: %v", err)
+	}
+
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	schema := "test_" + hex.EncodeToString(b)
+
+	ctx := context.Background()
+	if _, err := admin.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		_ = admin.Close()
+		t.Fatalf("create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if _, err := admin.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("drop schema %s: %v", schema, err)
+		}
+		_ = admin.Close()
+	})
+
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+	return u.String()
+}
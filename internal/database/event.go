@@ -2,14 +2,20 @@ package database
 
 import (
 	"context"
-	"database/sql"
 	"errors"
+	"fmt"
+	"slices"
 
 	"xiazki/internal/model"
 
+	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
+// ErrEventConflict is returned when an event contradicts the events of the
+// book the user already has.
+var ErrEventConflict = errors.New("event conflicts with existing events")
+
 func (db *DB) InsertEvent(ctx context.Context, book *model.Book, user *model.User, event *model.Event) error {
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		event.UserID = user.ID
@@ -18,16 +24,15 @@ func (db *DB) InsertEvent(ctx context.Context, book *model.Book, user *model.Use
 		switch event.Type {
 		case model.EventFinished, model.EventDropped:
 			// Reject inserting a "finished" or "dropped" event if there is a later "reading" event
-			err := tx.NewSelect().
+			exists, err := tx.NewSelect().
 				Model((*model.Event)(nil)).
 				Where("user_id = ? AND book_id = ? AND type = ? AND date > ?", event.UserID, event.BookID, model.EventReading, event.Date).
-				Limit(1).
-				Scan(ctx)
+				Exists(ctx)
 
-			if err == nil {
-				return errors.New("cannot insert finished/dropped event: reading event exists with later date")
-			} else if !errors.Is(err, sql.ErrNoRows) {
+			if err != nil {
 				return err
+			} else if exists {
+				return fmt.Errorf("%w: cannot insert finished/dropped event: reading event exists with later date", ErrEventConflict)
 			}
 
 			// Delete existing "finished" or "dropped" events for this book and user
@@ -40,16 +45,15 @@ func (db *DB) InsertEvent(ctx context.Context, book *model.Book, user *model.Use
 			}
 		case model.EventReading:
 			// Reject inserting a "reading" event if there is an earlier "finished" or "dropped" event
-			err := tx.NewSelect().
+			exists, err := tx.NewSelect().
 				Model((*model.Event)(nil)).
 				Where("user_id = ? AND book_id = ? AND type IN (?,?) AND date < ?", event.UserID, event.BookID, model.EventFinished, model.EventDropped, event.Date).
-				Limit(1).
-				Scan(ctx)
+				Exists(ctx)
 
-			if err == nil {
-				return errors.New("cannot insert reading event: finished/dropped event exists with earlier date")
-			} else if !errors.Is(err, sql.ErrNoRows) {
+			if err != nil {
 				return err
+			} else if exists {
+				return fmt.Errorf("%w: cannot insert reading event: finished/dropped event exists with earlier date", ErrEventConflict)
 			}
 
 			// Delete existing "reading" events for this book and user
@@ -60,12 +64,65 @@ func (db *DB) InsertEvent(ctx context.Context, book *model.Book, user *model.Use
 			if err != nil {
 				return err
 			}
+		case model.EventToRead:
+			// Reject inserting a "to-read" event if it is already superseded by a "reading" event
+			exists, err := tx.NewSelect().
+				Model((*model.Event)(nil)).
+				Where("user_id = ? AND book_id = ? AND type = ? AND date >= ?", event.UserID, event.BookID, model.EventReading, event.Date).
+				Exists(ctx)
+
+			if err != nil {
+				return err
+			} else if exists {
+				return fmt.Errorf("%w: cannot insert to-read event: reading event exists with later date", ErrEventConflict)
+			}
+
+			// Delete existing "to-read" events for this book and user
+			_, err = tx.NewDelete().
+				Model((*model.Event)(nil)).
+				Where("user_id = ? AND book_id = ? AND type = ?", event.UserID, event.BookID, model.EventToRead).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
 		default:
 			return errors.New("unknown event type")
 		}
 
-		// Insert the new event
-		_, err := tx.NewInsert().Model(event).Exec(ctx)
-		return err
+		if event.Type != model.EventToRead {
+			// Any other event supersedes "to-read" events up to its date
+			_, err := tx.NewDelete().
+				Model((*model.Event)(nil)).
+				Where("user_id = ? AND book_id = ? AND type = ? AND date <= ?", event.UserID, event.BookID, model.EventToRead, event.Date).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Insert the new event, the book belongs to the library of whoever reads it
+		if _, err := tx.NewInsert().Model(event).Exec(ctx); err != nil {
+			return err
+		} else if err := addLibraryBook(ctx, tx, event.UserID, event.BookID); err != nil {
+			return err
+		}
+
+		return queueWebhooks(ctx, tx, &model.WebhookOutbox{
+			Type:    model.WebhookEventCreated,
+			UserID:  event.UserID,
+			BookID:  event.BookID,
+			EventID: event.ID,
+		})
 	})
 }
+
+// WhereEventStatus limits a query on books to those whose current state for
+// the user is status, that is which have an event of that type and none of a
+// more conclusive type.
+func WhereEventStatus(q *bun.SelectQuery, userID uuid.UUID, status model.EventType) *bun.SelectQuery {
+	q = q.Where("EXISTS (SELECT 1 FROM events AS e WHERE e.book_id = book.id AND e.user_id = ? AND e.type = ?)", userID, status)
+	if i := slices.Index(model.EventTypes, status); i != -1 && i < len(model.EventTypes)-1 {
+		q = q.Where("NOT EXISTS (SELECT 1 FROM events AS e WHERE e.book_id = book.id AND e.user_id = ? AND e.type IN (?))", userID, bun.In(model.EventTypes[i+1:]))
+	}
+	return q
+}
@@ -0,0 +1,120 @@
+package database_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+
+	"github.com/uptrace/bun"
+)
+
+func TestInsertEvent(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		b := addBook(t, db, alice, &model.Book{Title: "Dune"})
+
+		day := func(d int) time.Time { return time.Date(2024, 3, d, 0, 0, 0, 0, time.UTC) }
+		insert := func(typ model.EventType, d int) error {
+			return db.InsertEvent(ctx, b, alice, &model.Event{Type: typ, Date: day(d)})
+		}
+		types := func() []string {
+			var events []*model.Event
+			if err := db.NewSelect().Model(&events).Where("book_id = ? AND user_id = ?", b.ID, alice.ID).Order("date", "id").Scan(ctx); err != nil {
+				t.Fatal(err)
+			}
+			types := make([]string, len(events))
+			for i, e := range events {
+				types[i] = string(e.Type)
+			}
+			return types
+		}
+
+		if err := insert(model.EventToRead, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := insert(model.EventReading, 5); err != nil {
+			t.Fatal(err)
+		}
+		// reading supersedes the earlier to-read
+		if got, want := types(), []string{"reading"}; !slices.Equal(got, want) {
+			t.Errorf("events = %q, want %q", got, want)
+		}
+
+		if err := insert(model.EventFinished, 3); !errors.Is(err, database.ErrEventConflict) {
+			t.Errorf("finished before reading: err = %v, want ErrEventConflict", err)
+		}
+		if err := insert(model.EventToRead, 2); !errors.Is(err, database.ErrEventConflict) {
+			t.Errorf("to-read before reading: err = %v, want ErrEventConflict", err)
+		}
+
+		if err := insert(model.EventDropped, 9); err != nil {
+			t.Fatal(err)
+		}
+		// finished replaces dropped
+		if err := insert(model.EventFinished, 10); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := types(), []string{"reading", "finished"}; !slices.Equal(got, want) {
+			t.Errorf("events = %q, want %q", got, want)
+		}
+
+		if err := insert(model.EventReading, 12); !errors.Is(err, database.ErrEventConflict) {
+			t.Errorf("reading after finished: err = %v, want ErrEventConflict", err)
+		}
+	})
+}
+
+func TestWhereEventStatus(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		bob := dbtest.User(t, db, "bob", model.RoleUser)
+
+		now := time.Now()
+		event := func(user *model.User, b *model.Book, typ model.EventType) {
+			t.Helper()
+			if err := db.InsertEvent(ctx, b, user, &model.Event{Type: typ, Date: now}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		reading := addBook(t, db, alice, &model.Book{Title: "Reading"})
+		finished := addBook(t, db, alice, &model.Book{Title: "Finished"})
+		addBook(t, db, alice, &model.Book{Title: "Unread"})
+		event(alice, reading, model.EventReading)
+		event(alice, finished, model.EventReading)
+		event(alice, finished, model.EventFinished)
+		// the events of other users do not count
+		event(bob, reading, model.EventFinished)
+
+		for _, tt := range []struct {
+			status model.EventType
+			want   []string
+		}{
+			{model.EventReading, []string{"Reading"}},
+			{model.EventFinished, []string{"Finished"}},
+			{model.EventToRead, nil},
+		} {
+			var books []*model.Book
+			err := db.NewSelect().
+				Model(&books).
+				Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+					return database.WhereEventStatus(q, alice.ID, tt.status)
+				}).
+				Order("book.id").
+				Scan(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := bookTitles(books); !slices.Equal(got, tt.want) {
+				t.Errorf("%s = %q, want %q", tt.status, got, tt.want)
+			}
+		}
+	})
+}
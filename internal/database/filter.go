@@ -0,0 +1,87 @@
+package database
+
+import (
+	"strings"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// EscapeLike escapes the LIKE wildcards in s, the pattern has to be used with
+// ESCAPE '\'.
+func EscapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// Contains returns a LIKE pattern matching values containing s. LIKE is case
+// sensitive in PostgreSQL, so the pattern is lower case and has to be matched
+// against LOWER(column).
+func Contains(s string) string {
+	return "%" + EscapeLike(strings.ToLower(s)) + "%"
+}
+
+// WhereBookFilter adds the conditions of the filter to a query selecting from
+// books aliased as book. Text fields match case-insensitively anywhere in the
+// value.
+func WhereBookFilter(q *bun.SelectQuery, userID uuid.UUID, f model.BookFilter) *bun.SelectQuery {
+	if f.Title != "" {
+		q = q.Where("LOWER(book.title) LIKE ? ESCAPE '\\'", Contains(f.Title))
+	}
+	if f.Publisher != "" {
+		q = q.Where("LOWER(book.publisher) LIKE ? ESCAPE '\\'", Contains(f.Publisher))
+	}
+	if f.Language != "" {
+		q = q.Where("LOWER(book.language) LIKE ? ESCAPE '\\'", Contains(f.Language))
+	}
+	if f.Series != "" {
+		q = q.Where("LOWER(book.series_name) LIKE ? ESCAPE '\\'", Contains(f.Series))
+	}
+	if f.ISBN != "" {
+		isbn := strings.NewReplacer("-", "", " ", "").Replace(f.ISBN)
+		q = q.Where("(book.isbn10 = ? OR book.isbn13 = ?)", isbn, isbn)
+	}
+
+	for _, rel := range []struct {
+		value, table, link, column string
+	}{
+		{f.Author, "authors", "book_authors", "author_id"},
+		{f.Tag, "tags", "book_tags", "tag_id"},
+		{f.Translator, "translators", "book_translators", "translator_id"},
+		{f.Narrator, "narrators", "book_narrators", "narrator_id"},
+	} {
+		if rel.value == "" {
+			continue
+		}
+		q = q.Where(
+			"EXISTS (SELECT 1 FROM ? AS l JOIN ? AS t ON t.id = l.? WHERE l.book_id = book.id AND LOWER(t.name) LIKE ? ESCAPE '\\')",
+			bun.Ident(rel.link), bun.Ident(rel.table), bun.Ident(rel.column), Contains(rel.value),
+		)
+	}
+
+	if f.PagesMin != 0 {
+		q = q.Where("book.page_count >= ?", f.PagesMin)
+	}
+	if f.PagesMax != 0 {
+		q = q.Where("book.page_count <= ?", f.PagesMax)
+	}
+	if !f.PublishedFrom.IsZero() {
+		q = q.Where("book.publish_date >= ?", f.PublishedFrom)
+	}
+	if !f.PublishedTo.IsZero() {
+		q = q.Where("book.publish_date <= ?", f.PublishedTo)
+	}
+
+	if f.Status != "" {
+		q = WhereEventStatus(q, userID, f.Status)
+	}
+	if f.RatingMin != 0 {
+		q = q.Where("EXISTS (SELECT 1 FROM reviews AS r WHERE r.book_id = book.id AND r.user_id = ? AND r.rating >= ?)", userID, f.RatingMin)
+	}
+	if f.RatingMax != 0 {
+		q = q.Where("EXISTS (SELECT 1 FROM reviews AS r WHERE r.book_id = book.id AND r.user_id = ? AND r.rating <= ?)", userID, f.RatingMax)
+	}
+
+	return q
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+var (
+	// ErrIdentityLinked is returned when an identity is linked to a user
+	// while it belongs to another one.
+	ErrIdentityLinked = errors.New("identity is linked to another user")
+	// ErrLastIdentity is returned when unlinking the only identity a user
+	// can sign in with.
+	ErrLastIdentity = errors.New("the last identity cannot be unlinked")
+)
+
+// IdentityUser returns the user the identity is linked to, sql.ErrNoRows is
+// returned if it is not linked.
+func (db *DB) IdentityUser(ctx context.Context, provider, subject string) (*model.User, error) {
+	var identity model.Identity
+	err := db.NewSelect().
+		Model(&identity).
+		Relation("User").
+		Where("identity.provider = ?", provider).
+		Where("identity.subject = ?", subject).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return identity.User, nil
+}
+
+// Identities returns the identities linked to a user.
+func (db *DB) Identities(ctx context.Context, userID uuid.UUID) ([]*model.Identity, error) {
+	var identities []*model.Identity
+	err := db.NewSelect().
+		Model(&identities).
+		Where("user_id = ?", userID).
+		Order("provider", "created_at").
+		Scan(ctx)
+	return identities, err
+}
+
+// LinkIdentity links an identity to a user, linking it again to the same user
+// does nothing.
+func (db *DB) LinkIdentity(ctx context.Context, identity *model.Identity) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return linkIdentity(ctx, tx, identity)
+	})
+}
+
+func linkIdentity(ctx context.Context, tx bun.Tx, identity *model.Identity) error {
+	var linked model.Identity
+	err := tx.NewSelect().
+		Model(&linked).
+		Where("provider = ?", identity.Provider).
+		Where("subject = ?", identity.Subject).
+		Scan(ctx)
+	if err == nil {
+		if linked.UserID != identity.UserID {
+			return ErrIdentityLinked
+		}
+		*identity = linked
+		return nil
+	}
+
+	_, err = tx.NewInsert().Model(identity).Exec(ctx)
+	return err
+}
+
+// ProvisionUser creates a user signing in with an identity which is not
+// linked yet. The first user of an instance becomes its admin.
+func (db *DB) ProvisionUser(ctx context.Context, user *model.User, identity *model.Identity) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if tx.Dialect().Name() == dialect.PG {
+			// see InsertFirstAdmin
+			if _, err := tx.ExecContext(ctx, "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+				return err
+			}
+		}
+
+		if exists, err := tx.NewSelect().Model((*model.User)(nil)).Exists(ctx); err != nil {
+			return err
+		} else if !exists {
+			user.Role = model.RoleAdmin
+		}
+
+		if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
+			return err
+		}
+		identity.UserID = user.ID
+		return linkIdentity(ctx, tx, identity)
+	})
+}
+
+// UnlinkIdentity removes an identity of a user. If keepOne is set, the user
+// has no other way to sign in, so ErrLastIdentity is returned instead of
+// unlinking their only identity. sql.ErrNoRows is returned if the user has no
+// such identity.
+func (db *DB) UnlinkIdentity(ctx context.Context, userID uuid.UUID, id int64, keepOne bool) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.Identity)(nil)).
+			Where("id = ? AND user_id = ?", id, userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil || !keepOne {
+			return err
+		}
+
+		if exists, err := tx.NewSelect().
+			Model((*model.Identity)(nil)).
+			Where("user_id = ?", userID).
+			Exists(ctx); err != nil {
+			return err
+		} else if !exists {
+			return ErrLastIdentity
+		}
+		return nil
+	})
+}
@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+var (
+	// ErrInvalidInvite is returned for invites which do not exist, were used
+	// or expired.
+	ErrInvalidInvite = errors.New("invite is invalid or expired")
+	// ErrSetupDone is returned when the first admin is created twice.
+	ErrSetupDone = errors.New("setup has already been completed")
+)
+
+func (db *DB) InsertInvite(ctx context.Context, invite *model.Invite) error {
+	_, err := db.NewInsert().Model(invite).Exec(ctx)
+	return err
+}
+
+// PendingInvites returns the invites which were neither used nor expired,
+// newest first.
+func (db *DB) PendingInvites(ctx context.Context) ([]*model.Invite, error) {
+	var invites []*model.Invite
+	err := db.NewSelect().
+		Model(&invites).
+		Relation("CreatedBy").
+		Where("invite.used_at IS NULL").
+		Where("invite.expires_at > ?", time.Now()).
+		Order("invite.created_at DESC").
+		Scan(ctx)
+	return invites, err
+}
+
+// DeleteInvite revokes an invite which has not been used yet, sql.ErrNoRows is
+// returned if there is no such invite.
+func (db *DB) DeleteInvite(ctx context.Context, id int64) error {
+	res, err := db.NewDelete().
+		Model((*model.Invite)(nil)).
+		Where("id = ? AND used_at IS NULL", id).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// InviteValid reports whether the invite token can be used to register.
+func (db *DB) InviteValid(ctx context.Context, token string) (bool, error) {
+	return db.NewSelect().
+		Model((*model.Invite)(nil)).
+		Where("token_hash = ?", model.HashInviteToken(token)).
+		Where("used_at IS NULL").
+		Where("expires_at > ?", time.Now()).
+		Exists(ctx)
+}
+
+// InsertUser registers a user. If invite is not empty, the invite is used up,
+// ErrInvalidInvite is returned if that is not possible.
+func (db *DB) InsertUser(ctx context.Context, user *model.User, invite string) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(user).Exec(ctx); err != nil {
+			return err
+		}
+		if invite == "" {
+			return nil
+		}
+
+		now := time.Now()
+		res, err := tx.NewUpdate().
+			Model((*model.Invite)(nil)).
+			Set("used_at = ?", now).
+			Set("used_by_id = ?", user.ID).
+			Where("token_hash = ?", model.HashInviteToken(invite)).
+			Where("used_at IS NULL").
+			Where("expires_at > ?", now).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrInvalidInvite
+		}
+		return nil
+	})
+}
+
+// InsertFirstAdmin creates the admin of a new instance, ErrSetupDone is
+// returned if there are users already.
+func (db *DB) InsertFirstAdmin(ctx context.Context, user *model.User) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if tx.Dialect().Name() == dialect.PG {
+			// keep concurrent setups from both seeing an empty table
+			if _, err := tx.ExecContext(ctx, "LOCK TABLE users IN SHARE ROW EXCLUSIVE MODE"); err != nil {
+				return err
+			}
+		}
+
+		if exists, err := tx.NewSelect().Model((*model.User)(nil)).Exists(ctx); err != nil {
+			return err
+		} else if exists {
+			return ErrSetupDone
+		}
+
+		user.Role = model.RoleAdmin
+		_, err := tx.NewInsert().Model(user).Exec(ctx)
+		return err
+	})
+}
+
+// SetupDone reports whether the first admin has been created.
+func (db *DB) SetupDone(ctx context.Context) (bool, error) {
+	return db.NewSelect().Model((*model.User)(nil)).Exists(ctx)
+}
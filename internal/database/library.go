@@ -0,0 +1,69 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// AddLibraryBook puts a book into the library of a user. Adding a book which
+// is already there does nothing.
+func (db *DB) AddLibraryBook(ctx context.Context, userID uuid.UUID, bookID int64) error {
+	return addLibraryBook(ctx, db.conn(ctx), userID, bookID)
+}
+
+func addLibraryBook(ctx context.Context, idb bun.IDB, userID uuid.UUID, bookID int64) error {
+	_, err := idb.NewInsert().
+		Model(&model.UserBook{UserID: userID, BookID: bookID}).
+		On("CONFLICT DO NOTHING").
+		Exec(ctx)
+	return err
+}
+
+// UpdateLibraryBook stores the details of a book in a library, sql.ErrNoRows
+// is returned if the book is not in the library.
+func (db *DB) UpdateLibraryBook(ctx context.Context, ub *model.UserBook) error {
+	ub.UpdatedAt = time.Now()
+	res, err := db.NewUpdate().
+		Model(ub).
+		Column("owned", "format", "location", "updated_at").
+		WherePK().
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// RemoveLibraryBook takes a book out of the library of a user. The book stays
+// in the catalog, and so do the events, reviews and quotes of the user.
+func (db *DB) RemoveLibraryBook(ctx context.Context, userID uuid.UUID, bookID int64) error {
+	_, err := db.NewDelete().
+		Model((*model.UserBook)(nil)).
+		Where("user_id = ? AND book_id = ?", userID, bookID).
+		Exec(ctx)
+	return err
+}
+
+// LibraryBook returns the library entry of a book, sql.ErrNoRows is returned
+// if the book is not in the library of the user.
+func (db *DB) LibraryBook(ctx context.Context, userID uuid.UUID, bookID int64) (*model.UserBook, error) {
+	var ub model.UserBook
+	err := db.NewSelect().
+		Model(&ub).
+		Where("user_id = ? AND book_id = ?", userID, bookID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &ub, nil
+}
+
+// WhereInLibrary limits a query on books to those in the library of a user.
+func WhereInLibrary(q *bun.SelectQuery, userID uuid.UUID) *bun.SelectQuery {
+	return q.Where("EXISTS (SELECT 1 FROM user_books AS ub WHERE ub.book_id = book.id AND ub.user_id = ?)", userID)
+}
@@ -0,0 +1,105 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/uptrace/bun"
+)
+
+// InsertLoginAttempt records an attempt and forgets those older than
+// retention.
+func (db *DB) InsertLoginAttempt(ctx context.Context, attempt *model.LoginAttempt, retention time.Duration) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(attempt).Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := tx.NewDelete().
+			Model((*model.LoginAttempt)(nil)).
+			Where("created_at < ?", time.Now().Add(-retention)).
+			Exec(ctx)
+		return err
+	})
+}
+
+// SucceedLoginAttempt records that an attempt succeeded.
+func (db *DB) SucceedLoginAttempt(ctx context.Context, id int64) error {
+	_, err := db.NewUpdate().
+		Model((*model.LoginAttempt)(nil)).
+		Set("success = ?", true).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// DeleteLoginAttempt forgets an attempt.
+func (db *DB) DeleteLoginAttempt(ctx context.Context, id int64) error {
+	_, err := db.NewDelete().
+		Model((*model.LoginAttempt)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+// UsernameFailures counts the failed logins with username since the given
+// time and the last successful one, leaving out the attempt except, and
+// returns the time of the last failure.
+func (db *DB) UsernameFailures(ctx context.Context, username string, since time.Time, except int64) (int, time.Time, error) {
+	var success model.LoginAttempt
+	err := db.NewSelect().
+		Model(&success).
+		Where("username = ? AND success", username).
+		Where("created_at > ?", since).
+		Order("created_at DESC").
+		Limit(1).
+		Scan(ctx)
+	if err == nil {
+		since = success.CreatedAt
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		return 0, time.Time{}, err
+	}
+
+	return db.loginFailures(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("username = ?", username).Where("created_at > ?", since).Where("id != ?", except)
+	})
+}
+
+// IPFailures counts the failed logins from ip since the given time, leaving
+// out the attempt except, and returns the time of the last one.
+func (db *DB) IPFailures(ctx context.Context, ip string, since time.Time, except int64) (int, time.Time, error) {
+	return db.loginFailures(ctx, func(q *bun.SelectQuery) *bun.SelectQuery {
+		return q.Where("ip = ?", ip).Where("created_at > ?", since).Where("id != ?", except)
+	})
+}
+
+func (db *DB) loginFailures(ctx context.Context, where func(*bun.SelectQuery) *bun.SelectQuery) (int, time.Time, error) {
+	var failures []*model.LoginAttempt
+	n, err := where(db.NewSelect().Model(&failures)).
+		Where("NOT success").
+		Order("created_at DESC").
+		Limit(1).
+		ScanAndCount(ctx)
+	// the count and the last failure are separate queries, between which
+	// attempts may be forgotten
+	if err != nil || n == 0 || len(failures) == 0 {
+		return 0, time.Time{}, err
+	}
+	return n, failures[0].CreatedAt, nil
+}
+
+// FailedLogins returns the last failed logins, newest first.
+func (db *DB) FailedLogins(ctx context.Context, limit int) ([]*model.LoginAttempt, error) {
+	var failures []*model.LoginAttempt
+	err := db.NewSelect().
+		Model(&failures).
+		Where("NOT success").
+		Order("created_at DESC").
+		Limit(limit).
+		Scan(ctx)
+	return failures, err
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"context"
+
+	"xiazki/internal/database/migrations"
+
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+	"github.com/uptrace/bun/dialect/pgdialect"
+	"github.com/uptrace/bun/dialect/sqlitedialect"
+	"github.com/uptrace/bun/migrate"
+	"github.com/uptrace/bun/schema"
+)
+
+// migrator runs the migrations on a bun.DB of its own. The table models of a
+// dialect are looked up by name, so the snapshot models of the migrations
+// would otherwise replace the models of the same tables, e.g. the join tables
+// of many-to-many relations.
+func (db *DB) migrator() *migrate.Migrator {
+	var d schema.Dialect = sqlitedialect.New()
+	if db.Dialect().Name() == dialect.PG {
+		d = pgdialect.New()
+	}
+	return migrate.NewMigrator(bun.NewDB(db.DB.DB, d), migrations.Migrations, migrate.WithMarkAppliedOnSuccess(true))
+}
+
+// Migrate applies all pending migrations as a single group.
+func (db *DB) Migrate(ctx context.Context) (*migrate.MigrationGroup, error) {
+	m := db.migrator()
+	if err := m.Init(ctx); err != nil {
+		return nil, err
+	}
+	if err := m.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { _ = m.Unlock(ctx) }()
+
+	return m.Migrate(ctx)
+}
+
+// Rollback reverts the most recently applied migration and returns it, or
+// nil if none is applied. Migrations are reverted one at a time whatever group
+// they were applied in, as Migrate applies all pending ones as one group.
+func (db *DB) Rollback(ctx context.Context) (*migrate.Migration, error) {
+	m := db.migrator()
+	if err := m.Init(ctx); err != nil {
+		return nil, err
+	}
+	if err := m.Lock(ctx); err != nil {
+		return nil, err
+	}
+	defer func() { _ = m.Unlock(ctx) }()
+
+	ms, err := m.MigrationsWithStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	applied := ms.Applied()
+	if len(applied) == 0 {
+		return nil, nil
+	}
+
+	last := &applied[0]
+	if last.Down != nil {
+		if err := last.Down(ctx, m.DB(), nil); err != nil {
+			return nil, err
+		}
+	}
+	if err := m.MarkUnapplied(ctx, last); err != nil {
+		return nil, err
+	}
+	return last, nil
+}
+
+// Migrations returns all known migrations, the applied ones have a group ID.
+func (db *DB) Migrations(ctx context.Context) (migrate.MigrationSlice, error) {
+	m := db.migrator()
+	if err := m.Init(ctx); err != nil {
+		return nil, err
+	}
+	return m.MigrationsWithStatus(ctx)
+}
@@ -0,0 +1,54 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestMigrate(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+
+		ms, err := db.Migrations(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if unapplied := ms.Unapplied(); len(unapplied) != 0 {
+			t.Fatalf("%d migrations not applied: %v", len(unapplied), unapplied)
+		}
+
+		dbtest.User(t, db, "alice", model.RoleUser)
+
+		// migrations are reverted one at a time, down to the initial one
+		for i := len(ms) - 1; i > 0; i-- {
+			m, err := db.Rollback(ctx)
+			if err != nil {
+				t.Fatalf("rollback %s: %v", ms[i].Name, err)
+			}
+			if m == nil || m.Name != ms[i].Name {
+				t.Fatalf("rolled back %v, want %s", m, ms[i].Name)
+			}
+		}
+		if _, err := db.Rollback(ctx); err == nil {
+			t.Fatal("rolled back the initial migration")
+		}
+		if n, err := db.NewSelect().Table("users").Count(ctx); err != nil {
+			t.Fatalf("users after refusing the rollback: %v", err)
+		} else if n != 1 {
+			t.Errorf("%d users after refusing the rollback, want 1", n)
+		}
+
+		if _, err := db.Migrate(ctx); err != nil {
+			t.Fatalf("migrate after rollback: %v", err)
+		}
+		if ms, err = db.Migrations(ctx); err != nil {
+			t.Fatal(err)
+		} else if unapplied := ms.Unapplied(); len(unapplied) != 0 {
+			t.Errorf("%d migrations not applied after migrating again", len(unapplied))
+		}
+	})
+}
@@ -0,0 +1,229 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// The schema as it was before migrations were introduced. The structs are
+// copies of the models of that time, so that the migration keeps creating the
+// same tables when the models change. Tables which already exist are left
+// alone, which adopts databases created by older versions.
+
+type user0001 struct {
+	bun.BaseModel `bun:"table:users"`
+
+	ID        uuid.UUID `bun:"id,pk,type:uuid"`
+	Username  string    `bun:"username,notnull,unique"`
+	Password  string    `bun:"password,notnull"`
+	Role      string    `bun:"role,notnull,default:'user'"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+type book0001 struct {
+	bun.BaseModel `bun:"table:books"`
+
+	ID           int64     `bun:"id,pk,autoincrement"`
+	Title        string    `bun:"title,notnull"`
+	Summary      string    `bun:"summary,type:text,nullzero"`
+	ISBN10       string    `bun:"isbn10,nullzero"`
+	ISBN13       string    `bun:"isbn13,nullzero"`
+	Language     string    `bun:"language,nullzero"`
+	Publisher    string    `bun:"publisher,nullzero"`
+	PublishDate  time.Time `bun:"publish_date,nullzero"`
+	PageCount    int64     `bun:"page_count,nullzero"`
+	SeriesName   string    `bun:"series_name,nullzero"`
+	SeriesNumber int64     `bun:"series_number,nullzero"`
+	CoverURL     string    `bun:"cover_url,nullzero"`
+	CreatedAt    time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt    time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// name0001 is the shape of authors, tags, translators and narrators.
+type name0001 struct {
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+type review0001 struct {
+	bun.BaseModel `bun:"table:reviews"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Rating    int64     `bun:"rating,nullzero"`
+	Opinion   string    `bun:"opinion,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull,unique:user_book"`
+	BookID    int64     `bun:"book_id,notnull,unique:user_book"`
+}
+
+type event0001 struct {
+	bun.BaseModel `bun:"table:events"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Type      string    `bun:"type,notnull"`
+	Date      time.Time `bun:"date,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	BookID    int64     `bun:"book_id,notnull"`
+}
+
+type quote0001 struct {
+	bun.BaseModel `bun:"table:quotes"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Quote     string    `bun:"quote,notnull"`
+	Page      int64     `bun:"page,nullzero"`
+	Note      string    `bun:"note,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	BookID    int64     `bun:"book_id,notnull"`
+}
+
+type shelf0001 struct {
+	bun.BaseModel `bun:"table:shelves"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name,notnull,unique:user_name"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull,unique:user_name"`
+}
+
+type bookAuthor0001 struct {
+	bun.BaseModel `bun:"table:book_authors"`
+
+	BookID   int64 `bun:"book_id,pk,unique:book_author"`
+	AuthorID int64 `bun:"author_id,pk,unique:book_author"`
+}
+
+type bookTag0001 struct {
+	bun.BaseModel `bun:"table:book_tags"`
+
+	BookID int64 `bun:"book_id,pk,unique:book_tag"`
+	TagID  int64 `bun:"tag_id,pk,unique:book_tag"`
+}
+
+type bookTranslator0001 struct {
+	bun.BaseModel `bun:"table:book_translators"`
+
+	BookID       int64 `bun:"book_id,pk,unique:book_translator"`
+	TranslatorID int64 `bun:"translator_id,pk,unique:book_translator"`
+}
+
+type bookNarrator0001 struct {
+	bun.BaseModel `bun:"table:book_narrators"`
+
+	BookID     int64 `bun:"book_id,pk,unique:book_narrator"`
+	NarratorID int64 `bun:"narrator_id,pk,unique:book_narrator"`
+}
+
+type shelfBook0001 struct {
+	bun.BaseModel `bun:"table:shelf_books"`
+
+	ShelfID   int64     `bun:"shelf_id,pk"`
+	BookID    int64     `bun:"book_id,pk"`
+	Position  int64     `bun:"position,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+var (
+	tables0001 = []any{
+		(*user0001)(nil),
+		(*book0001)(nil),
+		(*review0001)(nil),
+		(*event0001)(nil),
+		(*quote0001)(nil),
+		(*shelf0001)(nil),
+		(*bookAuthor0001)(nil),
+		(*bookTag0001)(nil),
+		(*bookTranslator0001)(nil),
+		(*bookNarrator0001)(nil),
+		(*shelfBook0001)(nil),
+	}
+	nameTables0001   = []string{"authors", "tags", "translators", "narrators"}
+	searchTables0001 = map[string]string{
+		"books_fts":   "title, summary, authors",
+		"reviews_fts": "opinion",
+		"quotes_fts":  "quote, note",
+	}
+)
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if err := dropSingleQuotes0001(ctx, db); err != nil {
+			return err
+		}
+
+		for _, table := range tables0001 {
+			if _, err := db.NewCreateTable().Model(table).IfNotExists().Exec(ctx); err != nil {
+				return err
+			}
+		}
+		for _, table := range nameTables0001 {
+			_, err := db.NewCreateTable().
+				Model((*name0001)(nil)).
+				ModelTableExpr("?", bun.Ident(table)).
+				IfNotExists().
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if db.Dialect().Name() != dialect.SQLite {
+			return nil
+		}
+		for table, columns := range searchTables0001 {
+			_, err := db.NewRaw(
+				"CREATE VIRTUAL TABLE IF NOT EXISTS ? USING fts5(?, tokenize = 'unicode61 remove_diacritics 2')",
+				bun.Ident(table), bun.Safe(columns),
+			).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, func(ctx context.Context, db *bun.DB) error {
+		// the tables may hold the data of a version older than migrations,
+		// which nothing could restore
+		return errors.New("the initial schema cannot be rolled back")
+	})
+}
+
+// dropSingleQuotes0001 drops the quotes table created by versions which
+// allowed only a single quote per user and book and had no page or note
+// columns. Nothing ever wrote to it, so no data is lost.
+func dropSingleQuotes0001(ctx context.Context, db *bun.DB) error {
+	if db.Dialect().Name() != dialect.SQLite {
+		return nil
+	}
+
+	var columns []struct {
+		Name string `bun:"name"`
+	}
+	if err := db.NewRaw("SELECT name FROM pragma_table_info('quotes')").Scan(ctx, &columns); err != nil {
+		return err
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	for _, column := range columns {
+		if column.Name == "page" {
+			return nil
+		}
+	}
+
+	_, err := db.NewDropTable().Model((*quote0001)(nil)).IfExists().Exec(ctx)
+	return err
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Disabling accounts and instance settings for the admin panel.
+
+type setting0002 struct {
+	bun.BaseModel `bun:"table:settings"`
+
+	Key       string    `bun:"key,pk"`
+	Value     string    `bun:"value,notnull"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewAddColumn().
+			Table("users").
+			ColumnExpr("disabled BOOLEAN NOT NULL DEFAULT FALSE").
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.NewCreateTable().Model((*setting0002)(nil)).Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		if _, err := db.NewDropTable().Model((*setting0002)(nil)).IfExists().Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := db.NewDropColumn().Table("users").Column("disabled").Exec(ctx)
+		return err
+	})
+}
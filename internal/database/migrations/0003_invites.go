@@ -0,0 +1,47 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Registration modes and invites. The open/closed switch becomes the
+// open/invite/closed mode.
+
+type invite0003 struct {
+	bun.BaseModel `bun:"table:invites"`
+
+	ID          int64     `bun:"id,pk,autoincrement"`
+	TokenHash   string    `bun:"token_hash,notnull,unique"`
+	CreatedByID uuid.UUID `bun:"created_by_id,type:uuid,notnull"`
+	ExpiresAt   time.Time `bun:"expires_at,notnull"`
+	UsedAt      time.Time `bun:"used_at,nullzero"`
+	UsedByID    uuid.UUID `bun:"used_by_id,type:uuid,nullzero"`
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		if _, err := db.NewCreateTable().Model((*invite0003)(nil)).Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := db.NewRaw(`UPDATE settings
+			SET key = 'registration', value = CASE value WHEN 'false' THEN 'closed' ELSE 'open' END
+			WHERE key = 'registration_open'`).Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewRaw(`UPDATE settings
+			SET key = 'registration_open', value = CASE value WHEN 'open' THEN 'true' ELSE 'false' END
+			WHERE key = 'registration'`).Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = db.NewDropTable().Model((*invite0003)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
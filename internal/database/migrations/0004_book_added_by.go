@@ -0,0 +1,22 @@
+package migrations
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+// Books record who added them. Existing books are left without an adder.
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewAddColumn().
+			Table("books").
+			ColumnExpr("added_by_id UUID").
+			Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropColumn().Table("books").Column("added_by_id").Exec(ctx)
+		return err
+	})
+}
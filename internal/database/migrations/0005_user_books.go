@@ -0,0 +1,62 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Per-user libraries. Books stay in the shared catalog and are linked into
+// the libraries of users. A user's library starts with the books they added
+// or have events, reviews, quotes or shelf entries for. Books nobody can be
+// matched with were visible to everyone before, so they go into every
+// library, from which users can remove them.
+
+type userBook0005 struct {
+	bun.BaseModel `bun:"table:user_books"`
+
+	UserID    uuid.UUID `bun:"user_id,pk,type:uuid"`
+	BookID    int64     `bun:"book_id,pk"`
+	Owned     bool      `bun:"owned,notnull,default:false"`
+	Format    string    `bun:"format,nullzero"`
+	Location  string    `bun:"location,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+var backfill0005 = []string{
+	`SELECT added_by_id AS user_id, id AS book_id, created_at FROM books WHERE added_by_id IS NOT NULL`,
+	`SELECT user_id, book_id, MIN(created_at) AS created_at FROM events GROUP BY user_id, book_id`,
+	`SELECT user_id, book_id, MIN(created_at) AS created_at FROM reviews GROUP BY user_id, book_id`,
+	`SELECT user_id, book_id, MIN(created_at) AS created_at FROM quotes GROUP BY user_id, book_id`,
+	`SELECT s.user_id, sb.book_id, MIN(sb.created_at) AS created_at FROM shelf_books AS sb
+		JOIN shelves AS s ON s.id = sb.shelf_id GROUP BY s.user_id, sb.book_id`,
+	`SELECT u.id AS user_id, b.id AS book_id, b.created_at FROM books AS b CROSS JOIN users AS u
+		WHERE NOT EXISTS (SELECT 1 FROM user_books AS ub WHERE ub.book_id = b.id)`,
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := tx.NewCreateTable().Model((*userBook0005)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+
+			for _, query := range backfill0005 {
+				_, err := tx.ExecContext(ctx, `INSERT INTO user_books (user_id, book_id, created_at) `+
+					`SELECT l.user_id, l.book_id, l.created_at FROM (`+query+`) AS l `+
+					`WHERE EXISTS (SELECT 1 FROM books AS b WHERE b.id = l.book_id) AND NOT EXISTS (`+
+					`SELECT 1 FROM user_books AS ub WHERE ub.user_id = l.user_id AND ub.book_id = l.book_id)`)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*userBook0005)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Identities at OpenID Connect providers users can sign in with.
+
+type identity0006 struct {
+	bun.BaseModel `bun:"table:user_identities"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	Provider  string    `bun:"provider,notnull,unique:provider_subject"`
+	Subject   string    `bun:"subject,notnull,unique:provider_subject"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewCreateTable().Model((*identity0006)(nil)).Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*identity0006)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Login attempts, looked up by username, by IP address and by age.
+
+type loginAttempt0007 struct {
+	bun.BaseModel `bun:"table:login_attempts"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Username  string    `bun:"username,notnull"`
+	IP        string    `bun:"ip,notnull"`
+	Success   bool      `bun:"success,notnull,default:false"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+var indexes0007 = map[string][]string{
+	"login_attempts_username_idx":   {"username", "created_at"},
+	"login_attempts_ip_idx":         {"ip", "created_at"},
+	"login_attempts_created_at_idx": {"created_at"},
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := tx.NewCreateTable().Model((*loginAttempt0007)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+			for name, columns := range indexes0007 {
+				_, err := tx.NewCreateIndex().
+					Model((*loginAttempt0007)(nil)).
+					Index(name).
+					Column(columns...).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*loginAttempt0007)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
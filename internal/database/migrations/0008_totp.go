@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Two-factor authentication with TOTP and recovery codes.
+
+type recoveryCode0008 struct {
+	bun.BaseModel `bun:"table:recovery_codes"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	CodeHash  string    `bun:"code_hash,notnull,unique"`
+	UsedAt    time.Time `bun:"used_at,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+var columns0008 = map[string]string{
+	"totp_secret": "totp_secret VARCHAR",
+	"totp_step":   "totp_step BIGINT NOT NULL DEFAULT 0",
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		for _, expr := range columns0008 {
+			if _, err := db.NewAddColumn().Table("users").ColumnExpr(expr).Exec(ctx); err != nil {
+				return err
+			}
+		}
+
+		_, err := db.NewCreateTable().Model((*recoveryCode0008)(nil)).Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		if _, err := db.NewDropTable().Model((*recoveryCode0008)(nil)).IfExists().Exec(ctx); err != nil {
+			return err
+		}
+
+		for column := range columns0008 {
+			if _, err := db.NewDropColumn().Table("users").Column(column).Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
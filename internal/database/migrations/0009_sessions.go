@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Sessions stored in the database instead of the session cookie.
+
+type session0009 struct {
+	bun.BaseModel `bun:"table:sessions"`
+
+	ID           int64     `bun:"id,pk,autoincrement"`
+	TokenHash    string    `bun:"token_hash,notnull,unique"`
+	UserID       uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	SecondFactor bool      `bun:"second_factor,notnull,default:false"`
+	UserAgent    string    `bun:"user_agent,nullzero"`
+	IP           string    `bun:"ip,nullzero"`
+	ExpiresAt    time.Time `bun:"expires_at,notnull"`
+	LastSeenAt   time.Time `bun:"last_seen_at,nullzero,notnull,default:current_timestamp"`
+	CreatedAt    time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+var indexes0009 = map[string][]string{
+	"sessions_user_id_idx":    {"user_id"},
+	"sessions_expires_at_idx": {"expires_at"},
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := tx.NewCreateTable().Model((*session0009)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+			for name, columns := range indexes0009 {
+				_, err := tx.NewCreateIndex().
+					Model((*session0009)(nil)).
+					Index(name).
+					Column(columns...).
+					Exec(ctx)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*session0009)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Personal API tokens.
+
+type apiToken0010 struct {
+	bun.BaseModel `bun:"table:api_tokens"`
+
+	ID         int64     `bun:"id,pk,autoincrement"`
+	Name       string    `bun:"name,notnull"`
+	TokenHash  string    `bun:"token_hash,notnull,unique"`
+	UserID     uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	Scope      string    `bun:"scope,notnull"`
+	ExpiresAt  time.Time `bun:"expires_at,nullzero"`
+	LastUsedAt time.Time `bun:"last_used_at,nullzero"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := tx.NewCreateTable().Model((*apiToken0010)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+			_, err := tx.NewCreateIndex().
+				Model((*apiToken0010)(nil)).
+				Index("api_tokens_user_id_idx").
+				Column("user_id").
+				Exec(ctx)
+			return err
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*apiToken0010)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
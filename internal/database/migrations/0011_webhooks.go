@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Webhooks and their delivery queue.
+
+type webhook0011 struct {
+	bun.BaseModel `bun:"table:webhooks"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	URL       string    `bun:"url,notnull"`
+	Secret    string    `bun:"secret,notnull"`
+	Events    string    `bun:"events,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+type webhookDelivery0011 struct {
+	bun.BaseModel `bun:"table:webhook_deliveries"`
+
+	ID             int64     `bun:"id,pk,autoincrement"`
+	WebhookID      int64     `bun:"webhook_id,notnull"`
+	Event          string    `bun:"event,notnull"`
+	Payload        string    `bun:"payload,notnull"`
+	Status         string    `bun:"status,notnull"`
+	Attempts       int       `bun:"attempts,notnull,default:0"`
+	NextAttemptAt  time.Time `bun:"next_attempt_at,notnull"`
+	LastAttemptAt  time.Time `bun:"last_attempt_at,nullzero"`
+	ResponseStatus int       `bun:"response_status,nullzero"`
+	Error          string    `bun:"error,nullzero"`
+	CreatedAt      time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+			if _, err := tx.NewCreateTable().Model((*webhook0011)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+			if _, err := tx.NewCreateTable().Model((*webhookDelivery0011)(nil)).Exec(ctx); err != nil {
+				return err
+			}
+
+			_, err := tx.NewCreateIndex().
+				Model((*webhook0011)(nil)).
+				Index("webhooks_user_id_idx").
+				Column("user_id").
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.NewCreateIndex().
+				Model((*webhookDelivery0011)(nil)).
+				Index("webhook_deliveries_webhook_id_idx").
+				Column("webhook_id").
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+			_, err = tx.NewCreateIndex().
+				Model((*webhookDelivery0011)(nil)).
+				Index("webhook_deliveries_due_idx").
+				Column("status", "next_attempt_at").
+				Exec(ctx)
+			return err
+		})
+	}, func(ctx context.Context, db *bun.DB) error {
+		if _, err := db.NewDropTable().Model((*webhookDelivery0011)(nil)).IfExists().Exec(ctx); err != nil {
+			return err
+		}
+		_, err := db.NewDropTable().Model((*webhook0011)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
@@ -0,0 +1,33 @@
+package migrations
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// The outbox of changes which webhooks are yet to be told about.
+
+type webhookOutbox0012 struct {
+	bun.BaseModel `bun:"table:webhook_outbox"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Type      string    `bun:"type,notnull"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	BookID    int64     `bun:"book_id,notnull"`
+	EventID   int64     `bun:"event_id,nullzero"`
+	ReviewID  int64     `bun:"review_id,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+func init() {
+	Migrations.MustRegister(func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewCreateTable().Model((*webhookOutbox0012)(nil)).Exec(ctx)
+		return err
+	}, func(ctx context.Context, db *bun.DB) error {
+		_, err := db.NewDropTable().Model((*webhookOutbox0012)(nil)).IfExists().Exec(ctx)
+		return err
+	})
+}
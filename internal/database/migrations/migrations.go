@@ -0,0 +1,11 @@
+// Package migrations holds the schema migrations of the database. Each
+// migration lives in its own file named after its number, which orders them,
+// and must never change once released. Schema changes are made by adding a
+// new migration.
+package migrations
+
+import (
+	"github.com/uptrace/bun/migrate"
+)
+
+var Migrations = migrate.NewMigrations()
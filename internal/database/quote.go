@@ -0,0 +1,67 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+func (db *DB) InsertQuote(ctx context.Context, quote *model.Quote) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(quote).Exec(ctx); err != nil {
+			return err
+		}
+		return reindex(ctx, tx, quotesIndex, quote.ID)
+	})
+}
+
+// UpdateQuote updates the text, page and note of a quote. Only quotes owned by
+// quote.UserID are affected, sql.ErrNoRows is returned otherwise.
+func (db *DB) UpdateQuote(ctx context.Context, quote *model.Quote) error {
+	quote.UpdatedAt = time.Now()
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model(quote).
+			Column("quote", "page", "note", "updated_at").
+			Where("id = ? AND user_id = ?", quote.ID, quote.UserID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+		return reindex(ctx, tx, quotesIndex, quote.ID)
+	})
+}
+
+// DeleteQuote deletes a quote owned by userID, sql.ErrNoRows is returned if
+// there is no such quote.
+func (db *DB) DeleteQuote(ctx context.Context, id int64, userID uuid.UUID) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.Quote)(nil)).
+			Where("id = ? AND user_id = ?", id, userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+		return reindex(ctx, tx, quotesIndex, id)
+	})
+}
+
+func expectAffected(res sql.Result) error {
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
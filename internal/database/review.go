@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"errors"
+	"strconv"
 	"time"
 
 	"xiazki/internal/model"
@@ -12,7 +13,10 @@ import (
 	"github.com/uptrace/bun"
 )
 
-func (db *DB) InsertOrUpdateReview(ctx context.Context, review *model.Review, updateOpinion bool) error {
+// InsertOrUpdateReview saves the review of a book by a user. An existing
+// review only takes the rating and the opinion if updateRating and
+// updateOpinion are set, so that e.g. an import without ratings keeps them.
+func (db *DB) InsertOrUpdateReview(ctx context.Context, review *model.Review, updateRating, updateOpinion bool) error {
 	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
 		var oldReview model.Review
 
@@ -22,15 +26,17 @@ func (db *DB) InsertOrUpdateReview(ctx context.Context, review *model.Review, up
 			Limit(1).
 			Scan(ctx); err != nil {
 			if errors.Is(err, sql.ErrNoRows) {
-				_, err := tx.NewInsert().
-					Model(review).
-					Exec(ctx)
-				return err
+				if _, err := tx.NewInsert().Model(review).Exec(ctx); err != nil {
+					return err
+				} else if err := reindex(ctx, tx, reviewsIndex, review.ID); err != nil {
+					return err
+				}
+				return queueReviewWebhooks(ctx, tx, review)
 			}
 			return err
 		}
 
-		if 0 <= review.Rating && review.Rating <= 10 {
+		if updateRating {
 			oldReview.Rating = review.Rating
 		}
 		if updateOpinion {
@@ -43,43 +49,80 @@ func (db *DB) InsertOrUpdateReview(ctx context.Context, review *model.Review, up
 			Model(&oldReview).
 			WherePK().
 			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := reindex(ctx, tx, reviewsIndex, oldReview.ID); err != nil {
+			return err
+		}
+		return queueReviewWebhooks(ctx, tx, &oldReview)
+	})
+}
 
-		return err
+func queueReviewWebhooks(ctx context.Context, tx bun.Tx, review *model.Review) error {
+	return queueWebhooks(ctx, tx, &model.WebhookOutbox{
+		Type:     model.WebhookReviewSaved,
+		UserID:   review.UserID,
+		BookID:   review.BookID,
+		ReviewID: review.ID,
+	})
+}
+
+// DeleteReview deletes the review of a book by a user, sql.ErrNoRows is
+// returned if there is none.
+func (db *DB) DeleteReview(ctx context.Context, userID uuid.UUID, bookID int64) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var review model.Review
+		_, err := tx.NewDelete().
+			Model(&review).
+			Where("user_id = ? AND book_id = ?", userID, bookID).
+			Returning("id").
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if review.ID == 0 {
+			return sql.ErrNoRows
+		}
+		return reindex(ctx, tx, reviewsIndex, review.ID)
 	})
 }
 
 func (db *DB) FullReviewStats(ctx context.Context, bookID int64, userID uuid.UUID) (*model.ReviewStats, error) {
 	var stats model.ReviewStats
-	ratingsSpreadCTE := db.NewSelect().
-		Table("reviews").
-		ColumnExpr("rating").
-		ColumnExpr("COUNT(*) AS count").
-		Where("book_id = ?", bookID).
-		Where("rating BETWEEN 1 AND 10").
-		Group("rating")
 	err := db.NewSelect().
 		ColumnExpr("COALESCE(MAX(CASE WHEN user_id = ? THEN rating END), 0) AS user_rating", userID).
 		ColumnExpr("COALESCE(AVG(CASE WHEN rating != 0 THEN CAST(rating AS REAL) END), 0.0) AS average_rating").
 		ColumnExpr("COUNT(CASE WHEN rating != 0 THEN 1 END) AS ratings_count").
 		ColumnExpr("COUNT(CASE WHEN opinion != '' THEN 1 END) AS opinions_count").
-		ColumnExpr(`
-			COALESCE(
-				(SELECT json_object(
-					'1', COALESCE(SUM(CASE WHEN rating = 1 THEN count ELSE 0 END), 0),
-					'2', COALESCE(SUM(CASE WHEN rating = 2 THEN count ELSE 0 END), 0),
-					'3', COALESCE(SUM(CASE WHEN rating = 3 THEN count ELSE 0 END), 0),
-					'4', COALESCE(SUM(CASE WHEN rating = 4 THEN count ELSE 0 END), 0),
-					'5', COALESCE(SUM(CASE WHEN rating = 5 THEN count ELSE 0 END), 0),
-					'6', COALESCE(SUM(CASE WHEN rating = 6 THEN count ELSE 0 END), 0),
-					'7', COALESCE(SUM(CASE WHEN rating = 7 THEN count ELSE 0 END), 0),
-					'8', COALESCE(SUM(CASE WHEN rating = 8 THEN count ELSE 0 END), 0),
-					'9', COALESCE(SUM(CASE WHEN rating = 9 THEN count ELSE 0 END), 0),
-					'10', COALESCE(SUM(CASE WHEN rating = 10 THEN count ELSE 0 END), 0)
-				) FROM (?) AS t), 
-				'{}'
-			) AS ratings_spread`, ratingsSpreadCTE).
 		Table("reviews").
 		Where("book_id = ?", bookID).
 		Scan(ctx, &stats)
-	return &stats, err
+	if err != nil {
+		return nil, err
+	}
+
+	var spread []struct {
+		Rating int64 `bun:"rating"`
+		Count  int64 `bun:"count"`
+	}
+	err = db.NewSelect().
+		Table("reviews").
+		ColumnExpr("rating").
+		ColumnExpr("COUNT(*) AS count").
+		Where("book_id = ?", bookID).
+		Where("rating BETWEEN 1 AND 10").
+		Group("rating").
+		Scan(ctx, &spread)
+	if err != nil {
+		return nil, err
+	}
+
+	stats.RatingsSpread = make(map[string]int64, 10)
+	for rating := 1; rating <= 10; rating++ {
+		stats.RatingsSpread[strconv.Itoa(rating)] = 0
+	}
+	for _, s := range spread {
+		stats.RatingsSpread[strconv.FormatInt(s.Rating, 10)] = s.Count
+	}
+
+	return &stats, nil
 }
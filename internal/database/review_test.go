@@ -0,0 +1,64 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestReviews(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		bob := dbtest.User(t, db, "bob", model.RoleUser)
+		b := addBook(t, db, alice, &model.Book{Title: "Dune"})
+
+		save := func(user *model.User, rating int64, opinion string, updateRating, updateOpinion bool) {
+			t.Helper()
+			review := &model.Review{UserID: user.ID, BookID: b.ID, Rating: rating, Opinion: opinion}
+			if err := db.InsertOrUpdateReview(ctx, review, updateRating, updateOpinion); err != nil {
+				t.Fatal(err)
+			}
+		}
+		save(alice, 8, "Spice", true, true)
+		save(bob, 0, "Not rated", true, true)
+		// an update without a rating keeps it
+		save(alice, 0, "Spice must flow", false, true)
+
+		var review model.Review
+		if err := db.NewSelect().Model(&review).Where("user_id = ? AND book_id = ?", alice.ID, b.ID).Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if review.Rating != 8 || review.Opinion != "Spice must flow" {
+			t.Errorf("review = %d %q, want 8 \"Spice must flow\"", review.Rating, review.Opinion)
+		}
+
+		stats, err := db.FullReviewStats(ctx, b.ID, alice.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.UserRating != 8 || stats.AverageRating != 8 || stats.RatingsCount != 1 || stats.OpinionsCount != 2 {
+			t.Errorf("stats = %+v", stats)
+		}
+		if stats.RatingsSpread["8"] != 1 || len(stats.RatingsSpread) != 10 {
+			t.Errorf("spread = %v", stats.RatingsSpread)
+		}
+
+		if err := db.DeleteReview(ctx, alice.ID, b.ID); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.DeleteReview(ctx, alice.ID, b.ID); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("deleting again: err = %v, want sql.ErrNoRows", err)
+		}
+		if stats, err := db.FullReviewStats(ctx, b.ID, alice.ID); err != nil {
+			t.Fatal(err)
+		} else if stats.UserRating != 0 || stats.RatingsCount != 0 || stats.OpinionsCount != 1 {
+			t.Errorf("stats after delete = %+v", stats)
+		}
+	})
+}
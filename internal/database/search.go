@@ -0,0 +1,264 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect"
+)
+
+// searchIndex is an FTS5 table mirroring some text columns, the tables are
+// created by the migrations. The rowid of the
+// table is the id of the indexed row, source selects it followed by the
+// indexed columns for the rows matching where.
+type searchIndex struct {
+	table   string
+	columns string
+	source  string
+	id      string
+	where   string
+}
+
+var (
+	booksIndex = searchIndex{
+		table:   "books_fts",
+		columns: "title, summary, authors",
+		source: `SELECT b.id, b.title, COALESCE(b.summary, ''),
+			COALESCE((SELECT group_concat(a.name, ' ') FROM book_authors AS ba JOIN authors AS a ON a.id = ba.author_id WHERE ba.book_id = b.id), '')
+			FROM books AS b`,
+		id:    "b.id",
+		where: "TRUE",
+	}
+	reviewsIndex = searchIndex{
+		table:   "reviews_fts",
+		columns: "opinion",
+		source:  "SELECT r.id, r.opinion FROM reviews AS r",
+		id:      "r.id",
+		where:   "r.opinion IS NOT NULL AND r.opinion != ''",
+	}
+	quotesIndex = searchIndex{
+		table:   "quotes_fts",
+		columns: "quote, note",
+		source:  "SELECT q.id, q.quote, COALESCE(q.note, '') FROM quotes AS q",
+		id:      "q.id",
+		where:   "TRUE",
+	}
+
+	searchIndexes = []searchIndex{booksIndex, reviewsIndex, quotesIndex}
+)
+
+// reindex brings the index entry of a single row up to date, a row which no
+// longer exists is removed from the index.
+func reindex(ctx context.Context, db bun.IDB, idx searchIndex, id int64) error {
+	if db.Dialect().Name() != dialect.SQLite {
+		return nil
+	}
+
+	if _, err := db.NewRaw("DELETE FROM ? WHERE rowid = ?", bun.Ident(idx.table), id).Exec(ctx); err != nil {
+		return err
+	}
+
+	_, err := db.NewRaw(
+		"INSERT INTO ? (rowid, ?) "+idx.source+" WHERE "+idx.where+" AND "+idx.id+" = ?",
+		bun.Ident(idx.table), bun.Safe(idx.columns), id,
+	).Exec(ctx)
+	return err
+}
+
+// RebuildSearchIndex fills the full-text search tables from scratch. It is
+// needed for SQLite databases created before the search was introduced,
+// PostgreSQL has no search tables.
+func (db *DB) RebuildSearchIndex(ctx context.Context) error {
+	if !db.isSQLite() {
+		return nil
+	}
+
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		for _, idx := range searchIndexes {
+			if _, err := tx.NewRaw("DELETE FROM ?", bun.Ident(idx.table)).Exec(ctx); err != nil {
+				return err
+			}
+			_, err := tx.NewRaw(
+				"INSERT INTO ? (rowid, ?) "+idx.source+" WHERE "+idx.where,
+				bun.Ident(idx.table), bun.Safe(idx.columns),
+			).Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Search looks up books, reviews and quotes containing all words of the query,
+// the last one also as a prefix. Only the books in the library and the quotes
+// of userID are searched, while reviews of all users are. Each kind of result
+// is ordered by relevance and limited to limit entries.
+func (db *DB) Search(ctx context.Context, userID uuid.UUID, query string, limit int) (*model.SearchResults, error) {
+	if !db.isSQLite() {
+		return db.searchPostgres(ctx, userID, query, limit)
+	}
+
+	var results model.SearchResults
+
+	match := ftsQuery(query)
+	if match == "" {
+		return &results, nil
+	}
+
+	err := db.NewRaw(`
+		SELECT b.id AS book_id,
+			highlight(books_fts, 0, ?, ?) AS title,
+			snippet(books_fts, -1, ?, ?, '…', 24) AS snippet
+		FROM books_fts
+		JOIN books AS b ON b.id = books_fts.rowid
+		JOIN user_books AS ub ON ub.book_id = b.id AND ub.user_id = ?
+		WHERE books_fts MATCH ?
+		ORDER BY rank
+		LIMIT ?`,
+		model.SnippetStart, model.SnippetEnd,
+		model.SnippetStart, model.SnippetEnd,
+		userID, match, limit,
+	).Scan(ctx, &results.Books)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.NewRaw(`
+		SELECT r.id AS review_id, b.id AS book_id, b.title AS book_title, u.username,
+			snippet(reviews_fts, 0, ?, ?, '…', 32) AS snippet
+		FROM reviews_fts
+		JOIN reviews AS r ON r.id = reviews_fts.rowid
+		JOIN books AS b ON b.id = r.book_id
+		JOIN users AS u ON u.id = r.user_id
+		WHERE reviews_fts MATCH ?
+		ORDER BY rank
+		LIMIT ?`,
+		model.SnippetStart, model.SnippetEnd,
+		match, limit,
+	).Scan(ctx, &results.Reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.NewRaw(`
+		SELECT q.id AS quote_id, b.id AS book_id, b.title AS book_title,
+			snippet(quotes_fts, -1, ?, ?, '…', 32) AS snippet
+		FROM quotes_fts
+		JOIN quotes AS q ON q.id = quotes_fts.rowid
+		JOIN books AS b ON b.id = q.book_id
+		WHERE quotes_fts MATCH ? AND q.user_id = ?
+		ORDER BY rank
+		LIMIT ?`,
+		model.SnippetStart, model.SnippetEnd,
+		match, userID, limit,
+	).Scan(ctx, &results.Quotes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// ftsQuery turns user input into an FTS5 query. Every word is quoted, so that
+// no input is interpreted as query syntax, and the last one matches as a
+// prefix.
+func ftsQuery(input string) string {
+	words := strings.Fields(input)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// searchPostgres is Search for PostgreSQL, which has no FTS5. The documents
+// are built and matched with the built-in text search at query time instead of
+// being kept in separate tables.
+func (db *DB) searchPostgres(ctx context.Context, userID uuid.UUID, query string, limit int) (*model.SearchResults, error) {
+	var results model.SearchResults
+
+	match := tsQuery(query)
+	if match == "" {
+		return &results, nil
+	}
+
+	sel := `StartSel="` + model.SnippetStart + `", StopSel="` + model.SnippetEnd + `"`
+	title := sel + ", HighlightAll=true"
+	snippet := sel + ", MaxWords=32, MinWords=12, FragmentDelimiter=\"…\", MaxFragments=2"
+
+	err := db.NewRaw(`
+		SELECT b.id AS book_id,
+			ts_headline('simple', b.title, q, ?) AS title,
+			ts_headline('simple', COALESCE(b.summary, '') || ' ' || a.names, q, ?) AS snippet
+		FROM books AS b
+		JOIN user_books AS ub ON ub.book_id = b.id AND ub.user_id = ?
+		CROSS JOIN LATERAL (
+			SELECT COALESCE(string_agg(a.name, ' '), '') AS names
+			FROM book_authors AS ba JOIN authors AS a ON a.id = ba.author_id
+			WHERE ba.book_id = b.id
+		) AS a
+		CROSS JOIN LATERAL to_tsvector('simple', b.title || ' ' || COALESCE(b.summary, '') || ' ' || a.names) AS doc
+		CROSS JOIN to_tsquery('simple', ?) AS q
+		WHERE doc @@ q
+		ORDER BY ts_rank(doc, q) DESC
+		LIMIT ?`,
+		title, snippet, userID, match, limit,
+	).Scan(ctx, &results.Books)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.NewRaw(`
+		SELECT r.id AS review_id, b.id AS book_id, b.title AS book_title, u.username,
+			ts_headline('simple', r.opinion, q, ?) AS snippet
+		FROM reviews AS r
+		JOIN books AS b ON b.id = r.book_id
+		JOIN users AS u ON u.id = r.user_id
+		CROSS JOIN LATERAL to_tsvector('simple', COALESCE(r.opinion, '')) AS doc
+		CROSS JOIN to_tsquery('simple', ?) AS q
+		WHERE doc @@ q
+		ORDER BY ts_rank(doc, q) DESC
+		LIMIT ?`,
+		snippet, match, limit,
+	).Scan(ctx, &results.Reviews)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.NewRaw(`
+		SELECT q.id AS quote_id, b.id AS book_id, b.title AS book_title,
+			ts_headline('simple', q.quote || ' ' || COALESCE(q.note, ''), tq, ?) AS snippet
+		FROM quotes AS q
+		JOIN books AS b ON b.id = q.book_id
+		CROSS JOIN LATERAL to_tsvector('simple', q.quote || ' ' || COALESCE(q.note, '')) AS doc
+		CROSS JOIN to_tsquery('simple', ?) AS tq
+		WHERE doc @@ tq AND q.user_id = ?
+		ORDER BY ts_rank(doc, tq) DESC
+		LIMIT ?`,
+		snippet, match, userID, limit,
+	).Scan(ctx, &results.Quotes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &results, nil
+}
+
+// tsQuery is ftsQuery for PostgreSQL text search.
+func tsQuery(input string) string {
+	words := strings.Fields(input)
+	for i, word := range words {
+		words[i] = "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(word) + "'"
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += ":*"
+	}
+	return strings.Join(words, " & ")
+}
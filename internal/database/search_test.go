@@ -0,0 +1,69 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestSearch(t *testing.T) {
+	dbtest.Each(t, func(t *testing.T, db *database.DB) {
+		ctx := context.Background()
+		alice := dbtest.User(t, db, "alice", model.RoleUser)
+		bob := dbtest.User(t, db, "bob", model.RoleUser)
+
+		dune := addBook(t, db, alice, &model.Book{
+			Title:   "Dune",
+			Summary: "A desert planet and its spice.",
+			Authors: []*model.Author{{Name: "Frank Herbert"}},
+		})
+		addBook(t, db, bob, &model.Book{Title: "Spice Trade"})
+		if err := db.InsertOrUpdateReview(ctx, &model.Review{UserID: bob.ID, BookID: dune.ID, Opinion: "Too much spice"}, true, true); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertQuote(ctx, &model.Quote{UserID: alice.ID, BookID: dune.ID, Quote: "The spice must flow."}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.InsertQuote(ctx, &model.Quote{UserID: bob.ID, BookID: dune.ID, Quote: "Spice is life."}); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := db.Search(ctx, alice.ID, "spic", 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// only books in the library and own quotes, but reviews of everyone
+		if len(results.Books) != 1 || results.Books[0].BookID != dune.ID {
+			t.Errorf("books = %+v, want Dune", results.Books)
+		}
+		if len(results.Reviews) != 1 || results.Reviews[0].Username != "bob" {
+			t.Errorf("reviews = %+v, want the review of bob", results.Reviews)
+		}
+		if len(results.Quotes) != 1 || results.Quotes[0].BookID != dune.ID {
+			t.Errorf("quotes = %+v, want the quote of alice", results.Quotes)
+		}
+
+		if results, err := db.Search(ctx, alice.ID, "herbert", 10); err != nil {
+			t.Fatal(err)
+		} else if len(results.Books) != 1 {
+			t.Errorf("search by author: books = %+v, want Dune", results.Books)
+		}
+
+		// query syntax is searched for literally
+		if _, err := db.Search(ctx, alice.ID, `"spice" OR -(`, 10); err != nil {
+			t.Errorf("search with operators: %v", err)
+		}
+
+		if err := db.RebuildSearchIndex(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if results, err := db.Search(ctx, alice.ID, "desert", 10); err != nil {
+			t.Fatal(err)
+		} else if len(results.Books) != 1 {
+			t.Errorf("search after rebuild: books = %+v, want Dune", results.Books)
+		}
+	})
+}
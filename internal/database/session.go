@@ -0,0 +1,122 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// InsertSession stores a new session and forgets the expired ones.
+func (db *DB) InsertSession(ctx context.Context, session *model.Session) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.NewInsert().Model(session).Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err := tx.NewDelete().
+			Model((*model.Session)(nil)).
+			Where("expires_at < ?", time.Now()).
+			Exec(ctx)
+		return err
+	})
+}
+
+// SessionByToken returns the unexpired session of a token, sql.ErrNoRows is
+// returned if there is none.
+func (db *DB) SessionByToken(ctx context.Context, token string) (*model.Session, error) {
+	var session model.Session
+	err := db.NewSelect().
+		Model(&session).
+		Where("token_hash = ?", model.HashSessionToken(token)).
+		Where("expires_at > ?", time.Now()).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession records that a session was used from ip. Only uses after
+// interval since the last recorded one are written, to spare the database a
+// write on every request.
+func (db *DB) TouchSession(ctx context.Context, session *model.Session, ip string, interval time.Duration) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < interval && session.IP == ip {
+		return nil
+	}
+
+	_, err := db.NewUpdate().
+		Model((*model.Session)(nil)).
+		Set("last_seen_at = ?", now).
+		Set("ip = ?", ip).
+		Where("id = ?", session.ID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	session.LastSeenAt = now
+	session.IP = ip
+	return nil
+}
+
+// PassSecondFactor records that the user of a session has passed the second
+// factor.
+func (db *DB) PassSecondFactor(ctx context.Context, id int64) error {
+	res, err := db.NewUpdate().
+		Model((*model.Session)(nil)).
+		Set("second_factor = ?", true).
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// UserSessions returns the unexpired sessions of a user, the last used first.
+func (db *DB) UserSessions(ctx context.Context, userID uuid.UUID) ([]*model.Session, error) {
+	var sessions []*model.Session
+	err := db.NewSelect().
+		Model(&sessions).
+		Where("user_id = ?", userID).
+		Where("expires_at > ?", time.Now()).
+		Order("last_seen_at DESC", "id DESC").
+		Scan(ctx)
+	return sessions, err
+}
+
+// DeleteSession revokes a session of a user, sql.ErrNoRows is returned if the
+// user has no such session.
+func (db *DB) DeleteSession(ctx context.Context, userID uuid.UUID, id int64) error {
+	res, err := db.NewDelete().
+		Model((*model.Session)(nil)).
+		Where("id = ? AND user_id = ?", id, userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// DeleteUserSessions signs a user out everywhere except for the session
+// keep, which may be zero.
+func (db *DB) DeleteUserSessions(ctx context.Context, userID uuid.UUID, keep int64) error {
+	_, err := db.NewDelete().
+		Model((*model.Session)(nil)).
+		Where("user_id = ? AND id != ?", userID, keep).
+		Exec(ctx)
+	return err
+}
+
+// DeleteSessionByToken revokes the session of a token, if there is one.
+func (db *DB) DeleteSessionByToken(ctx context.Context, token string) error {
+	_, err := db.NewDelete().
+		Model((*model.Session)(nil)).
+		Where("token_hash = ?", model.HashSessionToken(token)).
+		Exec(ctx)
+	return err
+}
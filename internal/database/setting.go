@@ -0,0 +1,50 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"xiazki/internal/model"
+)
+
+// Setting returns the value of an instance setting, or def if it was never
+// set.
+func (db *DB) Setting(ctx context.Context, key, def string) (string, error) {
+	var setting model.Setting
+	err := db.NewSelect().Model(&setting).Where("key = ?", key).Scan(ctx)
+	if errors.Is(err, sql.ErrNoRows) {
+		return def, nil
+	} else if err != nil {
+		return "", err
+	}
+	return setting.Value, nil
+}
+
+func (db *DB) SetSetting(ctx context.Context, key, value string) error {
+	setting := model.Setting{Key: key, Value: value, UpdatedAt: time.Now()}
+	_, err := db.NewInsert().
+		Model(&setting).
+		On("CONFLICT (key) DO UPDATE").
+		Set("value = EXCLUDED.value").
+		Set("updated_at = EXCLUDED.updated_at").
+		Exec(ctx)
+	return err
+}
+
+// RegistrationMode returns how new users can register, by default anyone can.
+func (db *DB) RegistrationMode(ctx context.Context) (model.RegistrationMode, error) {
+	value, err := db.Setting(ctx, model.SettingRegistration, string(model.RegistrationOpen))
+	if err != nil {
+		return "", err
+	}
+
+	mode := model.RegistrationMode(value)
+	if !slices.Contains(model.RegistrationModes, mode) {
+		return "", fmt.Errorf("invalid registration mode %q", value)
+	}
+	return mode, nil
+}
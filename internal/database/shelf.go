@@ -0,0 +1,140 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+func (db *DB) InsertShelf(ctx context.Context, shelf *model.Shelf) error {
+	_, err := db.NewInsert().Model(shelf).Exec(ctx)
+	return err
+}
+
+// RenameShelf renames a shelf owned by userID, sql.ErrNoRows is returned if
+// there is no such shelf.
+func (db *DB) RenameShelf(ctx context.Context, id int64, userID uuid.UUID, name string) error {
+	res, err := db.NewUpdate().
+		Model((*model.Shelf)(nil)).
+		Set("name = ?", name).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ? AND user_id = ?", id, userID).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// DeleteShelf deletes a shelf owned by userID together with its links to
+// books. The books themselves are kept.
+func (db *DB) DeleteShelf(ctx context.Context, id int64, userID uuid.UUID) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.Shelf)(nil)).
+			Where("id = ? AND user_id = ?", id, userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.ShelfBook)(nil)).
+			Where("shelf_id = ?", id).
+			Exec(ctx)
+		return err
+	})
+}
+
+// AddShelfBook puts the book at the end of the shelf. Adding a book which is
+// already on the shelf does nothing.
+func (db *DB) AddShelfBook(ctx context.Context, shelfID, bookID int64) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		exists, err := tx.NewSelect().
+			Model((*model.ShelfBook)(nil)).
+			Where("shelf_id = ? AND book_id = ?", shelfID, bookID).
+			Exists(ctx)
+		if err != nil || exists {
+			return err
+		}
+
+		var position int64
+		err = tx.NewSelect().
+			Model((*model.ShelfBook)(nil)).
+			ColumnExpr("COALESCE(MAX(position), 0) + 1").
+			Where("shelf_id = ?", shelfID).
+			Scan(ctx, &position)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().
+			Model(&model.ShelfBook{ShelfID: shelfID, BookID: bookID, Position: position}).
+			Exec(ctx)
+		return err
+	})
+}
+
+func (db *DB) RemoveShelfBook(ctx context.Context, shelfID, bookID int64) error {
+	_, err := db.NewDelete().
+		Model((*model.ShelfBook)(nil)).
+		Where("shelf_id = ? AND book_id = ?", shelfID, bookID).
+		Exec(ctx)
+	return err
+}
+
+// MoveShelfBook swaps the book with its neighbour on the shelf, the previous
+// one if up is set and the next one otherwise. Moving the first book up or
+// the last one down does nothing.
+func (db *DB) MoveShelfBook(ctx context.Context, shelfID, bookID int64, up bool) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var current model.ShelfBook
+		err := tx.NewSelect().
+			Model(&current).
+			Where("shelf_id = ? AND book_id = ?", shelfID, bookID).
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		q := tx.NewSelect().
+			Model((*model.ShelfBook)(nil)).
+			Where("shelf_id = ?", shelfID)
+		if up {
+			q = q.Where("position < ?", current.Position).OrderExpr("position DESC")
+		} else {
+			q = q.Where("position > ?", current.Position).OrderExpr("position ASC")
+		}
+
+		var neighbour model.ShelfBook
+		if err := q.Limit(1).Scan(ctx, &neighbour); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil
+			}
+			return err
+		}
+
+		for _, sb := range []struct {
+			bookID   int64
+			position int64
+		}{{current.BookID, neighbour.Position}, {neighbour.BookID, current.Position}} {
+			_, err := tx.NewUpdate().
+				Model((*model.ShelfBook)(nil)).
+				Set("position = ?", sb.position).
+				Where("shelf_id = ? AND book_id = ?", shelfID, sb.bookID).
+				Exec(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// EnableTOTP stores the TOTP secret of a user, replacing their recovery codes
+// with codes.
+func (db *DB) EnableTOTP(ctx context.Context, user *model.User, secret string, codes []*model.RecoveryCode) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("totp_secret = ?", secret).
+			Set("totp_step = 0").
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", user.ID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+
+		if _, err := tx.NewDelete().Model((*model.RecoveryCode)(nil)).Where("user_id = ?", user.ID).Exec(ctx); err != nil {
+			return err
+		}
+		if _, err := tx.NewInsert().Model(&codes).Exec(ctx); err != nil {
+			return err
+		}
+
+		user.TOTPSecret = secret
+		user.TOTPStep = 0
+		return nil
+	})
+}
+
+// DisableTOTP turns off the second factor of a user, sql.ErrNoRows is
+// returned if there is no such user.
+func (db *DB) DisableTOTP(ctx context.Context, id uuid.UUID) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("totp_secret = NULL").
+			Set("totp_step = 0").
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().Model((*model.RecoveryCode)(nil)).Where("user_id = ?", id).Exec(ctx)
+		return err
+	})
+}
+
+// UseTOTPStep records that the code of step was used, it reports false if a
+// code of that or a later step has been used already.
+func (db *DB) UseTOTPStep(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	res, err := db.NewUpdate().
+		Model((*model.User)(nil)).
+		Set("totp_step = ?", step).
+		Where("id = ? AND totp_step < ?", id, step).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode uses up a recovery code of a user, it reports false if the
+// user has no such unused code.
+func (db *DB) UseRecoveryCode(ctx context.Context, userID uuid.UUID, code string) (bool, error) {
+	res, err := db.NewUpdate().
+		Model((*model.RecoveryCode)(nil)).
+		Set("used_at = ?", time.Now()).
+		Where("user_id = ?", userID).
+		Where("code_hash = ?", model.HashRecoveryCode(code)).
+		Where("used_at IS NULL").
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RecoveryCodesLeft counts the unused recovery codes of a user.
+func (db *DB) RecoveryCodesLeft(ctx context.Context, userID uuid.UUID) (int, error) {
+	return db.NewSelect().
+		Model((*model.RecoveryCode)(nil)).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Count(ctx)
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// ErrLastAdmin is returned when a change would leave the instance without an
+// enabled admin.
+var ErrLastAdmin = errors.New("the last admin cannot be demoted or disabled")
+
+// SetUserRole changes the role of a user, sql.ErrNoRows is returned if there
+// is no such user.
+func (db *DB) SetUserRole(ctx context.Context, id uuid.UUID, role model.Role) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if role != model.RoleAdmin {
+			if err := keepAdmin(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("role = ?", role).
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return expectAffected(res)
+	})
+}
+
+// SetUserDisabled disables or enables a user, sql.ErrNoRows is returned if
+// there is no such user.
+func (db *DB) SetUserDisabled(ctx context.Context, id uuid.UUID, disabled bool) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if disabled {
+			if err := keepAdmin(ctx, tx, id); err != nil {
+				return err
+			}
+		}
+
+		res, err := tx.NewUpdate().
+			Model((*model.User)(nil)).
+			Set("disabled = ?", disabled).
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", id).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+		return expectAffected(res)
+	})
+}
+
+// keepAdmin returns ErrLastAdmin if no enabled admin other than id exists.
+func keepAdmin(ctx context.Context, tx bun.Tx, id uuid.UUID) error {
+	admins, err := tx.NewSelect().
+		Model((*model.User)(nil)).
+		Where("role = ?", model.RoleAdmin).
+		Where("NOT disabled").
+		Where("id != ?", id).
+		Count(ctx)
+	if err != nil {
+		return err
+	} else if admins == 0 {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+// UpdateUserPassword stores the password hash of user.
+func (db *DB) UpdateUserPassword(ctx context.Context, user *model.User) error {
+	user.UpdatedAt = time.Now()
+	_, err := db.NewUpdate().Model(user).Column("password", "updated_at").WherePK().Exec(ctx)
+	return err
+}
+
+// InstanceStats counts the rows of the main tables and measures the size of
+// the database.
+func (db *DB) InstanceStats(ctx context.Context) (*model.InstanceStats, error) {
+	var stats model.InstanceStats
+	counts := []struct {
+		model any
+		n     *int
+	}{
+		{(*model.User)(nil), &stats.Users},
+		{(*model.Book)(nil), &stats.Books},
+		{(*model.Event)(nil), &stats.Events},
+		{(*model.Review)(nil), &stats.Reviews},
+		{(*model.Quote)(nil), &stats.Quotes},
+	}
+	for _, c := range counts {
+		n, err := db.NewSelect().Model(c.model).Count(ctx)
+		if err != nil {
+			return nil, err
+		}
+		*c.n = n
+	}
+
+	var err error
+	if db.isSQLite() {
+		err = db.NewRaw("SELECT page_count * page_size FROM pragma_page_count(), pragma_page_size()").Scan(ctx, &stats.DBSize)
+	} else {
+		err = db.NewRaw("SELECT pg_database_size(current_database())").Scan(ctx, &stats.DBSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
@@ -0,0 +1,225 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type withoutWebhooksKey struct{}
+
+// WithoutWebhooks returns a context whose changes queue nothing for webhooks,
+// for imports which would otherwise post every record.
+func WithoutWebhooks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, withoutWebhooksKey{}, true)
+}
+
+// queueWebhooks adds a change to the outbox if its user has a webhook
+// subscribed to it. It runs in the transaction of the change, so only
+// committed changes are delivered.
+func queueWebhooks(ctx context.Context, tx bun.Tx, change *model.WebhookOutbox) error {
+	if change.UserID == uuid.Nil || ctx.Value(withoutWebhooksKey{}) != nil {
+		return nil
+	}
+
+	var hooks []*model.Webhook
+	if err := tx.NewSelect().Model(&hooks).Where("user_id = ?", change.UserID).Scan(ctx); err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(hooks, func(w *model.Webhook) bool { return w.Subscribes(change.Type) }) {
+		return nil
+	}
+	_, err := tx.NewInsert().Model(change).Exec(ctx)
+	return err
+}
+
+// WebhookOutbox returns up to limit queued changes, the oldest first, with
+// their user, book, and event or review. Those deleted since are left nil.
+func (db *DB) WebhookOutbox(ctx context.Context, limit int) ([]*model.WebhookOutbox, error) {
+	var changes []*model.WebhookOutbox
+	err := db.NewSelect().
+		Model(&changes).
+		Relation("User").
+		Relation("Book").
+		Relation("Event").
+		Relation("Review").
+		Order("webhook_outbox.id").
+		Limit(limit).
+		Scan(ctx)
+	return changes, err
+}
+
+// QueueWebhookDeliveries queues payload for the webhooks subscribed to a
+// change and takes the change out of the outbox.
+func (db *DB) QueueWebhookDeliveries(ctx context.Context, change *model.WebhookOutbox, payload string) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var hooks []*model.Webhook
+		if err := tx.NewSelect().Model(&hooks).Where("user_id = ?", change.UserID).Scan(ctx); err != nil {
+			return err
+		}
+
+		var deliveries []*model.WebhookDelivery
+		for _, w := range hooks {
+			if w.Subscribes(change.Type) {
+				deliveries = append(deliveries, newWebhookDelivery(w.ID, change.Type, payload))
+			}
+		}
+		if len(deliveries) > 0 {
+			if _, err := tx.NewInsert().Model(&deliveries).Exec(ctx); err != nil {
+				return err
+			}
+		}
+		return deleteWebhookOutbox(ctx, tx, change.ID)
+	})
+}
+
+// DeleteWebhookOutbox takes a change out of the outbox without delivering
+// it, for changes to data deleted since.
+func (db *DB) DeleteWebhookOutbox(ctx context.Context, id int64) error {
+	return deleteWebhookOutbox(ctx, db, id)
+}
+
+func deleteWebhookOutbox(ctx context.Context, db bun.IDB, id int64) error {
+	_, err := db.NewDelete().
+		Model((*model.WebhookOutbox)(nil)).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+func newWebhookDelivery(webhookID int64, event model.WebhookEvent, payload string) *model.WebhookDelivery {
+	return &model.WebhookDelivery{
+		WebhookID:     webhookID,
+		Event:         event,
+		Payload:       payload,
+		Status:        model.DeliveryPending,
+		NextAttemptAt: time.Now(),
+	}
+}
+
+func (db *DB) InsertWebhook(ctx context.Context, hook *model.Webhook) error {
+	_, err := db.NewInsert().Model(hook).Exec(ctx)
+	return err
+}
+
+// UserWebhooks returns the webhooks of a user, the newest first.
+func (db *DB) UserWebhooks(ctx context.Context, userID uuid.UUID) ([]*model.Webhook, error) {
+	var hooks []*model.Webhook
+	err := db.NewSelect().
+		Model(&hooks).
+		Where("user_id = ?", userID).
+		Order("id DESC").
+		Scan(ctx)
+	return hooks, err
+}
+
+// UserWebhook returns a webhook of a user, sql.ErrNoRows is returned if the
+// user has no such webhook.
+func (db *DB) UserWebhook(ctx context.Context, userID uuid.UUID, id int64) (*model.Webhook, error) {
+	var hook model.Webhook
+	err := db.NewSelect().
+		Model(&hook).
+		Where("id = ? AND user_id = ?", id, userID).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &hook, nil
+}
+
+// DeleteWebhook deletes a webhook of a user with its deliveries, sql.ErrNoRows
+// is returned if the user has no such webhook.
+func (db *DB) DeleteWebhook(ctx context.Context, userID uuid.UUID, id int64) error {
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		res, err := tx.NewDelete().
+			Model((*model.Webhook)(nil)).
+			Where("id = ? AND user_id = ?", id, userID).
+			Exec(ctx)
+		if err != nil {
+			return err
+		} else if err := expectAffected(res); err != nil {
+			return err
+		}
+
+		_, err = tx.NewDelete().
+			Model((*model.WebhookDelivery)(nil)).
+			Where("webhook_id = ?", id).
+			Exec(ctx)
+		return err
+	})
+}
+
+// PingWebhook queues a ping, which users send to try a webhook.
+func (db *DB) PingWebhook(ctx context.Context, hook *model.Webhook, payload string) error {
+	_, err := db.NewInsert().Model(newWebhookDelivery(hook.ID, model.WebhookPing, payload)).Exec(ctx)
+	return err
+}
+
+// WebhookDeliveries returns the latest deliveries of a webhook, the newest
+// first.
+func (db *DB) WebhookDeliveries(ctx context.Context, webhookID int64, limit int) ([]*model.WebhookDelivery, error) {
+	var deliveries []*model.WebhookDelivery
+	err := db.NewSelect().
+		Model(&deliveries).
+		Where("webhook_id = ?", webhookID).
+		Order("id DESC").
+		Limit(limit).
+		Scan(ctx)
+	return deliveries, err
+}
+
+// DueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due, with their webhooks.
+func (db *DB) DueWebhookDeliveries(ctx context.Context, limit int) ([]*model.WebhookDelivery, error) {
+	var deliveries []*model.WebhookDelivery
+	err := db.NewSelect().
+		Model(&deliveries).
+		Relation("Webhook").
+		Where("webhook_delivery.status = ? AND webhook_delivery.next_attempt_at <= ?", model.DeliveryPending, time.Now()).
+		Order("webhook_delivery.next_attempt_at", "webhook_delivery.id").
+		Limit(limit).
+		Scan(ctx)
+	return deliveries, err
+}
+
+// UpdateWebhookDelivery records the outcome of an attempt.
+func (db *DB) UpdateWebhookDelivery(ctx context.Context, d *model.WebhookDelivery) error {
+	_, err := db.NewUpdate().
+		Model(d).
+		Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "error").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// RetryWebhookDelivery queues a delivery of a webhook again with fresh
+// attempts, sql.ErrNoRows is returned if the webhook has no such delivery or
+// it is still pending.
+func (db *DB) RetryWebhookDelivery(ctx context.Context, webhookID, id int64) error {
+	res, err := db.NewUpdate().
+		Model((*model.WebhookDelivery)(nil)).
+		Set("status = ?", model.DeliveryPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", time.Now()).
+		Where("id = ? AND webhook_id = ? AND status != ?", id, webhookID, model.DeliveryPending).
+		Exec(ctx)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// PruneWebhookDeliveries forgets the deliveries which were delivered or given
+// up on before the given time.
+func (db *DB) PruneWebhookDeliveries(ctx context.Context, before time.Time) error {
+	_, err := db.NewDelete().
+		Model((*model.WebhookDelivery)(nil)).
+		Where("status != ? AND last_attempt_at < ?", model.DeliveryPending, before).
+		Exec(ctx)
+	return err
+}
@@ -0,0 +1,212 @@
+package exporter
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"xiazki/internal/model"
+)
+
+// csvHeader matches the field names of the generic CSV importer, so that the
+// flat export can be imported again without mapping any columns. The quotes
+// are written as a JSON array like in the JSON export.
+var csvHeader = []string{
+	"title", "authors", "tags", "translators", "narrators", "summary",
+	"isbn10", "isbn13", "language", "publish_date", "publisher", "page_count",
+	"series_name", "series_number", "cover_url",
+	"rating", "review", "date_started", "date_finished", "status", "quotes",
+}
+
+type csvWriter struct {
+	w *csv.Writer
+}
+
+// newCSVWriter writes the header right away, any write error is reported
+// by Close.
+func newCSVWriter(w io.Writer) *csvWriter {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	_ = c.w.Write(csvHeader)
+	return c
+}
+
+func (c *csvWriter) Write(entry *Entry) error {
+	b := entry.Book
+	record := []string{
+		b.Title,
+		strings.Join(authorNames(b), ", "),
+		strings.Join(tagNames(b), ", "),
+		strings.Join(translatorNames(b), ", "),
+		strings.Join(narratorNames(b), ", "),
+		b.Summary,
+		b.ISBN10,
+		b.ISBN13,
+		b.Language,
+		formatDate(b.PublishDate, "2006-01-02"),
+		b.Publisher,
+		formatInt(b.PageCount),
+		b.SeriesName,
+		formatInt(b.SeriesNumber),
+		b.CoverURL,
+	}
+
+	rating, review := "", ""
+	if entry.Review != nil {
+		rating, review = formatInt(entry.Review.Rating), entry.Review.Opinion
+	}
+	started, finished, status := "", "", ""
+	for _, e := range b.Events {
+		switch e.Type {
+		case model.EventReading:
+			started = e.Date.Format("2006-01-02")
+		case model.EventFinished:
+			finished = e.Date.Format("2006-01-02")
+		}
+	}
+	if last := lastEvent(b.Events); last != nil {
+		status = string(last.Type)
+	}
+	quotes := ""
+	if len(entry.Quotes) > 0 {
+		data, err := json.Marshal(NewBook(entry).Quotes)
+		if err != nil {
+			return err
+		}
+		quotes = string(data)
+	}
+	record = append(record, rating, review, started, finished, status, quotes)
+
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// goodreadsHeader is the header of the goodreads library export.
+var goodreadsHeader = []string{
+	"Book Id", "Title", "Author", "Author l-f", "Additional Authors", "ISBN",
+	"ISBN13", "My Rating", "Average Rating", "Publisher", "Binding",
+	"Number of Pages", "Year Published", "Original Publication Year",
+	"Date Read", "Date Added", "Bookshelves", "Bookshelves with positions",
+	"Exclusive Shelf", "My Review", "Spoiler", "Private Notes", "Read Count",
+	"Owned Copies",
+}
+
+type goodreadsWriter struct {
+	w *csv.Writer
+}
+
+func newGoodreadsWriter(w io.Writer) *goodreadsWriter {
+	g := &goodreadsWriter{w: csv.NewWriter(w)}
+	_ = g.w.Write(goodreadsHeader)
+	return g
+}
+
+func (g *goodreadsWriter) Write(entry *Entry) error {
+	b := entry.Book
+	authors := authorNames(b)
+	author, additional, authorLF := "", "", ""
+	if len(authors) > 0 {
+		author = authors[0]
+		additional = strings.Join(authors[1:], ", ")
+		if i := strings.LastIndex(author, " "); i != -1 {
+			authorLF = author[i+1:] + ", " + author[:i]
+		} else {
+			authorLF = author
+		}
+	}
+
+	title := b.Title
+	if b.SeriesName != "" && b.SeriesNumber > 0 {
+		title = fmt.Sprintf("%s (%s, #%d)", b.Title, b.SeriesName, b.SeriesNumber)
+	}
+
+	rating, review := "0", ""
+	if entry.Review != nil {
+		rating = strconv.FormatInt(int64(math.Round(float64(entry.Review.Rating)/2)), 10)
+		review = strings.ReplaceAll(entry.Review.Opinion, "\n", "<br/>")
+	}
+
+	// goodreads only knows the three exclusive shelves, dropped books stay
+	// on currently-reading and are marked with a shelf of their own
+	shelf, dateRead, readCount := "", "", "0"
+	shelves := append([]string{}, tagNames(b)...)
+	if last := lastEvent(b.Events); last != nil {
+		switch last.Type {
+		case model.EventFinished:
+			shelf, dateRead, readCount = "read", last.Date.Format("2006/01/02"), "1"
+		case model.EventReading:
+			shelf = "currently-reading"
+		case model.EventDropped:
+			shelf = "currently-reading"
+			shelves = append(shelves, "dropped")
+		case model.EventToRead:
+			shelf = "to-read"
+		}
+	}
+	if shelf != "" {
+		shelves = append(shelves, shelf)
+	}
+
+	year := ""
+	if !b.PublishDate.IsZero() {
+		year = strconv.Itoa(b.PublishDate.Year())
+	}
+
+	return g.w.Write([]string{
+		"",
+		title,
+		author,
+		authorLF,
+		additional,
+		goodreadsISBN(b.ISBN10),
+		goodreadsISBN(b.ISBN13),
+		rating,
+		"",
+		b.Publisher,
+		"",
+		formatInt(b.PageCount),
+		year,
+		year,
+		dateRead,
+		formatDate(entry.AddedAt, "2006/01/02"),
+		strings.Join(shelves, ", "),
+		"",
+		shelf,
+		review,
+		"",
+		"",
+		readCount,
+		"0",
+	})
+}
+
+func (g *goodreadsWriter) Close() error {
+	g.w.Flush()
+	return g.w.Error()
+}
+
+func goodreadsISBN(isbn string) string {
+	return `="` + isbn + `"`
+}
+
+func formatInt(n int64) string {
+	if n == 0 {
+		return ""
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+func formatDate(t time.Time, layout string) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(layout)
+}
@@ -0,0 +1,52 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"slices"
+	"testing"
+	"time"
+
+	"xiazki/internal/model"
+)
+
+func TestGoodreadsWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := newGoodreadsWriter(&buf)
+	err := w.Write(&Entry{
+		Book: &model.Book{
+			Title:     "Dune",
+			Tags:      []*model.Tag{{Name: "sf"}},
+			Events:    []*model.Event{{Type: model.EventDropped, Date: time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)}},
+			CreatedAt: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		AddedAt: time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("got %d rows, want the header and the book", len(rows))
+	}
+	get := func(column string) string {
+		return rows[1][slices.Index(goodreadsHeader, column)]
+	}
+	if got := get("Exclusive Shelf"); got != "currently-reading" {
+		t.Errorf("Exclusive Shelf = %q, want currently-reading", got)
+	}
+	if got := get("Bookshelves"); got != "sf, dropped, currently-reading" {
+		t.Errorf("Bookshelves = %q, want sf, dropped, currently-reading", got)
+	}
+	// the date the user added it, not the one it entered the catalog
+	if got := get("Date Added"); got != "2024/03/04" {
+		t.Errorf("Date Added = %q, want 2024/03/04", got)
+	}
+}
@@ -0,0 +1,205 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type Format string
+
+const (
+	FormatJSON      Format = "json"
+	FormatCSV       Format = "csv"
+	FormatGoodreads Format = "goodreads"
+)
+
+var Formats = []Format{FormatJSON, FormatCSV, FormatGoodreads}
+
+func (f Format) ContentType() string {
+	if f == FormatJSON {
+		return "application/json"
+	}
+	return "text/csv"
+}
+
+func (f Format) Extension() string {
+	if f == FormatJSON {
+		return "json"
+	}
+	return "csv"
+}
+
+// batchSize is the number of books loaded at once while exporting.
+const batchSize = 100
+
+// Entry is a book together with the data of the exporting user.
+type Entry struct {
+	Book   *model.Book
+	Review *model.Review
+	Quotes []*model.Quote
+	// AddedAt is when the user added the book to their library.
+	AddedAt time.Time
+}
+
+type writer interface {
+	Write(entry *Entry) error
+	Close() error
+}
+
+// Export writes the library of userID in the given format. Events, reviews and
+// quotes are limited to those of userID. Books are loaded and written in
+// batches, so the output is streamed rather than built in memory.
+func Export(ctx context.Context, db *database.DB, userID uuid.UUID, format Format, w io.Writer) error {
+	var out writer
+	switch format {
+	case FormatJSON:
+		out = newJSONWriter(w)
+	case FormatCSV:
+		out = newCSVWriter(w)
+	case FormatGoodreads:
+		out = newGoodreadsWriter(w)
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+
+	lastID := int64(0)
+	for {
+		entries, err := loadBatch(ctx, db, userID, lastID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := out.Write(entry); err != nil {
+				return err
+			}
+		}
+		if len(entries) < batchSize {
+			break
+		}
+		lastID = entries[len(entries)-1].Book.ID
+	}
+
+	return out.Close()
+}
+
+func loadBatch(ctx context.Context, db *database.DB, userID uuid.UUID, afterID int64) ([]*Entry, error) {
+	var books []*model.Book
+	err := db.NewSelect().
+		Model(&books).
+		Relation("Authors").
+		Relation("Tags").
+		Relation("Translators").
+		Relation("Narrators").
+		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("user_id = ?", userID).OrderExpr("date ASC")
+		}).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			return database.WhereInLibrary(q, userID)
+		}).
+		Where("book.id > ?", afterID).
+		OrderExpr("book.id ASC").
+		Limit(batchSize).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch books: %w", err)
+	}
+	if len(books) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(books))
+	entries := make([]*Entry, len(books))
+	byID := make(map[int64]*Entry, len(books))
+	for i, book := range books {
+		ids[i] = book.ID
+		entries[i] = &Entry{Book: book}
+		byID[book.ID] = entries[i]
+	}
+
+	var library []*model.UserBook
+	err = db.NewSelect().
+		Model(&library).
+		Column("book_id", "created_at").
+		Where("user_id = ? AND book_id IN (?)", userID, bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch library: %w", err)
+	}
+	for _, ub := range library {
+		byID[ub.BookID].AddedAt = ub.CreatedAt
+	}
+
+	var reviews []*model.Review
+	err = db.NewSelect().
+		Model(&reviews).
+		Where("user_id = ? AND book_id IN (?)", userID, bun.In(ids)).
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch reviews: %w", err)
+	}
+	for _, review := range reviews {
+		byID[review.BookID].Review = review
+	}
+
+	var quotes []*model.Quote
+	err = db.NewSelect().
+		Model(&quotes).
+		Where("user_id = ? AND book_id IN (?)", userID, bun.In(ids)).
+		OrderExpr("created_at ASC").
+		Scan(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetch quotes: %w", err)
+	}
+	for _, quote := range quotes {
+		byID[quote.BookID].Quotes = append(byID[quote.BookID].Quotes, quote)
+	}
+
+	return entries, nil
+}
+
+// lastEvent returns the event describing the current state of the book, the
+// same one shown on the book list.
+func lastEvent(events []*model.Event) *model.Event {
+	if len(events) == 0 {
+		return nil
+	}
+	return slices.MaxFunc(events, func(a, b *model.Event) int {
+		if ra, rb := slices.Index(model.EventTypes, a.Type), slices.Index(model.EventTypes, b.Type); ra != rb {
+			return ra - rb
+		}
+		return a.Date.Compare(b.Date)
+	})
+}
+
+func names[T any](items []*T, name func(*T) string) []string {
+	list := make([]string, len(items))
+	for i, item := range items {
+		list[i] = name(item)
+	}
+	return list
+}
+
+func authorNames(book *model.Book) []string {
+	return names(book.Authors, func(a *model.Author) string { return a.Name })
+}
+
+func tagNames(book *model.Book) []string {
+	return names(book.Tags, func(t *model.Tag) string { return t.Name })
+}
+
+func translatorNames(book *model.Book) []string {
+	return names(book.Translators, func(t *model.Translator) string { return t.Name })
+}
+
+func narratorNames(book *model.Book) []string {
+	return names(book.Narrators, func(n *model.Narrator) string { return n.Name })
+}
@@ -0,0 +1,136 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"xiazki/internal/model"
+)
+
+// Version of the JSON export format, bumped on incompatible changes.
+const Version = 1
+
+type Library struct {
+	Version    int       `json:"version"`
+	ExportedAt time.Time `json:"exported_at"`
+	Books      []*Book   `json:"books"`
+}
+
+type Book struct {
+	Title        string   `json:"title"`
+	Authors      []string `json:"authors"`
+	Tags         []string `json:"tags,omitempty"`
+	Translators  []string `json:"translators,omitempty"`
+	Narrators    []string `json:"narrators,omitempty"`
+	Summary      string   `json:"summary,omitempty"`
+	ISBN10       string   `json:"isbn10,omitempty"`
+	ISBN13       string   `json:"isbn13,omitempty"`
+	Language     string   `json:"language,omitempty"`
+	Publisher    string   `json:"publisher,omitempty"`
+	PublishDate  string   `json:"publish_date,omitempty"`
+	PageCount    int64    `json:"page_count,omitempty"`
+	SeriesName   string   `json:"series_name,omitempty"`
+	SeriesNumber int64    `json:"series_number,omitempty"`
+	CoverURL     string   `json:"cover_url,omitempty"`
+
+	Review *Review  `json:"review,omitempty"`
+	Events []*Event `json:"events,omitempty"`
+	Quotes []*Quote `json:"quotes,omitempty"`
+}
+
+type Review struct {
+	Rating  int64  `json:"rating,omitempty"`
+	Opinion string `json:"opinion,omitempty"`
+}
+
+type Event struct {
+	Type model.EventType `json:"type"`
+	Date string          `json:"date"`
+}
+
+type Quote struct {
+	Quote string `json:"quote"`
+	Page  int64  `json:"page,omitempty"`
+	Note  string `json:"note,omitempty"`
+}
+
+func NewBook(entry *Entry) *Book {
+	b := entry.Book
+	book := &Book{
+		Title:        b.Title,
+		Authors:      authorNames(b),
+		Tags:         tagNames(b),
+		Translators:  translatorNames(b),
+		Narrators:    narratorNames(b),
+		Summary:      b.Summary,
+		ISBN10:       b.ISBN10,
+		ISBN13:       b.ISBN13,
+		Language:     b.Language,
+		Publisher:    b.Publisher,
+		PageCount:    b.PageCount,
+		SeriesName:   b.SeriesName,
+		SeriesNumber: b.SeriesNumber,
+		CoverURL:     b.CoverURL,
+	}
+	if !b.PublishDate.IsZero() {
+		book.PublishDate = b.PublishDate.Format("2006-01-02")
+	}
+	if r := entry.Review; r != nil {
+		book.Review = &Review{Rating: r.Rating, Opinion: r.Opinion}
+	}
+	for _, e := range b.Events {
+		book.Events = append(book.Events, &Event{Type: e.Type, Date: e.Date.Format("2006-01-02")})
+	}
+	for _, q := range entry.Quotes {
+		book.Quotes = append(book.Quotes, &Quote{Quote: q.Quote, Page: q.Page, Note: q.Note})
+	}
+	return book
+}
+
+// jsonWriter writes a Library one book at a time.
+type jsonWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func newJSONWriter(w io.Writer) *jsonWriter {
+	return &jsonWriter{w: w}
+}
+
+func (j *jsonWriter) start() error {
+	j.started = true
+	exportedAt, err := json.Marshal(time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, "{\"version\":%d,\"exported_at\":%s,\"books\":[\n", Version, exportedAt)
+	return err
+}
+
+func (j *jsonWriter) Write(entry *Entry) error {
+	sep := ",\n"
+	if !j.started {
+		if err := j.start(); err != nil {
+			return err
+		}
+		sep = ""
+	}
+	data, err := json.Marshal(NewBook(entry))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(j.w, "%s%s", sep, data)
+	return err
+}
+
+func (j *jsonWriter) Close() error {
+	if !j.started {
+		if err := j.start(); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(j.w, "\n]}\n")
+	return err
+}
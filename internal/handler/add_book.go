@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -28,18 +30,46 @@ func (h *Handler) PostAddBook(c echo.Context) error {
 		}))
 	}
 
-	if err := h.db.InsertBook(c, bfv.ToBook()); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add book: "+err.Error())
+	b, _, err := h.addBook(c, bfv)
+	if err != nil {
+		return err
 	}
 
-	return HxRedirect(c, "/books")
+	return HxRedirect(c, "/book/"+strconv.FormatInt(b.ID, 10))
+}
+
+// addBook puts the book into the library of the current user. The catalog is
+// shared, so a book someone already added is only put into the library,
+// created reports whether the book is new.
+func (h *Handler) addBook(c echo.Context, bfv add_book.BookFormValues) (b *model.Book, created bool, err error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, false, err
+	}
+
+	ctx := c.Request().Context()
+	b, err = h.db.FindDuplicateBook(ctx, bfv.ToBook())
+	if errors.Is(err, sql.ErrNoRows) {
+		b = bfv.ToBook()
+		b.AddedByID = user.ID
+		if err := h.db.InsertBook(ctx, b); err != nil {
+			return nil, false, echo.NewHTTPError(http.StatusInternalServerError, "Failed to add book: "+err.Error())
+		}
+		created = true
+	} else if err != nil {
+		return nil, false, echo.NewHTTPError(http.StatusInternalServerError, "Failed to check for duplicates: "+err.Error())
+	}
+
+	if err := h.db.AddLibraryBook(ctx, user.ID, b.ID); err != nil {
+		return nil, false, echo.NewHTTPError(http.StatusInternalServerError, "Failed to add book to library: "+err.Error())
+	}
+	return b, created, nil
 }
 
 func (h *Handler) GetBookEdit(c echo.Context) error {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := h.editableBookID(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+		return err
 	}
 
 	var b model.Book
@@ -64,10 +94,9 @@ func (h *Handler) GetBookEdit(c echo.Context) error {
 }
 
 func (h *Handler) PutBookEdit(c echo.Context) error {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := h.editableBookID(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+		return err
 	}
 
 	var bfv add_book.BookFormValues
@@ -85,9 +114,9 @@ func (h *Handler) PutBookEdit(c echo.Context) error {
 		))
 	}
 
-	if err := h.db.UpdateBook(c, id, bfv.ToBook()); err != nil {
+	if err := h.db.UpdateBook(c.Request().Context(), id, bfv.ToBook()); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update book: "+err.Error())
 	}
 
-	return HxRedirect(c, "/book/"+idStr)
+	return HxRedirect(c, "/book/"+strconv.FormatInt(id, 10))
 }
@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/web/template/admin"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// failedLoginsShown is how many of the last failed logins the admin page
+// lists.
+const failedLoginsShown = 50
+
+func (h *Handler) GetAdmin(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	stats, err := h.db.InstanceStats(ctx)
+	if err != nil {
+		c.Logger().Error("Failed to fetch instance stats: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch instance stats")
+	}
+
+	mode, err := h.db.RegistrationMode(ctx)
+	if err != nil {
+		c.Logger().Error("Failed to check registration: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check registration")
+	}
+
+	invites, err := h.db.PendingInvites(ctx)
+	if err != nil {
+		c.Logger().Error("Failed to fetch invites: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch invites")
+	}
+
+	var users []*model.User
+	if err := h.db.NewSelect().Model(&users).Order("created_at ASC").Scan(ctx); err != nil {
+		c.Logger().Error("Failed to fetch users: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch users")
+	}
+
+	failures, err := h.db.FailedLogins(ctx, failedLoginsShown)
+	if err != nil {
+		c.Logger().Error("Failed to fetch failed logins: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch failed logins")
+	}
+
+	return Render(c, admin.Show(admin.Data{
+		Stats:         stats,
+		Registration:  mode,
+		Invites:       admin.InvitesData{Invites: invites},
+		Users:         users,
+		CurrentUserID: user.ID,
+		FailedLogins:  failures,
+	}))
+}
+
+func (h *Handler) PostAdminRegistration(c echo.Context) error {
+	mode := model.RegistrationMode(c.FormValue("mode"))
+	if !slices.Contains(model.RegistrationModes, mode) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid registration mode")
+	}
+
+	if err := h.db.SetSetting(c.Request().Context(), model.SettingRegistration, string(mode)); err != nil {
+		c.Logger().Error("Failed to update registration: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update registration")
+	}
+
+	return HxRedirect(c, "/admin")
+}
+
+// PostAdminInvite creates an invite and shows its link, which cannot be
+// retrieved later.
+func (h *Handler) PostAdminInvite(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	days, err := strconv.Atoi(c.FormValue("days"))
+	if err != nil || !slices.Contains(admin.InviteDays, days) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid expiry")
+	}
+
+	invite, token, err := model.NewInvite(user.ID, time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.Logger().Error("Failed to generate invite: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create invite")
+	}
+
+	if err := h.db.InsertInvite(c.Request().Context(), invite); err != nil {
+		c.Logger().Error("Failed to create invite: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create invite")
+	}
+
+	link := h.publicURL(c) + "/register?invite=" + token
+	return h.renderInvites(c, link)
+}
+
+func (h *Handler) DeleteAdminInvite(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid invite ID")
+	}
+
+	if err := h.db.DeleteInvite(c.Request().Context(), id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Invite not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete invite: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete invite")
+	}
+
+	return h.renderInvites(c, "")
+}
+
+// DeleteAdminBook deletes a book from the catalog, which takes it out of the
+// libraries of all users and deletes their events, reviews and quotes of it.
+func (h *Handler) DeleteAdminBook(c echo.Context) error {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	if err := h.db.DeleteBook(c.Request().Context(), id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete book")
+	}
+
+	return HxRedirect(c, "/books")
+}
+
+func (h *Handler) renderInvites(c echo.Context, link string) error {
+	invites, err := h.db.PendingInvites(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch invites: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch invites")
+	}
+
+	return Render(c, admin.Invites(admin.InvitesData{Invites: invites, Link: link}))
+}
+
+func (h *Handler) PutAdminUserRole(c echo.Context) error {
+	target, err := h.adminTargetUser(c)
+	if err != nil {
+		return err
+	}
+
+	role := model.Role(c.FormValue("role"))
+	if role != model.RoleUser && role != model.RoleAdmin {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid role")
+	}
+
+	if err := h.db.SetUserRole(c.Request().Context(), target.ID, role); err != nil {
+		return adminUpdateError(c, err)
+	}
+
+	return HxRedirect(c, "/admin")
+}
+
+func (h *Handler) PostAdminUserDisabled(c echo.Context) error {
+	target, err := h.adminTargetUser(c)
+	if err != nil {
+		return err
+	}
+
+	disabled, err := strconv.ParseBool(c.FormValue("disabled"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid value")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.db.SetUserDisabled(ctx, target.ID, disabled); err != nil {
+		return adminUpdateError(c, err)
+	}
+	if disabled {
+		if err := h.db.DeleteUserSessions(ctx, target.ID, 0); err != nil {
+			c.Logger().Error("Failed to delete sessions: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign out user")
+		}
+	}
+
+	return HxRedirect(c, "/admin")
+}
+
+func (h *Handler) GetAdminUserPassword(c echo.Context) error {
+	target, err := h.userByParam(c)
+	if err != nil {
+		return err
+	}
+
+	return Render(c, admin.ResetPasswordModal(admin.ResetPasswordData{User: target}))
+}
+
+func (h *Handler) PostAdminUserPassword(c echo.Context) error {
+	target, err := h.userByParam(c)
+	if err != nil {
+		return err
+	}
+
+	var values admin.ResetPasswordFormValues
+	if err := c.Bind(&values); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	if errors := values.Validate(); len(errors) > 0 {
+		return Render(c, admin.ResetPasswordForm(admin.ResetPasswordData{
+			User:   target,
+			Values: values,
+			Errors: errors,
+		}))
+	}
+
+	if err := target.SetPassword(values.NewPassword); err != nil {
+		c.Logger().Error("Failed to set new password: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set new password")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.db.UpdateUserPassword(ctx, target); err != nil {
+		c.Logger().Error("Failed to update password: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update password")
+	}
+	// admins resetting their own password stay signed in here
+	var keep int64
+	if s, err := h.currentSession(c); err == nil && s.UserID == target.ID {
+		keep = s.ID
+	}
+	if err := h.db.DeleteUserSessions(ctx, target.ID, keep); err != nil {
+		c.Logger().Error("Failed to delete sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign out user")
+	}
+
+	return HxRedirect(c, "/admin")
+}
+
+// userByParam fetches the user from the id path parameter.
+func (h *Handler) userByParam(c echo.Context) (*model.User, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid user ID")
+	}
+
+	var user model.User
+	if err := h.db.NewSelect().Model(&user).Where("id = ?", id).Scan(c.Request().Context()); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "User not found")
+		}
+		c.Logger().Error("Failed to fetch user: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+	}
+	return &user, nil
+}
+
+// adminTargetUser is userByParam for changes admins must not make to their
+// own account, so that they cannot lock themselves out.
+func (h *Handler) adminTargetUser(c echo.Context) (*model.User, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	target, err := h.userByParam(c)
+	if err != nil {
+		return nil, err
+	}
+	if target.ID == user.ID {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "You cannot change your own account")
+	}
+	return target, nil
+}
+
+func adminUpdateError(c echo.Context, err error) error {
+	if errors.Is(err, database.ErrLastAdmin) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	} else if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+	c.Logger().Error("Failed to update user: ", err)
+	return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update user")
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestPostAdminInvite(t *testing.T) {
+	h, db := newTestHandler(t)
+	admin := dbtest.User(t, db, "carol", model.RoleAdmin)
+
+	for _, tt := range []struct {
+		publicURL string
+		want      string
+	}{
+		{"", "http://attacker.example/register?invite="},
+		// the configured URL wins over the Host header
+		{"https://example.com/xiazki", "https://example.com/xiazki/register?invite="},
+	} {
+		h.cfg.PublicURL = tt.publicURL
+		req := formRequest(http.MethodPost, "/admin/invites", url.Values{"days": {"7"}})
+		req.Host = "attacker.example"
+		rec := serve(t, h.PostAdminInvite, admin, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("public URL %q: status = %d:\n%s", tt.publicURL, rec.Code, rec.Body)
+		}
+		if !strings.Contains(rec.Body.String(), tt.want) {
+			t.Errorf("public URL %q: no link starting with %s in\n%s", tt.publicURL, tt.want, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"xiazki/internal/api"
+
+	"github.com/labstack/echo/v4"
+)
+
+const apiPageSize = 30
+
+// apiValidationError is returned by API handlers for invalid input, it maps
+// the invalid fields to what is wrong with them.
+type apiValidationError map[string]string
+
+func (e apiValidationError) Error() string {
+	return "invalid input"
+}
+
+// APIErrors sends the errors returned by the handlers of the API as
+// api.Error bodies instead of the HTML error pages.
+func APIErrors(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		err := next(c)
+		if err == nil || c.Response().Committed {
+			return err
+		}
+
+		detail := api.ErrorDetail{Status: http.StatusInternalServerError, Message: "Internal server error"}
+		var he *echo.HTTPError
+		var ve apiValidationError
+		if errors.As(err, &ve) {
+			detail = api.ErrorDetail{Status: http.StatusUnprocessableEntity, Message: "Invalid input", Fields: ve}
+		} else if errors.As(err, &he) {
+			detail.Status = he.Code
+			detail.Message = fmt.Sprint(he.Message)
+		} else {
+			c.Logger().Error("API request failed: ", err)
+		}
+		return c.JSON(detail.Status, api.Error{Error: detail})
+	}
+}
+
+// RequireAuthAPI is RequireAuth for the API, which answers with an error
+// instead of redirecting to the login page.
+func (h *Handler) RequireAuthAPI(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		if _, err := h.currentUser(c); isUnauthorized(err) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Not authenticated")
+		} else if err != nil {
+			c.Logger().Error("Failed to fetch user: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+		}
+		return next(c)
+	}
+}
+
+// apiPage returns the after cursor and the page size of a list request.
+func apiPage(c echo.Context) (after int64, limit int, err error) {
+	if s := c.QueryParam("after"); s != "" {
+		if after, err = strconv.ParseInt(s, 10, 64); err != nil || after < 1 {
+			return 0, 0, apiValidationError{"after": "Invalid cursor"}
+		}
+	}
+
+	limit = apiPageSize
+	if s := c.QueryParam("limit"); s != "" {
+		if limit, err = strconv.Atoi(s); err != nil || limit < 1 || limit > api.MaxPageSize {
+			return 0, 0, apiValidationError{"limit": fmt.Sprintf("Limit must be between 1 and %d", api.MaxPageSize)}
+		}
+	}
+	return after, limit, nil
+}
+
+// newPage returns the page of items, which were loaded with one more than
+// limit to tell whether there is a next page.
+func newPage[T any](items []T, limit int, id func(T) int64) api.Page[T] {
+	page := api.Page[T]{Items: items}
+	if len(items) > limit {
+		page.Items = items[:limit]
+		page.Next = id(items[limit-1])
+	}
+	if page.Items == nil {
+		page.Items = []T{}
+	}
+	return page
+}
+
+// bindAPI binds the JSON body of a request to v.
+func bindAPI(c echo.Context, v any) error {
+	if err := (&echo.DefaultBinder{}).BindBody(c, v); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	return nil
+}
+
+// apiID returns the ID from the route parameter name.
+func apiID(c echo.Context, name, what string) (int64, error) {
+	id, err := strconv.ParseInt(c.Param(name), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid "+what+" ID")
+	}
+	return id, nil
+}
@@ -0,0 +1,293 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/web/template/add_book"
+	"xiazki/web/template/books"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// GetAPIBooks lists the books in the library of the user, with the filters
+// and sort orders of the books page.
+func (h *Handler) GetAPIBooks(c echo.Context) error {
+	var fv books.FilterValues
+	if err := (&echo.DefaultBinder{}).BindQueryParams(c, &fv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid filters")
+	}
+	if errors := fv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	list, next, err := h.db.ListBooks(ctx, user.ID, fv.ToBookFilter(), model.BookSort(fv.Sort), after, limit)
+	if err != nil {
+		c.Logger().Error("Failed to fetch books: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch books")
+	}
+	if err := h.db.LoadBookNames(ctx, list); err != nil {
+		c.Logger().Error("Failed to fetch books: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch books")
+	}
+
+	page := api.Page[api.Book]{Items: make([]api.Book, 0, len(list)), Next: next}
+	for _, b := range list {
+		page.Items = append(page.Items, api.NewBook(b, user))
+	}
+	return c.JSON(http.StatusOK, page)
+}
+
+func (h *Handler) GetAPIBook(c echo.Context) error {
+	id, err := apiID(c, "id", "book")
+	if err != nil {
+		return err
+	}
+	return h.renderAPIBook(c, http.StatusOK, id)
+}
+
+// PostAPIBooks adds a book to the library of the user, and to the catalog if
+// it is not in there yet.
+func (h *Handler) PostAPIBooks(c echo.Context) error {
+	var in api.BookInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	bfv := bookFormValues(in)
+	if errors := bfv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	b, created, err := h.addBook(c, bfv)
+	if err != nil {
+		return err
+	}
+
+	if !created {
+		return h.renderAPIBook(c, http.StatusOK, b.ID)
+	}
+	c.Response().Header().Set(echo.HeaderLocation, withBasePath(c, "/api/"+api.Version+"/books/"+strconv.FormatInt(b.ID, 10)))
+	return h.renderAPIBook(c, http.StatusCreated, b.ID)
+}
+
+func (h *Handler) PutAPIBook(c echo.Context) error {
+	id, err := h.editableBookID(c)
+	if err != nil {
+		return err
+	}
+
+	var in api.BookInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	bfv := bookFormValues(in)
+	if errors := bfv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	if err := h.db.UpdateBook(c.Request().Context(), id, bfv.ToBook()); err != nil {
+		c.Logger().Error("Failed to update book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update book")
+	}
+	return h.renderAPIBook(c, http.StatusOK, id)
+}
+
+// DeleteAPIBook removes a book from the library of the user, the book stays
+// in the catalog.
+func (h *Handler) DeleteAPIBook(c echo.Context) error {
+	user, id, err := h.libraryParams(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.RemoveLibraryBook(c.Request().Context(), user.ID, id); err != nil {
+		c.Logger().Error("Failed to remove book from library: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove book from library")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// renderAPIBook sends the book with all its relations and the events of the
+// user.
+func (h *Handler) renderAPIBook(c echo.Context, status int, id int64) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var b model.Book
+	err = h.db.NewSelect().
+		Model(&b).
+		Where("id = ?", id).
+		Relation("Authors").
+		Relation("Tags").
+		Relation("Translators").
+		Relation("Narrators").
+		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("user_id = ?", user.ID).OrderExpr("date ASC")
+		}).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch book details: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book details")
+	}
+
+	return c.JSON(status, api.NewBook(&b, user))
+}
+
+// bookFormValues converts the body of an API request to the values of the
+// book form, so that both are validated the same way.
+func bookFormValues(in api.BookInput) add_book.BookFormValues {
+	bfv := add_book.BookFormValues{
+		Title:       in.Title,
+		Authors:     strings.Join(in.Authors, ", "),
+		Tags:        strings.Join(in.Tags, ", "),
+		Translators: strings.Join(in.Translators, ", "),
+		Narrators:   strings.Join(in.Narrators, ", "),
+		Summary:     in.Summary,
+		ISBN10:      in.ISBN10,
+		ISBN13:      in.ISBN13,
+		Language:    in.Language,
+		PublishDate: in.PublishDate,
+		Publisher:   in.Publisher,
+		SeriesName:  in.SeriesName,
+		CoverURL:    in.CoverURL,
+	}
+	if in.PageCount != 0 {
+		bfv.PageCount = strconv.FormatInt(in.PageCount, 10)
+	}
+	if in.SeriesNumber != 0 {
+		bfv.SeriesNumber = strconv.FormatInt(in.SeriesNumber, 10)
+	}
+	return bfv
+}
+
+// GetAPIAuthors lists the authors, optionally those whose name contains q.
+func (h *Handler) GetAPIAuthors(c echo.Context) error {
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	var authors []*model.Author
+	err = h.db.NewSelect().
+		Model(&authors).
+		Apply(whereNamePage(c.QueryParam("q"), after)).
+		Order("id ASC").
+		Limit(limit + 1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch authors: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch authors")
+	}
+
+	items := make([]api.Author, 0, len(authors))
+	for _, a := range authors {
+		items = append(items, api.Author{ID: a.ID, Name: a.Name})
+	}
+	return c.JSON(http.StatusOK, newPage(items, limit, func(a api.Author) int64 { return a.ID }))
+}
+
+func (h *Handler) GetAPIAuthor(c echo.Context) error {
+	id, err := apiID(c, "id", "author")
+	if err != nil {
+		return err
+	}
+
+	var a model.Author
+	err = h.db.NewSelect().
+		Model(&a).
+		Where("id = ?", id).
+		Relation("Books", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Column("id", "title").Order("title ASC")
+		}).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Author not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch author: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch author")
+	}
+
+	author := api.Author{ID: a.ID, Name: a.Name, Books: make([]api.BookSummary, 0, len(a.Books))}
+	for _, b := range a.Books {
+		author.Books = append(author.Books, api.BookSummary{ID: b.ID, Title: b.Title})
+	}
+	return c.JSON(http.StatusOK, author)
+}
+
+// GetAPITags lists the tags, optionally those whose name contains q.
+func (h *Handler) GetAPITags(c echo.Context) error {
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	var tags []*model.Tag
+	err = h.db.NewSelect().
+		Model(&tags).
+		Apply(whereNamePage(c.QueryParam("q"), after)).
+		Order("id ASC").
+		Limit(limit + 1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch tags: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch tags")
+	}
+
+	items := make([]api.Name, 0, len(tags))
+	for _, t := range tags {
+		items = append(items, api.Name{ID: t.ID, Name: t.Name})
+	}
+	return c.JSON(http.StatusOK, newPage(items, limit, func(t api.Name) int64 { return t.ID }))
+}
+
+// whereNamePage limits a query on authors or tags to those after the cursor
+// whose name contains q.
+func whereNamePage(q string, after int64) func(*bun.SelectQuery) *bun.SelectQuery {
+	return func(sq *bun.SelectQuery) *bun.SelectQuery {
+		if q = strings.TrimSpace(q); q != "" {
+			sq = sq.Where("LOWER(name) LIKE ? ESCAPE '\\'", database.Contains(q))
+		}
+		if after != 0 {
+			sq = sq.Where("id > ?", after)
+		}
+		return sq
+	}
+}
+
+func (h *Handler) GetAPISearch(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	results, err := h.db.Search(c.Request().Context(), user.ID, strings.TrimSpace(c.QueryParam("q")), searchLimit)
+	if err != nil {
+		c.Logger().Error("Failed to search: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to search")
+	}
+	return c.JSON(http.StatusOK, api.NewSearchResults(results))
+}
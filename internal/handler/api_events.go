@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"xiazki/internal/api"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/web/template/book"
+
+	"github.com/labstack/echo/v4"
+)
+
+// GetAPIBookEvents lists the events of the user for a book, oldest first.
+func (h *Handler) GetAPIBookEvents(c echo.Context) error {
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var events []*model.Event
+	err = h.db.NewSelect().
+		Model(&events).
+		Where("book_id = ? AND user_id = ?", b.ID, user.ID).
+		OrderExpr("date ASC").
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch events: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch events")
+	}
+
+	items := make([]api.Event, 0, len(events))
+	for _, e := range events {
+		items = append(items, api.NewEvent(e))
+	}
+	return c.JSON(http.StatusOK, items)
+}
+
+// PostAPIBookEvents adds an event of the user for a book. Events which
+// contradict the existing ones, like finishing a book before reading it, are
+// rejected with a conflict.
+func (h *Handler) PostAPIBookEvents(c echo.Context) error {
+	var in api.EventInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	efv := book.EventFormValues{Type: string(in.Type), Date: in.Date}
+	if errors := efv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	event := efv.ToEvent()
+	if err := h.db.InsertEvent(c.Request().Context(), b, user, event); errors.Is(err, database.ErrEventConflict) {
+		return echo.NewHTTPError(http.StatusConflict, "Event conflicts with existing events")
+	} else if err != nil {
+		c.Logger().Error("Failed to add event: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add event")
+	}
+	return c.JSON(http.StatusCreated, api.NewEvent(event))
+}
+
+func (h *Handler) DeleteAPIEvent(c echo.Context) error {
+	event, err := h.userEvent(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = h.db.NewDelete().
+		Model((*model.Event)(nil)).
+		Where("id = ? AND user_id = ?", event.ID, event.UserID).
+		Exec(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to delete event: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete event")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// apiBook fetches the ID of the book from the :id route parameter, making sure
+// it exists.
+func (h *Handler) apiBook(c echo.Context) (*model.Book, error) {
+	id, err := apiID(c, "id", "book")
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := h.db.NewSelect().
+		Model((*model.Book)(nil)).
+		Where("id = ?", id).
+		Exists(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch book: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book")
+	} else if !exists {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+	return &model.Book{ID: id}, nil
+}
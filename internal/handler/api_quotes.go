@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/web/template/quotes"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// GetAPIQuotes lists the quotes of the user, newest first, optionally those
+// whose text, note or book title contains q.
+func (h *Handler) GetAPIQuotes(c echo.Context) error {
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+
+	var q []*model.Quote
+	err = h.db.NewSelect().
+		Model(&q).
+		Relation("Book", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Column("id")
+		}).
+		Where("quote.user_id = ?", user.ID).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			if after != 0 {
+				q = q.Where("quote.id < ?", after)
+			}
+			if query == "" {
+				return q
+			}
+			pattern := database.Contains(query)
+			return q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.
+					Where("LOWER(quote.quote) LIKE ? ESCAPE '\\'", pattern).
+					WhereOr("LOWER(quote.note) LIKE ? ESCAPE '\\'", pattern).
+					WhereOr("LOWER(book.title) LIKE ? ESCAPE '\\'", pattern)
+			})
+		}).
+		Order("quote.id DESC").
+		Limit(limit + 1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch quotes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotes")
+	}
+
+	return c.JSON(http.StatusOK, quotePage(q, limit))
+}
+
+// GetAPIBookQuotes lists the quotes of the user from a book, newest first.
+func (h *Handler) GetAPIBookQuotes(c echo.Context) error {
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var q []*model.Quote
+	err = h.db.NewSelect().
+		Model(&q).
+		Where("book_id = ? AND user_id = ?", b.ID, user.ID).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			if after != 0 {
+				return q.Where("id < ?", after)
+			}
+			return q
+		}).
+		Order("id DESC").
+		Limit(limit + 1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch quotes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotes")
+	}
+
+	return c.JSON(http.StatusOK, quotePage(q, limit))
+}
+
+func quotePage(q []*model.Quote, limit int) api.Page[api.Quote] {
+	items := make([]api.Quote, 0, len(q))
+	for _, quote := range q {
+		items = append(items, api.NewQuote(quote))
+	}
+	return newPage(items, limit, func(q api.Quote) int64 { return q.ID })
+}
+
+func (h *Handler) PostAPIBookQuotes(c echo.Context) error {
+	var in api.QuoteInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	qfv := quoteFormValues(in)
+	if errors := qfv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	quote := qfv.ToQuote()
+	quote.UserID = user.ID
+	quote.BookID = b.ID
+	if err := h.db.InsertQuote(c.Request().Context(), quote); err != nil {
+		c.Logger().Error("Failed to add quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add quote")
+	}
+
+	bookID := strconv.FormatInt(b.ID, 10)
+	c.Response().Header().Set(echo.HeaderLocation, withBasePath(c, "/api/"+api.Version+"/books/"+bookID+"/quotes/"+strconv.FormatInt(quote.ID, 10)))
+	return h.renderAPIQuote(c, http.StatusCreated, quote.ID)
+}
+
+func (h *Handler) GetAPIBookQuote(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, api.NewQuote(quote))
+}
+
+func (h *Handler) PutAPIBookQuote(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+
+	var in api.QuoteInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	qfv := quoteFormValues(in)
+	if errors := qfv.Validate(); len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	updated := qfv.ToQuote()
+	updated.ID = quote.ID
+	updated.UserID = quote.UserID
+	if err := h.db.UpdateQuote(c.Request().Context(), updated); err != nil {
+		c.Logger().Error("Failed to update quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update quote")
+	}
+	return h.renderAPIQuote(c, http.StatusOK, quote.ID)
+}
+
+func (h *Handler) DeleteAPIBookQuote(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DeleteQuote(c.Request().Context(), quote.ID, quote.UserID); err != nil {
+		c.Logger().Error("Failed to delete quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete quote")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// renderAPIQuote sends the quote as stored, with the timestamps set by the
+// database.
+func (h *Handler) renderAPIQuote(c echo.Context, status int, id int64) error {
+	var quote model.Quote
+	err := h.db.NewSelect().
+		Model(&quote).
+		Where("id = ?", id).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quote")
+	}
+	return c.JSON(status, api.NewQuote(&quote))
+}
+
+// quoteFormValues converts the body of an API request to the values of the
+// quote form, so that both are validated the same way.
+func quoteFormValues(in api.QuoteInput) quotes.QuoteFormValues {
+	qfv := quotes.QuoteFormValues{Quote: in.Quote, Note: in.Note}
+	if in.Page != 0 {
+		qfv.Page = strconv.FormatInt(in.Page, 10)
+	}
+	return qfv
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+// GetAPIBookReviews lists the reviews of a book by all users.
+func (h *Handler) GetAPIBookReviews(c echo.Context) error {
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	after, limit, err := apiPage(c)
+	if err != nil {
+		return err
+	}
+
+	var reviews []*model.Review
+	err = h.db.NewSelect().
+		Model(&reviews).
+		Relation("User", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Column("id", "username")
+		}).
+		Where("review.book_id = ?", b.ID).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			if after != 0 {
+				return q.Where("review.id > ?", after)
+			}
+			return q
+		}).
+		Order("review.id ASC").
+		Limit(limit + 1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch reviews: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch reviews")
+	}
+
+	items := make([]api.Review, 0, len(reviews))
+	for _, r := range reviews {
+		items = append(items, api.NewReview(r))
+	}
+	return c.JSON(http.StatusOK, newPage(items, limit, func(r api.Review) int64 { return r.ID }))
+}
+
+// GetAPIBookReview returns the review of a book by the user.
+func (h *Handler) GetAPIBookReview(c echo.Context) error {
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+	return h.renderAPIReview(c, b.ID)
+}
+
+// PutAPIBookReview sets the rating and opinion of the user for a book.
+func (h *Handler) PutAPIBookReview(c echo.Context) error {
+	var in api.ReviewInput
+	if err := bindAPI(c, &in); err != nil {
+		return err
+	}
+
+	errors := make(map[string]string)
+	if in.Rating < 0 || in.Rating > 10 {
+		errors["rating"] = "Rating must be between 0 and 10"
+	}
+	if len(in.Opinion) > 10000 {
+		errors["opinion"] = "Opinion must be at most 10000 characters"
+	}
+	if len(errors) > 0 {
+		return apiValidationError(errors)
+	}
+
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.InsertOrUpdateReview(c.Request().Context(), &model.Review{
+		UserID:  user.ID,
+		BookID:  b.ID,
+		Rating:  in.Rating,
+		Opinion: strings.TrimSpace(in.Opinion),
+	}, true, true); err != nil {
+		c.Logger().Error("Failed to submit review: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit review")
+	}
+	return h.renderAPIReview(c, b.ID)
+}
+
+func (h *Handler) DeleteAPIBookReview(c echo.Context) error {
+	b, err := h.apiBook(c)
+	if err != nil {
+		return err
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DeleteReview(c.Request().Context(), user.ID, b.ID); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Review not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete review: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete review")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// renderAPIReview sends the review of the book by the user.
+func (h *Handler) renderAPIReview(c echo.Context, bookID int64) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var review model.Review
+	err = h.db.NewSelect().
+		Model(&review).
+		Where("book_id = ? AND user_id = ?", bookID, user.ID).
+		Limit(1).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Review not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch review: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch review")
+	}
+
+	review.User = user
+	return c.JSON(http.StatusOK, api.NewReview(&review))
+}
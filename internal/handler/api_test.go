@@ -0,0 +1,352 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"xiazki/internal/api"
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+// callAPI runs fn behind the middleware of the API with token and returns
+// the status, decoding the body into out on success. Errors must have the
+// body of the API.
+func callAPI(t *testing.T, h *Handler, fn echo.HandlerFunc, token string, req *http.Request, out any, params ...string) (int, http.Header) {
+	t.Helper()
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+	rec := serve(t, APIErrors(h.TokenAuth(h.RequireAuthAPI(fn))), nil, req, params...)
+
+	if rec.Code >= 400 {
+		var e api.Error
+		if err := json.Unmarshal(rec.Body.Bytes(), &e); err != nil || e.Error.Status != rec.Code || e.Error.Message == "" {
+			t.Errorf("%s %s: error body = %s, want one with status %d", req.Method, req.URL, rec.Body, rec.Code)
+		}
+	} else if out != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v:\n%s", req.Method, req.URL, err, rec.Body)
+		}
+	}
+	return rec.Code, rec.Header()
+}
+
+func TestAPIBooks(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	alice := newAPIToken(t, db, u.alice, model.APIScopeWrite)
+	bob := newAPIToken(t, db, u.bob, model.APIScopeWrite)
+	carol := newAPIToken(t, db, u.carol, model.APIScopeWrite)
+
+	var created api.Book
+	code, header := callAPI(t, h, h.PostAPIBooks, alice,
+		jsonRequest(http.MethodPost, "/api/v1/books", `{"title": "Dune", "authors": ["Frank Herbert"], "page_count": 412}`), &created)
+	if code != http.StatusCreated {
+		t.Fatalf("create: status = %d, want %d", code, http.StatusCreated)
+	}
+	id := strconv.FormatInt(created.ID, 10)
+	if loc := header.Get(echo.HeaderLocation); loc != "/api/v1/books/"+id {
+		t.Errorf("create: location = %q", loc)
+	}
+	if created.Title != "Dune" || len(created.Authors) != 1 || created.Authors[0].Name != "Frank Herbert" || created.PageCount != 412 || !created.Editable {
+		t.Errorf("create: book = %+v", created)
+	}
+
+	// the same book again is not added twice
+	var again api.Book
+	code, _ = callAPI(t, h, h.PostAPIBooks, bob,
+		jsonRequest(http.MethodPost, "/api/v1/books", `{"title": "Dune", "authors": ["Frank Herbert"]}`), &again)
+	if code != http.StatusOK || again.ID != created.ID {
+		t.Errorf("create again: status = %d, id = %d, want %d and %d", code, again.ID, http.StatusOK, created.ID)
+	}
+
+	code, _ = callAPI(t, h, h.PostAPIBooks, alice, jsonRequest(http.MethodPost, "/api/v1/books", `{"authors": ["Nobody"]}`), nil)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("create without title: status = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+
+	var list api.Page[api.Book]
+	if code, _ = callAPI(t, h, h.GetAPIBooks, alice, jsonRequest(http.MethodGet, "/api/v1/books", ""), &list); code != http.StatusOK || len(list.Items) != 1 {
+		t.Errorf("list: status = %d, items = %+v", code, list.Items)
+	}
+
+	// only the adder and admins may change a book
+	update := `{"title": "Dune Messiah", "authors": ["Frank Herbert"]}`
+	for _, tt := range []struct {
+		name  string
+		token string
+		want  int
+	}{
+		{"other user", bob, http.StatusForbidden},
+		{"adder", alice, http.StatusOK},
+		{"admin", carol, http.StatusOK},
+	} {
+		var got api.Book
+		code, _ := callAPI(t, h, h.PutAPIBook, tt.token, jsonRequest(http.MethodPut, "/api/v1/books/"+id, update), &got, "id", id)
+		if code != tt.want {
+			t.Errorf("update by %s: status = %d, want %d", tt.name, code, tt.want)
+		} else if code == http.StatusOK && got.Title != "Dune Messiah" {
+			t.Errorf("update by %s: title = %q", tt.name, got.Title)
+		}
+	}
+	var got api.Book
+	if code, _ = callAPI(t, h, h.GetAPIBook, bob, jsonRequest(http.MethodGet, "/api/v1/books/"+id, ""), &got, "id", id); code != http.StatusOK || got.Title != "Dune Messiah" {
+		t.Errorf("get: status = %d, title = %q", code, got.Title)
+	}
+
+	if code, _ = callAPI(t, h, h.DeleteAPIBook, alice, jsonRequest(http.MethodDelete, "/api/v1/books/"+id, ""), nil, "id", id); code != http.StatusNoContent {
+		t.Errorf("delete: status = %d, want %d", code, http.StatusNoContent)
+	}
+	list = api.Page[api.Book]{}
+	if callAPI(t, h, h.GetAPIBooks, alice, jsonRequest(http.MethodGet, "/api/v1/books", ""), &list); len(list.Items) != 0 {
+		t.Errorf("list after delete: items = %+v", list.Items)
+	}
+	// the book stays in the catalog
+	if code, _ = callAPI(t, h, h.GetAPIBook, alice, jsonRequest(http.MethodGet, "/api/v1/books/"+id, ""), nil, "id", id); code != http.StatusOK {
+		t.Errorf("get after delete: status = %d, want %d", code, http.StatusOK)
+	}
+
+	for _, tt := range []struct {
+		name string
+		fn   echo.HandlerFunc
+		id   string
+		want int
+	}{
+		{"get missing", h.GetAPIBook, "999", http.StatusNotFound},
+		{"update missing", h.PutAPIBook, "999", http.StatusNotFound},
+		{"delete missing", h.DeleteAPIBook, "999", http.StatusNotFound},
+		{"get invalid", h.GetAPIBook, "dune", http.StatusBadRequest},
+	} {
+		req := jsonRequest(http.MethodPut, "/api/v1/books/"+tt.id, update)
+		if code, _ := callAPI(t, h, tt.fn, alice, req, nil, "id", tt.id); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestAPIEvents(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	alice := newAPIToken(t, db, u.alice, model.APIScopeWrite)
+	bob := newAPIToken(t, db, u.bob, model.APIScopeWrite)
+	id := strconv.FormatInt(insertBook(t, db, u.alice, "Dune").ID, 10)
+
+	post := func(token, id, body string) (int, api.Event) {
+		t.Helper()
+		var event api.Event
+		code, _ := callAPI(t, h, h.PostAPIBookEvents, token, jsonRequest(http.MethodPost, "/api/v1/books/"+id+"/events", body), &event, "id", id)
+		return code, event
+	}
+	list := func(token string) []api.Event {
+		t.Helper()
+		var events []api.Event
+		if code, _ := callAPI(t, h, h.GetAPIBookEvents, token, jsonRequest(http.MethodGet, "/api/v1/books/"+id+"/events", ""), &events, "id", id); code != http.StatusOK {
+			t.Fatalf("list: status = %d", code)
+		}
+		return events
+	}
+
+	code, reading := post(alice, id, `{"type": "reading", "date": "2024-01-10"}`)
+	if code != http.StatusCreated || reading.Type != model.EventReading || reading.Date != "2024-01-10" {
+		t.Fatalf("reading: status = %d, event = %+v", code, reading)
+	}
+
+	// finishing a book before starting it contradicts the reading event
+	if code, _ := post(alice, id, `{"type": "finished", "date": "2024-01-05"}`); code != http.StatusConflict {
+		t.Errorf("finished before reading: status = %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := post(alice, id, `{"type": "to-read", "date": "2024-01-10"}`); code != http.StatusConflict {
+		t.Errorf("to-read on reading: status = %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := post(alice, id, `{"type": "finished", "date": "2024-02-01"}`); code != http.StatusCreated {
+		t.Errorf("finished: status = %d, want %d", code, http.StatusCreated)
+	}
+	if code, _ := post(alice, id, `{"type": "reading", "date": "2024-03-01"}`); code != http.StatusConflict {
+		t.Errorf("reading after finished: status = %d, want %d", code, http.StatusConflict)
+	}
+	if code, _ := post(alice, id, `{"type": "lent", "date": "2024-03-01"}`); code != http.StatusUnprocessableEntity {
+		t.Errorf("unknown type: status = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	if code, _ := post(alice, "999", `{"type": "reading", "date": "2024-03-01"}`); code != http.StatusNotFound {
+		t.Errorf("missing book: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	// events are listed oldest first, and only those of the user
+	if events := list(alice); len(events) != 2 || events[0].ID != reading.ID || events[1].Type != model.EventFinished {
+		t.Errorf("events of alice = %+v", events)
+	}
+	if events := list(bob); len(events) != 0 {
+		t.Errorf("events of bob = %+v", events)
+	}
+
+	eventID := strconv.FormatInt(reading.ID, 10)
+	for _, tt := range []struct {
+		name  string
+		token string
+		id    string
+		want  int
+	}{
+		{"other user", bob, eventID, http.StatusForbidden},
+		{"owner", alice, eventID, http.StatusNoContent},
+		{"deleted", alice, eventID, http.StatusNotFound},
+		{"invalid", alice, "reading", http.StatusBadRequest},
+	} {
+		req := jsonRequest(http.MethodDelete, "/api/v1/events/"+tt.id, "")
+		if code, _ := callAPI(t, h, h.DeleteAPIEvent, tt.token, req, nil, "id", tt.id); code != tt.want {
+			t.Errorf("delete by %s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if events := list(alice); len(events) != 1 {
+		t.Errorf("events after delete = %+v", events)
+	}
+}
+
+func TestAPIReviews(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	alice := newAPIToken(t, db, u.alice, model.APIScopeWrite)
+	bob := newAPIToken(t, db, u.bob, model.APIScopeWrite)
+	id := strconv.FormatInt(insertBook(t, db, u.alice, "Dune").ID, 10)
+	path := "/api/v1/books/" + id + "/review"
+
+	get := func(token string) (int, api.Review) {
+		t.Helper()
+		var review api.Review
+		code, _ := callAPI(t, h, h.GetAPIBookReview, token, jsonRequest(http.MethodGet, path, ""), &review, "id", id)
+		return code, review
+	}
+
+	if code, _ := get(alice); code != http.StatusNotFound {
+		t.Errorf("get before review: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	var review api.Review
+	code, _ := callAPI(t, h, h.PutAPIBookReview, alice, jsonRequest(http.MethodPut, path, `{"rating": 8, "opinion": " spice "}`), &review, "id", id)
+	if code != http.StatusOK || review.Rating != 8 || review.Opinion != "spice" || review.Username != "alice" {
+		t.Fatalf("put: status = %d, review = %+v", code, review)
+	}
+	code, _ = callAPI(t, h, h.PutAPIBookReview, alice, jsonRequest(http.MethodPut, path, `{"rating": 11}`), nil, "id", id)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("put rating 11: status = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	code, _ = callAPI(t, h, h.PutAPIBookReview, alice, jsonRequest(http.MethodPut, "/api/v1/books/999/review", `{"rating": 8}`), nil, "id", "999")
+	if code != http.StatusNotFound {
+		t.Errorf("put on missing book: status = %d, want %d", code, http.StatusNotFound)
+	}
+	if code, got := get(alice); code != http.StatusOK || got.ID != review.ID || got.Rating != 8 {
+		t.Errorf("get: status = %d, review = %+v", code, got)
+	}
+	if code, _ := get(bob); code != http.StatusNotFound {
+		t.Errorf("get by other user: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	callAPI(t, h, h.PutAPIBookReview, bob, jsonRequest(http.MethodPut, path, `{"rating": 4}`), nil, "id", id)
+	var page api.Page[api.Review]
+	code, _ = callAPI(t, h, h.GetAPIBookReviews, bob, jsonRequest(http.MethodGet, "/api/v1/books/"+id+"/reviews", ""), &page, "id", id)
+	if code != http.StatusOK || len(page.Items) != 2 || page.Items[0].Username != "alice" || page.Items[1].Username != "bob" {
+		t.Errorf("list: status = %d, items = %+v", code, page.Items)
+	}
+
+	for _, tt := range []struct {
+		name string
+		want int
+	}{
+		{"delete", http.StatusNoContent},
+		{"delete again", http.StatusNotFound},
+	} {
+		if code, _ := callAPI(t, h, h.DeleteAPIBookReview, alice, jsonRequest(http.MethodDelete, path, ""), nil, "id", id); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+	if code, got := get(bob); code != http.StatusOK || got.Rating != 4 {
+		t.Errorf("review of bob after delete: status = %d, review = %+v", code, got)
+	}
+}
+
+func TestAPIQuotes(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	alice := newAPIToken(t, db, u.alice, model.APIScopeWrite)
+	bob := newAPIToken(t, db, u.bob, model.APIScopeWrite)
+	carol := newAPIToken(t, db, u.carol, model.APIScopeWrite)
+	id := strconv.FormatInt(insertBook(t, db, u.alice, "Dune").ID, 10)
+	otherID := strconv.FormatInt(insertBook(t, db, u.alice, "Emma").ID, 10)
+
+	var created api.Quote
+	code, header := callAPI(t, h, h.PostAPIBookQuotes, alice,
+		jsonRequest(http.MethodPost, "/api/v1/books/"+id+"/quotes", `{"quote": "Fear is the mind-killer.", "page": 8}`), &created, "id", id)
+	if code != http.StatusCreated || created.Quote != "Fear is the mind-killer." || created.Page != 8 {
+		t.Fatalf("create: status = %d, quote = %+v", code, created)
+	}
+	quoteID := strconv.FormatInt(created.ID, 10)
+	path := "/api/v1/books/" + id + "/quotes/" + quoteID
+	if loc := header.Get(echo.HeaderLocation); loc != path {
+		t.Errorf("create: location = %q, want %q", loc, path)
+	}
+	code, _ = callAPI(t, h, h.PostAPIBookQuotes, alice, jsonRequest(http.MethodPost, "/api/v1/books/"+id+"/quotes", `{"note": "empty"}`), nil, "id", id)
+	if code != http.StatusUnprocessableEntity {
+		t.Errorf("create without quote: status = %d, want %d", code, http.StatusUnprocessableEntity)
+	}
+	code, _ = callAPI(t, h, h.PostAPIBookQuotes, alice, jsonRequest(http.MethodPost, "/api/v1/books/999/quotes", `{"quote": "None"}`), nil, "id", "999")
+	if code != http.StatusNotFound {
+		t.Errorf("create on missing book: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	var updated api.Quote
+	code, _ = callAPI(t, h, h.PutAPIBookQuote, alice, jsonRequest(http.MethodPut, path, `{"quote": "Fear is the mind-killer.", "note": "Litany"}`), &updated, "id", id, "quote_id", quoteID)
+	if code != http.StatusOK || updated.ID != created.ID || updated.Note != "Litany" || updated.Page != 0 {
+		t.Errorf("update: status = %d, quote = %+v", code, updated)
+	}
+
+	var got api.Quote
+	if code, _ = callAPI(t, h, h.GetAPIBookQuote, alice, jsonRequest(http.MethodGet, path, ""), &got, "id", id, "quote_id", quoteID); code != http.StatusOK || got.Note != "Litany" {
+		t.Errorf("get: status = %d, quote = %+v", code, got)
+	}
+	var page api.Page[api.Quote]
+	code, _ = callAPI(t, h, h.GetAPIBookQuotes, alice, jsonRequest(http.MethodGet, "/api/v1/books/"+id+"/quotes", ""), &page, "id", id)
+	if code != http.StatusOK || len(page.Items) != 1 || page.Items[0].ID != created.ID {
+		t.Errorf("list: status = %d, items = %+v", code, page.Items)
+	}
+
+	// quotes are private, other users and admins do not find them, nor does
+	// the path of another book
+	for _, tt := range []struct {
+		name   string
+		token  string
+		bookID string
+	}{
+		{"other user", bob, id},
+		{"admin", carol, id},
+		{"other book", alice, otherID},
+	} {
+		for _, r := range []struct {
+			method string
+			fn     echo.HandlerFunc
+		}{
+			{http.MethodGet, h.GetAPIBookQuote},
+			{http.MethodPut, h.PutAPIBookQuote},
+			{http.MethodDelete, h.DeleteAPIBookQuote},
+		} {
+			req := jsonRequest(r.method, "/api/v1/books/"+tt.bookID+"/quotes/"+quoteID, `{"quote": "Changed"}`)
+			if code, _ := callAPI(t, h, r.fn, tt.token, req, nil, "id", tt.bookID, "quote_id", quoteID); code != http.StatusNotFound {
+				t.Errorf("%s %s: status = %d, want %d", r.method, tt.name, code, http.StatusNotFound)
+			}
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		fn   echo.HandlerFunc
+		want int
+	}{
+		{"delete", h.DeleteAPIBookQuote, http.StatusNoContent},
+		{"get deleted", h.GetAPIBookQuote, http.StatusNotFound},
+		{"delete again", h.DeleteAPIBookQuote, http.StatusNotFound},
+	} {
+		if code, _ := callAPI(t, h, tt.fn, alice, jsonRequest(http.MethodDelete, path, ""), nil, "id", id, "quote_id", quoteID); code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"xiazki/internal/model"
+	"xiazki/web/template/profile"
+
+	"github.com/labstack/echo/v4"
+)
+
+// apiTokenTouchInterval is how often the last use of an API token is
+// recorded.
+const apiTokenTouchInterval = time.Minute
+
+// TokenAuth signs in requests carrying an API token in the Authorization
+// header instead of the session, requests without one are left to the
+// session. Tokens which may only read are refused for changes. It must come
+// before RequireAuthAPI.
+func (h *Handler) TokenAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		header := c.Request().Header.Get(echo.HeaderAuthorization)
+		if header == "" {
+			return next(c)
+		}
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid authorization header")
+		}
+
+		ctx := c.Request().Context()
+		t, err := h.db.APITokenByToken(ctx, strings.TrimSpace(token))
+		if errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired token")
+		} else if err != nil {
+			c.Logger().Error("Failed to fetch API token: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch token")
+		}
+
+		var user model.User
+		if err := h.db.NewSelect().Model(&user).Where("id = ?", t.UserID).Scan(ctx); errors.Is(err, sql.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+		} else if err != nil {
+			c.Logger().Error("Failed to fetch user: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+		}
+		if user.Disabled {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Account disabled")
+		}
+
+		if !t.Allows(c.Request().Method) {
+			return echo.NewHTTPError(http.StatusForbidden, "The token may only read")
+		}
+		if err := h.db.TouchAPIToken(ctx, t, apiTokenTouchInterval); err != nil {
+			c.Logger().Error("Failed to update API token: ", err)
+		}
+
+		c.Set(userKey, &user)
+		return next(c)
+	}
+}
+
+// profileAPITokens returns the API tokens of the user for the profile page.
+func (h *Handler) profileAPITokens(c echo.Context) (profile.APITokens, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return profile.APITokens{}, err
+	}
+
+	tokens, err := h.db.UserAPITokens(c.Request().Context(), user.ID)
+	return profile.APITokens{Tokens: tokens}, err
+}
+
+// PostProfileAPIToken creates an API token, which is shown once.
+func (h *Handler) PostProfileAPIToken(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var atfv profile.APITokenFormValues
+	if err := c.Bind(&atfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	data, err := h.profileAPITokens(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch API tokens: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch API tokens")
+	}
+
+	if errors := atfv.Validate(); len(errors) > 0 {
+		data.Values = atfv
+		data.Errors = errors
+		return Render(c, profile.APITokenList(data))
+	}
+
+	days, _ := strconv.Atoi(atfv.Days)
+	t, token, err := model.NewAPIToken(user.ID, strings.TrimSpace(atfv.Name), model.APIScope(atfv.Scope), time.Duration(days)*24*time.Hour)
+	if err != nil {
+		c.Logger().Error("Failed to generate API token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create API token")
+	}
+	if err := h.db.InsertAPIToken(c.Request().Context(), t); err != nil {
+		c.Logger().Error("Failed to create API token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create API token")
+	}
+
+	data.Tokens = append([]*model.APIToken{t}, data.Tokens...)
+	data.Token = token
+	return Render(c, profile.APITokenList(data))
+}
+
+// DeleteProfileAPIToken revokes an API token of the user.
+func (h *Handler) DeleteProfileAPIToken(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid token ID")
+	}
+
+	if err := h.db.DeleteAPIToken(c.Request().Context(), user.ID, id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Token not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete API token: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete API token")
+	}
+
+	data, err := h.profileAPITokens(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch API tokens: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch API tokens")
+	}
+	return Render(c, profile.APITokenList(data))
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+// serveAPI runs fn behind the middleware of the API, signed in only by the
+// authorization header if there is one.
+func serveAPI(t *testing.T, h *Handler, fn echo.HandlerFunc, authorization string, req *http.Request, params ...string) int {
+	t.Helper()
+	if authorization != "" {
+		req.Header.Set(echo.HeaderAuthorization, authorization)
+	}
+	return serve(t, APIErrors(h.TokenAuth(h.RequireAuthAPI(fn))), nil, req, params...).Code
+}
+
+// newAPIToken adds a token of user and returns it.
+func newAPIToken(t *testing.T, db *database.DB, user *model.User, scope model.APIScope) string {
+	t.Helper()
+	apiToken, token, err := model.NewAPIToken(user.ID, string(scope), scope, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertAPIToken(context.Background(), apiToken); err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenAuth(t *testing.T) {
+	h, db := newTestHandler(t)
+	ctx := context.Background()
+	alice := dbtest.User(t, db, "alice", model.RoleUser)
+	read := newAPIToken(t, db, alice, model.APIScopeRead)
+	write := newAPIToken(t, db, alice, model.APIScopeWrite)
+
+	expired := newAPIToken(t, db, alice, model.APIScopeWrite)
+	_, err := db.NewUpdate().
+		Model((*model.APIToken)(nil)).
+		Set("expires_at = ?", time.Now().Add(-time.Minute)).
+		Where("token_hash = ?", model.HashAPIToken(expired)).
+		Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disabled := dbtest.User(t, db, "bob", model.RoleUser)
+	disabledToken := newAPIToken(t, db, disabled, model.APIScopeWrite)
+	if _, err := db.NewUpdate().Model(disabled).Set("disabled = ?", true).WherePK().Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	book := insertBook(t, db, alice, "Dune")
+	if err := db.AddLibraryBook(ctx, alice.ID, book.ID); err != nil {
+		t.Fatal(err)
+	}
+	id := strconv.FormatInt(book.ID, 10)
+	list := func() *http.Request { return jsonRequest(http.MethodGet, "/api/v1/books", "") }
+	create := func() *http.Request {
+		return jsonRequest(http.MethodPost, "/api/v1/books", `{"title": "Emma", "authors": ["Jane Austen"]}`)
+	}
+	review := func() *http.Request {
+		return jsonRequest(http.MethodPut, "/api/v1/books/"+id+"/review", `{"rating": 6}`)
+	}
+
+	for _, tt := range []struct {
+		name          string
+		authorization string
+		fn            echo.HandlerFunc
+		req           func() *http.Request
+		want          int
+	}{
+		{"no token", "", h.GetAPIBooks, list, http.StatusUnauthorized},
+		{"not bearer", "Token " + read, h.GetAPIBooks, list, http.StatusUnauthorized},
+		{"unknown", "Bearer xz_unknown", h.GetAPIBooks, list, http.StatusUnauthorized},
+		{"expired", "Bearer " + expired, h.GetAPIBooks, list, http.StatusUnauthorized},
+		{"disabled user", "Bearer " + disabledToken, h.GetAPIBooks, list, http.StatusUnauthorized},
+		{"read list", "Bearer " + read, h.GetAPIBooks, list, http.StatusOK},
+		{"read create", "Bearer " + read, h.PostAPIBooks, create, http.StatusForbidden},
+		{"read review", "Bearer " + read, h.PutAPIBookReview, review, http.StatusForbidden},
+		{"read delete", "Bearer " + read, h.DeleteAPIBook, func() *http.Request {
+			return jsonRequest(http.MethodDelete, "/api/v1/books/"+id, "")
+		}, http.StatusForbidden},
+		{"write create", "Bearer " + write, h.PostAPIBooks, create, http.StatusCreated},
+		{"write review", "Bearer " + write, h.PutAPIBookReview, review, http.StatusOK},
+	} {
+		if got := serveAPI(t, h, tt.fn, tt.authorization, tt.req(), "id", id); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// the refused requests changed nothing
+	if n, err := db.NewSelect().Model((*model.Book)(nil)).Where("title = ?", "Emma").Count(ctx); err != nil || n != 1 {
+		t.Errorf("%d books created, %v, want only that of the write token", n, err)
+	}
+	if !inLibrary(t, db, alice, book.ID) {
+		t.Error("the read token removed the book from the library")
+	}
+}
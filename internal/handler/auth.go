@@ -3,9 +3,16 @@ package handler
 // NOTE: https://cheatsheetseries.owasp.org/cheatsheets/Authentication_Cheat_Sheet.html
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/login"
 	"xiazki/internal/model"
 	"xiazki/web/template/auth"
 
@@ -13,83 +20,184 @@ import (
 	"github.com/labstack/echo/v4"
 )
 
+// loginTimeout limits the requests to login providers and the directory.
+const loginTimeout = 10 * time.Second
+
+// newAuthenticator returns the backends the login form is checked against,
+// the directory first so that its users get their roles updated.
+func newAuthenticator(db *database.DB, cfg *config.Config) login.Chain {
+	var chain login.Chain
+	if cfg.Auth.LDAP.Enabled() {
+		chain = append(chain, login.NewLDAP(db, cfg.Auth.LDAP, loginTimeout))
+	}
+	if cfg.Auth.PasswordLogin {
+		chain = append(chain, login.NewPassword(db))
+	}
+	return chain
+}
+
 type AuthForm struct {
 	Username string `form:"username"`
 	Password string `form:"password"`
+	Invite   string `form:"invite"`
 }
 
-func (h *Handler) GetRegister(c echo.Context) error {
-	return Render(c, auth.Show(auth.Data{Op: auth.Register}))
-}
-
-func (h *Handler) GetLogin(c echo.Context) error {
-	return Render(c, auth.Show(auth.Data{Op: auth.Login}))
+func (h *Handler) GetSetup(c echo.Context) error {
+	if done, err := h.db.SetupDone(c.Request().Context()); err != nil {
+		c.Logger().Error("Failed to check setup: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check setup")
+	} else if done {
+		return c.Redirect(http.StatusSeeOther, withBasePath(c, "/login"))
+	}
+	return Render(c, auth.Show(h.authData(auth.Setup)))
 }
 
-// TODO: password strength meter
+// PostSetup creates the admin account of a new instance.
+func (h *Handler) PostSetup(c echo.Context) error {
+	if !h.cfg.Auth.PasswordLogin {
+		return errPasswordLoginDisabled
+	}
 
-func (h *Handler) PostRegister(c echo.Context) error {
 	var form AuthForm
 	if err := c.Bind(&form); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
 	}
 
 	data := auth.Data{
-		Op:     auth.Register,
+		Op:     auth.Setup,
 		Values: map[string]string{"username": form.Username, "password": form.Password},
 		Errors: map[string]string{},
 	}
 
-	if form.Username == "" {
-		data.Errors["username"] = "Username is required"
-	} else if len(form.Username) < 3 {
-		data.Errors["username"] = "Username must be at least 3 characters"
-	} else if len(form.Username) > 30 {
-		data.Errors["username"] = "Username must be at most 30 characters"
+	if h.validateAuthForm(c, form, data.Errors); len(data.Errors) > 0 {
+		return Render(c, auth.Form(data))
 	}
 
-	// Check username uniqueness only if basic validation passes
-	if len(data.Errors) == 0 {
-		var existingUser model.User
-		err := h.db.NewSelect().Model(&existingUser).Where("username = ?", form.Username).Scan(c.Request().Context())
-		if err == nil {
-			data.Errors["username"] = "Username already taken"
+	user := model.User{ID: uuid.New(), Username: form.Username}
+	if err := user.SetPassword(form.Password); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+
+	if err := h.db.InsertFirstAdmin(c.Request().Context(), &user); errors.Is(err, database.ErrSetupDone) {
+		return echo.NewHTTPError(http.StatusForbidden, "Setup has already been completed")
+	} else if err != nil {
+		c.Logger().Error("Failed to create admin: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
+	}
+
+	if err := h.createSession(c, user.ID, false); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+	return HxRedirect(c, "/books")
+}
+
+// errPasswordLoginDisabled is returned by the password forms while they
+// cannot be used.
+var errPasswordLoginDisabled = echo.NewHTTPError(http.StatusForbidden, "Password login is disabled")
+
+func (h *Handler) GetRegister(c echo.Context) error {
+	return h.renderAuthPage(c, auth.Register, nil)
+}
+
+func (h *Handler) GetLogin(c echo.Context) error {
+	return h.renderAuthPage(c, auth.Login, nil)
+}
+
+// renderAuthPage renders the login or register page with errors, or the setup
+// while there are no users.
+func (h *Handler) renderAuthPage(c echo.Context, op auth.Operation, errors map[string]string) error {
+	ctx := c.Request().Context()
+	if done, err := h.db.SetupDone(ctx); err != nil {
+		c.Logger().Error("Failed to check setup: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check setup")
+	} else if !done && len(errors) == 0 {
+		return c.Redirect(http.StatusSeeOther, withBasePath(c, "/setup"))
+	} else if !done {
+		op = auth.Setup
+	}
+
+	mode, err := h.db.RegistrationMode(ctx)
+	if err != nil {
+		c.Logger().Error("Failed to check registration: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check registration")
+	}
+
+	data := h.authData(op)
+	data.Registration = mode
+	for field, msg := range errors {
+		data.Errors[field] = msg
+	}
+	if invite := c.QueryParam("invite"); op == auth.Register && mode == model.RegistrationInvite && invite != "" {
+		if valid, err := h.db.InviteValid(ctx, invite); err != nil {
+			c.Logger().Error("Failed to check invite: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check invite")
+		} else if valid {
+			data.Invite = invite
+		} else {
+			data.Errors["invite"] = "This invite is invalid or has expired"
 		}
 	}
+	return Render(c, auth.Show(data))
+}
 
-	if form.Password == "" {
-		data.Errors["password"] = "Password is required"
-	} else if len(form.Password) < 8 {
-		data.Errors["password"] = "Password must be at least 8 characters"
-	} else if len(form.Password) > 128 {
-		data.Errors["password"] = "Password must be at most 128 characters"
+// TODO: password strength meter
+
+// PostRegister creates a user account. The first account is the admin, which
+// only the setup creates.
+func (h *Handler) PostRegister(c echo.Context) error {
+	if !h.cfg.Auth.PasswordLogin {
+		return errPasswordLoginDisabled
 	}
 
-	if len(data.Errors) > 0 {
-		return Render(c, auth.Form(data))
+	var form AuthForm
+	if err := c.Bind(&form); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
 	}
 
-	user := model.User{
-		ID:       uuid.New(),
-		Username: form.Username,
+	ctx := c.Request().Context()
+	if done, err := h.db.SetupDone(ctx); err != nil {
+		c.Logger().Error("Failed to check setup: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check setup")
+	} else if !done {
+		return echo.NewHTTPError(http.StatusForbidden, "Setup has not been completed")
 	}
 
-	userCount, err := h.db.NewSelect().Model((*model.User)(nil)).Count(c.Request().Context())
+	mode, err := h.db.RegistrationMode(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check users")
+		c.Logger().Error("Failed to check registration: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check registration")
+	}
+
+	data := h.authData(auth.Register)
+	data.Values = map[string]string{"username": form.Username, "password": form.Password}
+	data.Registration = mode
+	data.Invite = form.Invite
+	if !data.CanRegister() {
+		return echo.NewHTTPError(http.StatusForbidden, "Registration is closed")
+	}
+	if mode != model.RegistrationInvite {
+		form.Invite = ""
 	}
 
-	if userCount == 0 {
-		user.Role = model.RoleAdmin
-	} else {
-		user.Role = model.RoleUser
+	if h.validateAuthForm(c, form, data.Errors); len(data.Errors) > 0 {
+		return Render(c, auth.Form(data))
+	}
+
+	user := model.User{
+		ID:       uuid.New(),
+		Username: form.Username,
+		Role:     model.RoleUser,
 	}
 
 	if err := user.SetPassword(form.Password); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
 
-	if _, err := h.db.NewInsert().Model(&user).Exec(c.Request().Context()); err != nil {
+	if err := h.db.InsertUser(ctx, &user, form.Invite); err != nil {
+		if errors.Is(err, database.ErrInvalidInvite) {
+			data.Errors["invite"] = "This invite is invalid or has expired"
+			return Render(c, auth.Form(data))
+		}
 		if strings.Contains(err.Error(), "duplicate") || strings.Contains(err.Error(), "unique") {
 			data.Errors["username"] = "Username already taken"
 			return Render(c, auth.Form(data))
@@ -97,13 +205,46 @@ func (h *Handler) PostRegister(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create user")
 	}
 
-	if err := h.createSession(c, user.ID); err != nil {
+	if err := h.createSession(c, user.ID, false); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
 	}
 	return HxRedirect(c, "/books")
 }
 
+// validateAuthForm adds the problems with the username and password of a new
+// account to errors.
+func (h *Handler) validateAuthForm(c echo.Context, form AuthForm, errors map[string]string) {
+	if form.Username == "" {
+		errors["username"] = "Username is required"
+	} else if len(form.Username) < 3 {
+		errors["username"] = "Username must be at least 3 characters"
+	} else if len(form.Username) > 30 {
+		errors["username"] = "Username must be at most 30 characters"
+	}
+
+	// Check username uniqueness only if basic validation passes
+	if len(errors) == 0 {
+		var existingUser model.User
+		err := h.db.NewSelect().Model(&existingUser).Where("username = ?", form.Username).Scan(c.Request().Context())
+		if err == nil {
+			errors["username"] = "Username already taken"
+		}
+	}
+
+	if form.Password == "" {
+		errors["password"] = "Password is required"
+	} else if len(form.Password) < 8 {
+		errors["password"] = "Password must be at least 8 characters"
+	} else if len(form.Password) > 128 {
+		errors["password"] = "Password must be at most 128 characters"
+	}
+}
+
 func (h *Handler) PostLogin(c echo.Context) error {
+	if len(h.authenticator) == 0 {
+		return errPasswordLoginDisabled
+	}
+
 	var form AuthForm
 	if err := c.Bind(&form); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
@@ -124,24 +265,51 @@ func (h *Handler) PostLogin(c echo.Context) error {
 		return Render(c, auth.Form(data))
 	}
 
-	var user model.User
-	err := h.db.NewSelect().Model(&user).Where("username = ?", form.Username).Scan(c.Request().Context())
+	ctx := c.Request().Context()
+	attempt, wait, err := h.throttle.Begin(ctx, form.Username, c.RealIP())
 	if err != nil {
-		// Use generic error to avoid revealing whether user exists
-		data.Errors["password"] = "Invalid username or password"
+		c.Logger().Error("Failed to check login attempts: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check login attempts")
+	} else if wait > 0 {
+		wait = wait.Truncate(time.Second) + time.Second
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		data.Errors["password"] = fmt.Sprintf("Too many failed attempts, try again in %s", wait)
 		return Render(c, auth.Form(data))
 	}
 
-	if !user.CheckPassword(form.Password) {
+	user, err := h.authenticator.Authenticate(ctx, form.Username, form.Password)
+	// the attempt stays a failure for invalid credentials. The login of users
+	// with a second factor only succeeds once they passed it, so that the
+	// password does not reset the failures of their codes.
+	var recordErr error
+	if err == nil && !user.HasTOTP() {
+		recordErr = attempt.Succeed(ctx)
+	} else if !errors.Is(err, login.ErrInvalidCredentials) {
+		recordErr = attempt.Cancel(ctx)
+	}
+	if recordErr != nil {
+		c.Logger().Error("Failed to record login attempt: ", recordErr)
+	}
+	if errors.Is(err, login.ErrInvalidCredentials) {
 		// Use generic error to avoid revealing whether user exists
 		data.Errors["password"] = "Invalid username or password"
 		return Render(c, auth.Form(data))
+	} else if err != nil {
+		c.Logger().Error("Failed to authenticate: ", err)
+		data.Errors["password"] = "Signing in failed, please try again later"
+		return Render(c, auth.Form(data))
 	}
 
-	if err := h.createSession(c, user.ID); err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	if user.Disabled {
+		data.Errors["password"] = "This account has been disabled"
+		return Render(c, auth.Form(data))
 	}
-	return HxRedirect(c, "/books")
+
+	next, err := h.signIn(c, user)
+	if err != nil {
+		return err
+	}
+	return HxRedirect(c, next)
 }
 
 func (h *Handler) PostLogout(c echo.Context) error {
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestPostRegister(t *testing.T) {
+	h, db := newTestHandler(t)
+	ctx := context.Background()
+	form := url.Values{"username": {"alice"}, "password": {"alice-password"}}
+
+	userExists := func(username string) bool {
+		t.Helper()
+		exists, err := db.NewSelect().Model((*model.User)(nil)).Where("username = ?", username).Exists(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	// the first user must go through the setup to become the admin
+	rec := serve(t, h.PostRegister, nil, formRequest(http.MethodPost, "/register", form))
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("before setup: status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if userExists("alice") {
+		t.Fatal("user registered before setup")
+	}
+
+	dbtest.User(t, db, "admin", model.RoleAdmin)
+
+	if err := db.SetSetting(ctx, model.SettingRegistration, string(model.RegistrationInvite)); err != nil {
+		t.Fatal(err)
+	}
+	invite := url.Values{"username": {"alice"}, "password": {"alice-password"}, "invite": {"unknown"}}
+	rec = serve(t, h.PostRegister, nil, formRequest(http.MethodPost, "/register", invite))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "This invite is invalid or has expired") {
+		t.Errorf("invalid invite: status = %d, body does not show the invite error:\n%s", rec.Code, rec.Body)
+	}
+	if userExists("alice") {
+		t.Fatal("user registered with an invalid invite")
+	}
+
+	if err := db.SetSetting(ctx, model.SettingRegistration, string(model.RegistrationOpen)); err != nil {
+		t.Fatal(err)
+	}
+	rec = serve(t, h.PostRegister, nil, formRequest(http.MethodPost, "/register", form))
+	if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") == "" {
+		t.Errorf("after setup: status = %d, HX-Redirect = %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	if !userExists("alice") {
+		t.Error("user not registered after setup")
+	}
+}
+
+func TestPostLoginConcurrent(t *testing.T) {
+	h, db := newTestHandler(t)
+	dbtest.User(t, db, "alice", model.RoleAdmin)
+	form := url.Values{"username": {"alice"}, "password": {"wrong-password"}}
+
+	const attempts = 20
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		checked int
+	)
+	for range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := serve(t, h.PostLogin, nil, formRequest(http.MethodPost, "/login", form))
+			body := rec.Body.String()
+			switch {
+			case strings.Contains(body, "Invalid username or password"):
+				mu.Lock()
+				checked++
+				mu.Unlock()
+			case !strings.Contains(body, "Too many failed attempts"):
+				t.Errorf("status = %d, body neither rejects the password nor throttles:\n%s", rec.Code, body)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// only the attempts before the backoff may check the password
+	if checked > 3 {
+		t.Errorf("%d of %d concurrent logins checked the password, want at most 3", checked, attempts)
+	}
+}
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -18,6 +20,11 @@ func (h *Handler) GetBook(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
 	}
 
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
 	var b model.Book
 	err = h.db.NewSelect().
 		Model(&b).
@@ -27,7 +34,7 @@ func (h *Handler) GetBook(c echo.Context) error {
 		Relation("Translators").
 		Relation("Narrators").
 		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
-			return q.OrderExpr("CASE WHEN type = ? THEN 3 WHEN type = ? THEN 2 WHEN type = ? THEN 1 ELSE 0 END ASC", model.EventFinished, model.EventDropped, model.EventReading).OrderExpr("date ASC")
+			return q.Where("user_id = ?", user.ID).OrderExpr("CASE WHEN type = ? THEN 3 WHEN type = ? THEN 2 WHEN type = ? THEN 1 ELSE 0 END ASC", model.EventFinished, model.EventDropped, model.EventReading).OrderExpr("date ASC")
 		}).
 		Scan(c.Request().Context())
 	if err != nil {
@@ -35,26 +42,12 @@ func (h *Handler) GetBook(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book details")
 	}
 
-	return Render(c, book.Show(book.Data{Book: b}))
-}
-
-func (h *Handler) DeleteBook(c echo.Context) error {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
-	}
-
-	_, err = h.db.NewDelete().
-		Model((*model.Book)(nil)).
-		Where("id = ?", id).
-		Exec(c.Request().Context())
+	library, err := h.libraryData(c, user, b.ID)
 	if err != nil {
-		c.Logger().Error("Failed to delete book: ", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete book")
+		return err
 	}
 
-	return HxRedirect(c, "/books")
+	return Render(c, book.Show(book.Data{Book: b, Editable: b.EditableBy(user), Deletable: user.IsAdmin(), Library: library}))
 }
 
 func (h *Handler) PostBookRate(c echo.Context) error {
@@ -89,7 +82,7 @@ func (h *Handler) PostBookRate(c echo.Context) error {
 		UserID: user.ID,
 		BookID: id,
 		Rating: rating,
-	}, false); err != nil {
+	}, true, false); err != nil {
 		c.Logger().Error("Failed to submit rating: ", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit rating")
 	}
@@ -109,3 +102,35 @@ func (h *Handler) PostBookRate(c echo.Context) error {
 	}
 	return Render(c, book.Stats(id, stats))
 }
+
+// editableBookID returns the id of the book from the id path parameter, after
+// checking that the current user may edit it.
+func (h *Handler) editableBookID(c echo.Context) (int64, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return 0, err
+	}
+
+	var b model.Book
+	err = h.db.NewSelect().
+		Model(&b).
+		Column("id", "added_by_id").
+		Where("id = ?", id).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch book: ", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book")
+	}
+
+	if !b.EditableBy(user) {
+		return 0, echo.NewHTTPError(http.StatusForbidden, "Only the user who added the book or an admin can change it")
+	}
+	return id, nil
+}
@@ -0,0 +1,237 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+// bookUsers are the users of the book edit tests: alice adds the books, bob
+// is another user and carol the admin.
+type bookUsers struct {
+	alice, bob, carol *model.User
+}
+
+func newBookUsers(t *testing.T, db *database.DB) bookUsers {
+	return bookUsers{
+		alice: dbtest.User(t, db, "alice", model.RoleUser),
+		bob:   dbtest.User(t, db, "bob", model.RoleUser),
+		carol: dbtest.User(t, db, "carol", model.RoleAdmin),
+	}
+}
+
+func insertBook(t *testing.T, db *database.DB, addedBy *model.User, title string) *model.Book {
+	t.Helper()
+	b := &model.Book{Title: title, Authors: []*model.Author{{Name: "Frank Herbert"}}}
+	if addedBy != nil {
+		b.AddedByID = addedBy.ID
+	}
+	if err := db.InsertBook(context.Background(), b); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestEditableBookID(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	added := insertBook(t, db, u.alice, "Dune")
+	// books from before the adder was recorded are left to the admins
+	legacy := insertBook(t, db, nil, "Dune Messiah")
+
+	check := func(c echo.Context) error {
+		if _, err := h.editableBookID(c); err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+
+	for _, tt := range []struct {
+		name string
+		user *model.User
+		id   string
+		want int
+	}{
+		{"adder", u.alice, strconv.FormatInt(added.ID, 10), http.StatusNoContent},
+		{"admin", u.carol, strconv.FormatInt(added.ID, 10), http.StatusNoContent},
+		{"other user", u.bob, strconv.FormatInt(added.ID, 10), http.StatusForbidden},
+		{"legacy by user", u.alice, strconv.FormatInt(legacy.ID, 10), http.StatusForbidden},
+		{"legacy by admin", u.carol, strconv.FormatInt(legacy.ID, 10), http.StatusNoContent},
+		{"missing", u.carol, "999", http.StatusNotFound},
+		{"invalid", u.carol, "dune", http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, check, tt.user, formRequest(http.MethodDelete, "/book/"+tt.id, nil), "id", tt.id)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetBookEdit(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+	id := strconv.FormatInt(b.ID, 10)
+
+	for _, tt := range []struct {
+		user *model.User
+		want int
+	}{
+		{u.alice, http.StatusOK},
+		{u.bob, http.StatusForbidden},
+		{u.carol, http.StatusOK},
+	} {
+		req := formRequest(http.MethodGet, "/book/"+id+"/edit", nil)
+		if rec := serve(t, h.GetBookEdit, tt.user, req, "id", id); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.user.Username, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestPutBookEdit(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+	id := strconv.FormatInt(b.ID, 10)
+
+	title := func() string {
+		t.Helper()
+		var got model.Book
+		if err := db.NewSelect().Model(&got).Column("title").Where("id = ?", b.ID).Scan(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		return got.Title
+	}
+
+	for _, tt := range []struct {
+		user  *model.User
+		title string
+		want  int
+		saved string
+	}{
+		{u.bob, "Dune by bob", http.StatusForbidden, "Dune"},
+		{u.alice, "Dune by alice", http.StatusOK, "Dune by alice"},
+		{u.carol, "Dune by carol", http.StatusOK, "Dune by carol"},
+	} {
+		form := url.Values{"title": {tt.title}, "authors": {"Frank Herbert"}}
+		rec := serve(t, h.PutBookEdit, tt.user, formRequest(http.MethodPut, "/book/"+id+"/edit", form), "id", id)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.user.Username, rec.Code, tt.want)
+		}
+		if got := title(); got != tt.saved {
+			t.Errorf("%s: title = %q, want %q", tt.user.Username, got, tt.saved)
+		}
+	}
+}
+
+// inLibrary reports whether the book is in the library of user.
+func inLibrary(t *testing.T, db *database.DB, user *model.User, bookID int64) bool {
+	t.Helper()
+	exists, err := db.NewSelect().Model((*model.UserBook)(nil)).Where("user_id = ? AND book_id = ?", user.ID, bookID).Exists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestDeleteAdminBook(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+	id := strconv.FormatInt(b.ID, 10)
+	if err := db.AddLibraryBook(context.Background(), u.bob.ID, b.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	exists := func() bool {
+		t.Helper()
+		exists, err := db.NewSelect().Model((*model.Book)(nil)).Where("id = ?", b.ID).Exists(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return exists
+	}
+
+	// the route is behind RequireAdmin, even the adder may not delete the
+	// book from the catalog
+	for _, tt := range []struct {
+		name string
+		user *model.User
+		want int
+		kept bool
+	}{
+		{"adder", u.alice, http.StatusForbidden, true},
+		{"other user", u.bob, http.StatusForbidden, true},
+		{"admin", u.carol, http.StatusOK, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h.RequireAdmin(h.DeleteAdminBook), tt.user, formRequest(http.MethodDelete, "/admin/books/"+id, nil), "id", id)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if exists() != tt.kept {
+				t.Errorf("book kept = %v, want %v", !tt.kept, tt.kept)
+			}
+		})
+	}
+	if inLibrary(t, db, u.bob, b.ID) {
+		t.Error("deleted book left in a library")
+	}
+}
+
+func TestDeleteAPIBook(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+	id := strconv.FormatInt(b.ID, 10)
+	users := []*model.User{u.alice, u.bob, u.carol}
+	for _, user := range users {
+		if err := db.AddLibraryBook(context.Background(), user.ID, b.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// whoever deletes the book only removes it from their own library
+	removed := map[*model.User]bool{}
+	for _, tt := range []struct {
+		name string
+		user *model.User
+	}{
+		{"other user", u.bob},
+		{"admin", u.carol},
+		{"adder", u.alice},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := serve(t, h.DeleteAPIBook, tt.user, jsonRequest(http.MethodDelete, "/api/v1/books/"+id, ""), "id", id)
+			if rec.Code != http.StatusNoContent {
+				t.Errorf("status = %d, want %d", rec.Code, http.StatusNoContent)
+			}
+			removed[tt.user] = true
+			for _, user := range users {
+				if got := inLibrary(t, db, user, b.ID); got == removed[user] {
+					t.Errorf("in the library of %s = %v, want %v", user.Username, got, !removed[user])
+				}
+			}
+		})
+	}
+
+	exists, err := db.NewSelect().Model((*model.Book)(nil)).Where("id = ?", b.ID).Exists(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if !exists {
+		t.Error("book deleted from the catalog")
+	}
+	if rec := serve(t, h.DeleteAPIBook, u.bob, jsonRequest(http.MethodDelete, "/api/v1/books/999", ""), "id", "999"); rec.Code != http.StatusNotFound {
+		t.Errorf("missing book: status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -9,25 +9,41 @@ import (
 	"xiazki/web/template/books"
 
 	"github.com/labstack/echo/v4"
-	"github.com/uptrace/bun"
 )
 
+const booksPageSize = 30
+
 func (h *Handler) GetBooks(c echo.Context) error {
-	var b []*model.Book
-	err := h.db.NewSelect().
-		Model(&b).
-		Relation("Authors").
-		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
-			return q.OrderExpr("CASE WHEN type = ? THEN 3 WHEN type = ? THEN 2 WHEN type = ? THEN 1 ELSE 0 END ASC", model.EventFinished, model.EventDropped, model.EventReading).OrderExpr("date ASC")
-		}).
-		OrderExpr("created_at DESC").
-		Scan(c.Request().Context())
+	var fv books.FilterValues
+	if err := c.Bind(&fv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid filters")
+	}
+
+	if errors := fv.Validate(); len(errors) > 0 {
+		return Render(c, books.Show(books.Data{Filters: fv, Errors: errors}))
+	}
+
+	after, err := strconv.ParseInt(c.QueryParam("after"), 10, 64)
+	if c.QueryParam("after") != "" && (err != nil || after < 1) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid cursor")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	b, next, err := h.db.ListBooks(c.Request().Context(), user.ID, fv.ToBookFilter(), model.BookSort(fv.Sort), after, booksPageSize)
 	if err != nil {
 		c.Logger().Error("Failed to fetch books: ", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch books")
 	}
 
-	return Render(c, books.Show(books.Data{Books: b}))
+	data := books.Data{Books: b, Filters: fv, Next: next}
+	if after != 0 && isHtmxFragment(c) {
+		return Render(c, books.Page(data))
+	}
+	return Render(c, books.Show(data))
 }
 
 func (h *Handler) GetAuthor(c echo.Context) error {
@@ -1,6 +1,8 @@
 package handler
 
 import (
+	"database/sql"
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -27,8 +29,8 @@ func (h *Handler) PostBookAddEvent(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
 	}
 
-	if err := efv.Validate(); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	if errors := efv.Validate(); len(errors) > 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid event type or date")
 	}
 
 	u, err := h.currentUser(c)
@@ -61,15 +63,14 @@ func (h *Handler) PostBookAddEvent(c echo.Context) error {
 }
 
 func (h *Handler) DeleteEvent(c echo.Context) error {
-	idStr := c.Param("id")
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	event, err := h.userEvent(c)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid event ID")
+		return err
 	}
 
 	_, err = h.db.NewDelete().
 		Model((*model.Event)(nil)).
-		Where("id = ?", id).
+		Where("id = ? AND user_id = ?", event.ID, event.UserID).
 		Exec(c.Request().Context())
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete event: "+err.Error())
@@ -77,3 +78,33 @@ func (h *Handler) DeleteEvent(c echo.Context) error {
 
 	return HxRedirect(c, c.Request().Referer())
 }
+
+// userEvent fetches the event from the :id route parameter and makes sure it
+// belongs to the current user.
+func (h *Handler) userEvent(c echo.Context) (*model.Event, error) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid event ID")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	var event model.Event
+	err = h.db.NewSelect().
+		Model(&event).
+		Column("id", "user_id").
+		Where("id = ?", id).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Event not found")
+	} else if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch event: "+err.Error())
+	}
+	if event.UserID != user.ID {
+		return nil, echo.NewHTTPError(http.StatusForbidden, "Events can only be deleted by their owner")
+	}
+	return &event, nil
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestDeleteEvent(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+
+	for _, handler := range []struct {
+		name string
+		fn   echo.HandlerFunc
+		ok   int
+	}{
+		{"html", h.DeleteEvent, http.StatusOK},
+		{"api", h.DeleteAPIEvent, http.StatusNoContent},
+	} {
+		t.Run(handler.name, func(t *testing.T) {
+			event := &model.Event{Type: model.EventReading, Date: time.Now()}
+			if err := db.InsertEvent(context.Background(), b, u.bob, event); err != nil {
+				t.Fatal(err)
+			}
+			id := strconv.FormatInt(event.ID, 10)
+
+			exists := func() bool {
+				t.Helper()
+				exists, err := db.NewSelect().Model((*model.Event)(nil)).Where("id = ?", event.ID).Exists(context.Background())
+				if err != nil {
+					t.Fatal(err)
+				}
+				return exists
+			}
+
+			// events are private, neither the adder of the book nor an
+			// admin may delete those of other users
+			for _, tt := range []struct {
+				name string
+				user *model.User
+				want int
+				kept bool
+			}{
+				{"adder", u.alice, http.StatusForbidden, true},
+				{"admin", u.carol, http.StatusForbidden, true},
+				{"owner", u.bob, handler.ok, false},
+				{"deleted", u.bob, http.StatusNotFound, false},
+			} {
+				req := formRequest(http.MethodDelete, "/event/"+id, nil)
+				req.Header.Set("Referer", "/book/"+strconv.FormatInt(b.ID, 10))
+				if rec := serve(t, handler.fn, tt.user, req, "id", id); rec.Code != tt.want {
+					t.Errorf("%s: status = %d, want %d", tt.name, rec.Code, tt.want)
+				}
+				if exists() != tt.kept {
+					t.Fatalf("%s: event kept = %v, want %v", tt.name, !tt.kept, tt.kept)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+
+	"xiazki/internal/importer"
+	"xiazki/web/template/imports"
+
+	"github.com/labstack/echo/v4"
+)
+
+const maxImportSize = 32 << 20 // 32 MiB
+
+func (h *Handler) GetImport(c echo.Context) error {
+	return Render(c, imports.Show(imports.Data{Sources: importer.Sources()}))
+}
+
+func (h *Handler) PostImport(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	source := c.FormValue("source")
+	dryRun := c.FormValue("dry_run") == "true"
+
+	data, err := importPayload(c)
+	if err != nil {
+		return Render(c, imports.Form(imports.Data{
+			Sources: importer.Sources(),
+			Errors:  map[string]string{"file": err.Error()},
+		}))
+	}
+
+	var records []*importer.Record
+	var mapping importer.Mapping
+	if importer.IsMapped(source) {
+		table, err := importer.ReadTable(source, bytes.NewReader(data))
+		if err != nil {
+			return Render(c, imports.Form(imports.Data{
+				Sources: importer.Sources(),
+				Errors:  map[string]string{"file": err.Error()},
+			}))
+		}
+
+		if c.FormValue("mapped") != "true" {
+			return Render(c, imports.Mapping(imports.MappingData{
+				Source:  source,
+				DryRun:  dryRun,
+				Payload: base64.StdEncoding.EncodeToString(data),
+				Columns: table.Columns,
+				Mapping: importer.DefaultMapping(table.Columns),
+			}))
+		}
+
+		mapping = make(importer.Mapping)
+		for _, field := range importer.Fields {
+			if column := c.FormValue("map_" + field.Name); column != "" {
+				mapping[field.Name] = column
+			}
+		}
+		records = table.Records(mapping)
+	} else {
+		records, err = importer.Parse(source, bytes.NewReader(data))
+		if err != nil {
+			return Render(c, imports.Form(imports.Data{
+				Sources: importer.Sources(),
+				Errors:  map[string]string{"file": err.Error()},
+			}))
+		}
+	}
+
+	results := importer.Import(c.Request().Context(), h.db, user, records, dryRun)
+
+	report := imports.ReportData{
+		Source:  source,
+		DryRun:  dryRun,
+		Mapping: mapping,
+		Results: results,
+	}
+	if dryRun {
+		report.Payload = base64.StdEncoding.EncodeToString(data)
+	}
+	return Render(c, imports.Report(report))
+}
+
+// importPayload returns the uploaded file, or the file sent back from the
+// preview when the user confirms the import. Both are limited to
+// maxImportSize.
+func importPayload(c echo.Context) ([]byte, error) {
+	if payload := c.FormValue("data"); payload != "" {
+		if len(payload) > base64.StdEncoding.EncodedLen(maxImportSize) {
+			return nil, errors.New("File is too large")
+		}
+		data, err := base64.StdEncoding.DecodeString(payload)
+		if err != nil {
+			return nil, errors.New("Invalid import data")
+		}
+		return data, nil
+	}
+
+	fh, err := c.FormFile("file")
+	if err != nil {
+		return nil, errors.New("File is required")
+	}
+	if fh.Size > maxImportSize {
+		return nil, errors.New("File is too large")
+	}
+
+	f, err := fh.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	return io.ReadAll(io.LimitReader(f, maxImportSize))
+}
@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"xiazki/internal/model"
+	"xiazki/web/template/book"
+
+	"github.com/labstack/echo/v4"
+)
+
+func (h *Handler) PostBookLibrary(c echo.Context) error {
+	user, bookID, err := h.libraryParams(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.AddLibraryBook(c.Request().Context(), user.ID, bookID); err != nil {
+		c.Logger().Error("Failed to add book to library: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add book to library")
+	}
+
+	return h.renderLibrary(c, user, bookID)
+}
+
+func (h *Handler) PutBookLibrary(c echo.Context) error {
+	user, bookID, err := h.libraryParams(c)
+	if err != nil {
+		return err
+	}
+
+	var values book.LibraryFormValues
+	if err := c.Bind(&values); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	ub, err := h.db.LibraryBook(c.Request().Context(), user.ID, bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Book is not in your library")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch library book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch library book")
+	}
+
+	if errors := values.Validate(); len(errors) > 0 {
+		return Render(c, book.Library(book.LibraryData{
+			BookID:   bookID,
+			UserBook: ub,
+			Values:   values,
+			Errors:   errors,
+		}))
+	}
+
+	values.ToUserBook(ub)
+	if err := h.db.UpdateLibraryBook(c.Request().Context(), ub); err != nil {
+		c.Logger().Error("Failed to update library book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update library book")
+	}
+
+	return h.renderLibrary(c, user, bookID)
+}
+
+func (h *Handler) DeleteBookLibrary(c echo.Context) error {
+	user, bookID, err := h.libraryParams(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.RemoveLibraryBook(c.Request().Context(), user.ID, bookID); err != nil {
+		c.Logger().Error("Failed to remove book from library: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove book from library")
+	}
+
+	return h.renderLibrary(c, user, bookID)
+}
+
+// libraryParams returns the current user and the book from the id path
+// parameter, checking that the book exists.
+func (h *Handler) libraryParams(c echo.Context) (*model.User, int64, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	bookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	exists, err := h.db.NewSelect().Model((*model.Book)(nil)).Where("id = ?", bookID).Exists(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch book: ", err)
+		return nil, 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book")
+	} else if !exists {
+		return nil, 0, echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+	return user, bookID, nil
+}
+
+func (h *Handler) renderLibrary(c echo.Context, user *model.User, bookID int64) error {
+	data, err := h.libraryData(c, user, bookID)
+	if err != nil {
+		return err
+	}
+	return Render(c, book.Library(data))
+}
+
+func (h *Handler) libraryData(c echo.Context, user *model.User, bookID int64) (book.LibraryData, error) {
+	data := book.LibraryData{BookID: bookID}
+
+	ub, err := h.db.LibraryBook(c.Request().Context(), user.ID, bookID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return data, nil
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch library book: ", err)
+		return data, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch library book")
+	}
+
+	data.UserBook = ub
+	data.Values = book.UserBookToLibraryFormValues(ub)
+	return data, nil
+}
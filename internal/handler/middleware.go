@@ -2,10 +2,12 @@ package handler
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
-	"os"
+	"time"
 
 	"xiazki/internal/model"
+	"xiazki/web/template/layout"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/sessions"
@@ -14,28 +16,48 @@ import (
 )
 
 const (
-	sessionName   = "session"
-	authKey       = "authenticated"
-	userIDKey     = "user_id"
-	sessionMaxAge = 86400 * 7 // 7 days
+	sessionName = "session"
+	tokenKey    = "token"
+	userKey     = "user"
+	sessionKey  = "session"
+	basePathKey = "base_path"
+	// sessionTouchInterval is how often the last use of a session is
+	// recorded.
+	sessionTouchInterval = time.Minute
 )
 
-func (h *Handler) createSession(c echo.Context, userID uuid.UUID) error {
+// createSession signs the user in, replacing the session of the request if
+// there is one. secondFactor records whether they have passed the second
+// factor, users with one set up are only signed in halfway without it.
+func (h *Handler) createSession(c echo.Context, userID uuid.UUID, secondFactor bool) error {
 	sess, err := session.Get(sessionName, c)
 	if err != nil {
 		return err
 	}
 
+	ctx := c.Request().Context()
+	if token, ok := sess.Values[tokenKey].(string); ok {
+		if err := h.db.DeleteSessionByToken(ctx, token); err != nil {
+			return err
+		}
+	}
+
+	s, token, err := model.NewSession(userID, secondFactor, c.Request().UserAgent(), c.RealIP(), h.cfg.Session.MaxAge)
+	if err != nil {
+		return err
+	}
+	if err := h.db.InsertSession(ctx, s); err != nil {
+		return err
+	}
+
 	sess.Options = &sessions.Options{
-		Path:     "/",
-		MaxAge:   sessionMaxAge,
+		Path:     h.cfg.CookiePath(),
+		MaxAge:   int(h.cfg.Session.MaxAge.Seconds()),
 		HttpOnly: true,
-		Secure:   os.Getenv("APP_ENV") == "prod",
+		Secure:   h.cfg.Secure(),
 		SameSite: http.SameSiteLaxMode,
 	}
-
-	sess.Values[authKey] = true
-	sess.Values[userIDKey] = userID.String()
+	sess.Values = map[any]any{tokenKey: token}
 
 	return sess.Save(c.Request(), c.Response())
 }
@@ -46,32 +68,96 @@ func (h *Handler) clearSession(c echo.Context) error {
 		return err
 	}
 
+	if token, ok := sess.Values[tokenKey].(string); ok {
+		if err := h.db.DeleteSessionByToken(c.Request().Context(), token); err != nil {
+			return err
+		}
+	}
+
 	sess.Values = make(map[any]any)
 	sess.Options.MaxAge = -1
 	return sess.Save(c.Request(), c.Response())
 }
 
-func (h *Handler) currentUser(c echo.Context) (*model.User, error) {
-	userID, err := checkSession(c)
+// currentSession returns the session of the request, which is loaded once per
+// request.
+func (h *Handler) currentSession(c echo.Context) (*model.Session, error) {
+	if s, ok := c.Get(sessionKey).(*model.Session); ok {
+		return s, nil
+	}
+
+	sess, err := session.Get(sessionName, c)
 	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid session")
+	}
+	token, ok := sess.Values[tokenKey].(string)
+	if !ok {
 		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Not authenticated")
 	}
 
+	ctx := c.Request().Context()
+	s, err := h.db.SessionByToken(ctx, token)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Session expired")
+	} else if err != nil {
+		return nil, err
+	}
+	if err := h.db.TouchSession(ctx, s, c.RealIP(), sessionTouchInterval); err != nil {
+		c.Logger().Error("Failed to update session: ", err)
+	}
+
+	c.Set(sessionKey, s)
+	return s, nil
+}
+
+// currentUser returns the signed in user. The user is loaded once per request,
+// disabled users and users who have not passed their second factor count as
+// signed out.
+func (h *Handler) currentUser(c echo.Context) (*model.User, error) {
+	if user, ok := c.Get(userKey).(*model.User); ok {
+		return user, nil
+	}
+
+	user, s, err := h.sessionUser(c)
+	if err != nil {
+		return nil, err
+	}
+	if user.HasTOTP() && !s.SecondFactor {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Second factor required")
+	}
+
+	c.Set(userKey, user)
+	return user, nil
+}
+
+// sessionUser returns the user of the session, whether or not they have
+// passed the second factor.
+func (h *Handler) sessionUser(c echo.Context) (*model.User, *model.Session, error) {
+	s, err := h.currentSession(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	var user model.User
-	if err = h.db.NewSelect().Model(&user).Where("id = ?", userID).Scan(c.Request().Context()); err != nil {
+	if err = h.db.NewSelect().Model(&user).Where("id = ?", s.UserID).Scan(c.Request().Context()); err != nil {
 		if err == sql.ErrNoRows {
-			return nil, echo.NewHTTPError(http.StatusUnauthorized, "User not found")
+			return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "User not found")
 		}
-		return nil, err
+		return nil, nil, err
 	}
-
-	return &user, nil
+	if user.Disabled {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "Account disabled")
+	}
+	return &user, s, nil
 }
 
 func (h *Handler) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		if _, err := checkSession(c); err != nil {
-			return c.Redirect(http.StatusSeeOther, "/login")
+		if _, err := h.currentUser(c); isUnauthorized(err) {
+			return c.Redirect(http.StatusSeeOther, withBasePath(c, "/login"))
+		} else if err != nil {
+			c.Logger().Error("Failed to fetch user: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
 		}
 		return next(c)
 	}
@@ -79,31 +165,65 @@ func (h *Handler) RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
 
 func (h *Handler) RequireAuthHTMX(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		if _, err := checkSession(c); err != nil || c.Request().Header.Get("HX-Request") != "true" {
+		if c.Request().Header.Get("HX-Request") != "true" {
 			return c.NoContent(http.StatusUnauthorized)
 		}
+		if _, err := h.currentUser(c); isUnauthorized(err) {
+			return c.NoContent(http.StatusUnauthorized)
+		} else if err != nil {
+			c.Logger().Error("Failed to fetch user: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+		}
 		return next(c)
 	}
 }
 
-func checkSession(c echo.Context) (uuid.UUID, error) {
-	sess, err := session.Get(sessionName, c)
-	if err != nil {
-		return uuid.Nil, err
+// RequireAdmin must come after RequireAuth or RequireAuthHTMX.
+func (h *Handler) RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, err := h.currentUser(c)
+		if err != nil {
+			return err
+		}
+		if !user.IsAdmin() {
+			return echo.NewHTTPError(http.StatusForbidden, "Forbidden")
+		}
+		return next(c)
 	}
+}
 
-	if auth, ok := sess.Values[authKey].(bool); !ok || !auth {
-		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Not authenticated")
-	}
+func isUnauthorized(err error) bool {
+	var he *echo.HTTPError
+	return errors.As(err, &he) && he.Code == http.StatusUnauthorized
+}
 
-	userIDStr, ok := sess.Values[userIDKey].(string)
-	if !ok {
-		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid session")
+// BasePath makes the path prefix the application is served under available to
+// handlers and templates.
+func BasePath(path string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set(basePathKey, path)
+			return next(c)
+		}
 	}
+}
+
+func basePath(c echo.Context) string {
+	path, _ := c.Get(basePathKey).(string)
+	return path
+}
+
+// withBasePath prefixes paths starting with a single slash with the base path.
+func withBasePath(c echo.Context, path string) string {
+	return layout.JoinBasePath(basePath(c), path)
+}
 
-	if userID, err := uuid.Parse(userIDStr); err != nil {
-		return uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Invalid user ID")
-	} else {
-		return userID, nil
+// publicURL is the configured public URL, or the URL of the request host and
+// the base path if there is none. Links leaving the request, like those of
+// invites, use it so a spoofed Host header cannot point them elsewhere.
+func (h *Handler) publicURL(c echo.Context) string {
+	if h.cfg.PublicURL != "" {
+		return h.cfg.PublicURL
 	}
+	return c.Scheme() + "://" + c.Request().Host + basePath(c)
 }
@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/internal/services/oidc"
+	"xiazki/web/template/auth"
+	"xiazki/web/template/profile"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// oidcSessionName is the session keeping a login flow between the
+	// redirect to the provider and the callback.
+	oidcSessionName = "oidc"
+	oidcFlowMaxAge  = 10 * time.Minute
+)
+
+// loginProvider is a configured OpenID Connect provider.
+type loginProvider struct {
+	config.OIDCProvider
+	*oidc.Provider
+}
+
+func newLoginProviders(cfgs []config.OIDCProvider) map[string]*loginProvider {
+	providers := make(map[string]*loginProvider, len(cfgs))
+	for _, cfg := range cfgs {
+		providers[cfg.Name] = &loginProvider{
+			OIDCProvider: cfg,
+			Provider: oidc.NewProvider(oidc.Config{
+				Issuer:        cfg.Issuer,
+				ClientID:      cfg.ClientID,
+				ClientSecret:  cfg.ClientSecret,
+				Scopes:        cfg.Scopes,
+				UsernameClaim: cfg.UsernameClaim,
+			}, loginTimeout),
+		}
+	}
+	return providers
+}
+
+// loginError is a reason a login failed which is shown to the user.
+type loginError string
+
+func (e loginError) Error() string {
+	return string(e)
+}
+
+// GetOIDCLogin redirects to the login page of a provider. Signed in users
+// link the identity they sign in with to their account.
+func (h *Handler) GetOIDCLogin(c echo.Context) error {
+	p, ok := h.providers[c.Param("provider")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Login provider not found")
+	}
+
+	flow := oidc.NewFlow()
+	url, err := p.AuthCodeURL(c.Request().Context(), h.oidcRedirectURL(c, p), flow)
+	if err != nil {
+		c.Logger().Error("Failed to reach login provider: ", err)
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to reach login provider")
+	}
+
+	sess, err := session.Get(oidcSessionName, c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+	sess.Options = &sessions.Options{
+		Path:     h.cfg.CookiePath(),
+		MaxAge:   int(oidcFlowMaxAge.Seconds()),
+		HttpOnly: true,
+		Secure:   h.cfg.Secure(),
+		SameSite: http.SameSiteLaxMode,
+	}
+	sess.Values = map[any]any{
+		"provider": p.Name,
+		"state":    flow.State,
+		"nonce":    flow.Nonce,
+		"verifier": flow.Verifier,
+	}
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+
+	return c.Redirect(http.StatusSeeOther, url)
+}
+
+// GetOIDCCallback finishes a login the provider redirected back from.
+func (h *Handler) GetOIDCCallback(c echo.Context) error {
+	p, ok := h.providers[c.Param("provider")]
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "Login provider not found")
+	}
+
+	flow, ok := h.takeOIDCFlow(c, p)
+	if !ok {
+		return h.renderLoginError(c, "The login has expired, please try again")
+	}
+	if c.QueryParam("error") != "" {
+		return h.renderLoginError(c, fmt.Sprintf("Signing in with %s was cancelled or failed", p.DisplayName))
+	}
+
+	identity, err := p.Exchange(c.Request().Context(), h.oidcRedirectURL(c, p), c.QueryParam("code"), flow)
+	if err != nil {
+		c.Logger().Error("Failed to sign in with ", p.Name, ": ", err)
+		return h.renderLoginError(c, fmt.Sprintf("Signing in with %s failed", p.DisplayName))
+	}
+
+	if current, err := h.currentUser(c); err == nil {
+		return h.linkIdentity(c, p, current, identity)
+	} else if !isUnauthorized(err) {
+		c.Logger().Error("Failed to fetch user: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+	}
+
+	user, err := h.db.IdentityUser(c.Request().Context(), p.Name, identity.Subject)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = h.provisionUser(c, p, identity)
+	}
+	var le loginError
+	if errors.As(err, &le) {
+		return h.renderLoginError(c, le.Error())
+	} else if err != nil {
+		c.Logger().Error("Failed to sign in with ", p.Name, ": ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign in")
+	}
+
+	if user.Disabled {
+		return h.renderLoginError(c, "This account has been disabled")
+	}
+
+	next, err := h.signIn(c, user)
+	if err != nil {
+		return err
+	}
+	return c.Redirect(http.StatusSeeOther, withBasePath(c, next))
+}
+
+// takeOIDCFlow returns the login flow started with the provider and ends it,
+// so that a callback cannot be replayed.
+func (h *Handler) takeOIDCFlow(c echo.Context, p *loginProvider) (oidc.Flow, bool) {
+	sess, err := session.Get(oidcSessionName, c)
+	if err != nil {
+		return oidc.Flow{}, false
+	}
+
+	provider, _ := sess.Values["provider"].(string)
+	flow := oidc.Flow{}
+	flow.State, _ = sess.Values["state"].(string)
+	flow.Nonce, _ = sess.Values["nonce"].(string)
+	flow.Verifier, _ = sess.Values["verifier"].(string)
+
+	sess.Values = map[any]any{}
+	sess.Options.MaxAge = -1
+	_ = sess.Save(c.Request(), c.Response())
+
+	return flow, provider == p.Name && flow.State != "" && flow.State == c.QueryParam("state")
+}
+
+// provisionUser finds the user an identity which is not linked yet belongs
+// to, linking it by username or creating a new account if the provider
+// allows it. The first user of a new instance is created regardless.
+func (h *Handler) provisionUser(c echo.Context, p *loginProvider, identity *oidc.Identity) (*model.User, error) {
+	ctx := c.Request().Context()
+	done, err := h.db.SetupDone(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if done && p.LinkByUsername && identity.Username != "" {
+		var user model.User
+		err := h.db.NewSelect().Model(&user).Where("username = ?", identity.Username).Scan(ctx)
+		if err == nil {
+			return &user, h.db.LinkIdentity(ctx, &model.Identity{
+				UserID:   user.ID,
+				Provider: p.Name,
+				Subject:  identity.Subject,
+			})
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if done && !p.AutoProvision {
+		return nil, loginError(fmt.Sprintf("No account is linked to this %s login", p.DisplayName))
+	}
+
+	if n := len(identity.Username); n < 3 || n > 30 {
+		return nil, loginError(fmt.Sprintf("%s did not provide a username of 3 to 30 characters", p.DisplayName))
+	}
+	if taken, err := h.db.NewSelect().Model((*model.User)(nil)).Where("username = ?", identity.Username).Exists(ctx); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, loginError(fmt.Sprintf("The username %s is already taken", identity.Username))
+	}
+
+	user := model.User{ID: uuid.New(), Username: identity.Username, Role: model.RoleUser}
+	err = h.db.ProvisionUser(ctx, &user, &model.Identity{Provider: p.Name, Subject: identity.Subject})
+	return &user, err
+}
+
+// linkIdentity links the identity a signed in user signed in with to their
+// account.
+func (h *Handler) linkIdentity(c echo.Context, p *loginProvider, user *model.User, identity *oidc.Identity) error {
+	err := h.db.LinkIdentity(c.Request().Context(), &model.Identity{
+		UserID:   user.ID,
+		Provider: p.Name,
+		Subject:  identity.Subject,
+	})
+	if errors.Is(err, database.ErrIdentityLinked) {
+		return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("This %s login is linked to another user", p.DisplayName))
+	} else if err != nil {
+		c.Logger().Error("Failed to link identity: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to link identity")
+	}
+	return c.Redirect(http.StatusSeeOther, withBasePath(c, "/profile"))
+}
+
+func (h *Handler) DeleteProfileIdentity(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid identity ID")
+	}
+
+	data := profile.Data{User: user, Errors: map[string]string{}}
+	keepOne := !h.cfg.Auth.PasswordLogin || user.Password == ""
+	if err := h.db.UnlinkIdentity(c.Request().Context(), user.ID, id, keepOne); errors.Is(err, database.ErrLastIdentity) {
+		data.Errors["identities"] = "This login cannot be unlinked, it is the only way to sign in to your account"
+	} else if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Identity not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to unlink identity: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to unlink identity")
+	}
+
+	if data.Providers, err = h.profileProviders(c, user); err != nil {
+		c.Logger().Error("Failed to fetch identities: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch identities")
+	}
+	return Render(c, profile.Identities(data))
+}
+
+// profileProviders returns the configured providers with the identities of
+// user at them.
+func (h *Handler) profileProviders(c echo.Context, user *model.User) ([]profile.Provider, error) {
+	identities, err := h.db.Identities(c.Request().Context(), user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	providers := make([]profile.Provider, 0, len(h.cfg.Auth.OIDC))
+	for _, cfg := range h.cfg.Auth.OIDC {
+		provider := profile.Provider{Name: cfg.Name, DisplayName: cfg.DisplayName}
+		for _, identity := range identities {
+			if identity.Provider == cfg.Name {
+				provider.Identities = append(provider.Identities, identity)
+			}
+		}
+		providers = append(providers, provider)
+	}
+	return providers, nil
+}
+
+// authData returns the data of the auth pages common to all of them.
+func (h *Handler) authData(op auth.Operation) auth.Data {
+	data := auth.Data{
+		Op:            op,
+		PasswordLogin: h.cfg.Auth.PasswordLogin,
+		LoginForm:     len(h.authenticator) > 0,
+		Errors:        map[string]string{},
+	}
+	for _, cfg := range h.cfg.Auth.OIDC {
+		data.Providers = append(data.Providers, auth.Provider{Name: cfg.Name, DisplayName: cfg.DisplayName})
+	}
+	return data
+}
+
+func (h *Handler) renderLoginError(c echo.Context, msg string) error {
+	return h.renderAuthPage(c, auth.Login, map[string]string{"oidc": msg})
+}
+
+// oidcRedirectURL is the callback URL of the provider.
+func (h *Handler) oidcRedirectURL(c echo.Context, p *loginProvider) string {
+	return h.publicURL(c) + "/login/oidc/" + p.Name + "/callback"
+}
@@ -0,0 +1,306 @@
+package handler
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+const testClientID = "xiazki"
+
+// mockIssuer is an OpenID Connect provider serving discovery, its keys and
+// a token endpoint, which issues ID tokens with the claims registered for a
+// code.
+type mockIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  int
+	claims map[string]map[string]any
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, claims: map[string]map[string]any{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{
+			"issuer":                                m.URL,
+			"authorization_endpoint":                m.URL + "/authorize",
+			"token_endpoint":                        m.URL + "/token",
+			"jwks_uri":                              m.URL + "/keys",
+			"response_types_supported":              []string{"code"},
+			"subject_types_supported":               []string{"public"},
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /keys", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]any{"keys": []map[string]any{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		claims, ok := m.claims[r.FormValue("code")]
+		delete(m.claims, r.FormValue("code"))
+		m.mu.Unlock()
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]any{"error": "invalid_grant"})
+			return
+		}
+		writeJSON(w, map[string]any{
+			"access_token": "access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     m.idToken(t, claims),
+		})
+	})
+	m.Server = httptest.NewServer(mux)
+	t.Cleanup(m.Close)
+	return m
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// code registers the claims of the ID token issued for the returned code.
+func (m *mockIssuer) code(claims map[string]any) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.codes++
+	code := fmt.Sprintf("code-%d", m.codes)
+	m.claims[code] = claims
+	return code
+}
+
+// idToken signs the claims, with the standard ones of the issuer added.
+func (m *mockIssuer) idToken(t *testing.T, claims map[string]any) string {
+	now := time.Now()
+	full := map[string]any{"iss": m.URL, "aud": testClientID, "iat": now.Unix(), "exp": now.Add(time.Hour).Unix()}
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(full)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Error(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// newOIDCHandler returns a handler with two providers at the issuer: open
+// provisions and links accounts, closed only signs in linked identities.
+func newOIDCHandler(t *testing.T, issuer *mockIssuer) (*Handler, *database.DB) {
+	t.Helper()
+
+	cfg := config.Default()
+	for _, name := range []string{"open", "closed"} {
+		cfg.Auth.OIDC = append(cfg.Auth.OIDC, config.OIDCProvider{
+			Name:           name,
+			DisplayName:    strings.ToUpper(name[:1]) + name[1:],
+			Issuer:         issuer.URL,
+			ClientID:       testClientID,
+			ClientSecret:   "client-secret",
+			Scopes:         []string{"openid"},
+			UsernameClaim:  "preferred_username",
+			AutoProvision:  name == "open",
+			LinkByUsername: name == "open",
+		})
+	}
+	db := dbtest.New(t)
+	return NewHandler(db, cfg), db
+}
+
+// oidcLogin is a login started at a provider.
+type oidcLogin struct {
+	provider string
+	cookies  []*http.Cookie
+	state    string
+	nonce    string
+}
+
+func startOIDCLogin(t *testing.T, h *Handler, provider string) oidcLogin {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/login/oidc/"+provider, nil)
+	rec := serve(t, h.GetOIDCLogin, nil, req, "provider", provider)
+	if rec.Code != http.StatusSeeOther {
+		t.Fatalf("start login: status = %d, want %d", rec.Code, http.StatusSeeOther)
+	}
+	location, err := url.Parse(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := location.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Errorf("login URL without PKCE: %s", location)
+	}
+	return oidcLogin{
+		provider: provider,
+		cookies:  rec.Result().Cookies(),
+		state:    q.Get("state"),
+		nonce:    q.Get("nonce"),
+	}
+}
+
+// callback returns to xiazki from the provider with the code and state.
+func (l oidcLogin) callback(t *testing.T, h *Handler, code, state string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	target := "/login/oidc/" + l.provider + "/callback?" + url.Values{"code": {code}, "state": {state}}.Encode()
+	req := httptest.NewRequest(http.MethodGet, target, nil)
+	for _, c := range l.cookies {
+		req.AddCookie(c)
+	}
+	return serve(t, h.GetOIDCCallback, nil, req, "provider", l.provider)
+}
+
+// identityUser returns the user the identity is linked to, or nil.
+func identityUser(t *testing.T, db *database.DB, provider, subject string) *model.User {
+	t.Helper()
+	user, err := db.IdentityUser(context.Background(), provider, subject)
+	if err != nil {
+		return nil
+	}
+	return user
+}
+
+func assertSignedIn(t *testing.T, rec *httptest.ResponseRecorder) {
+	t.Helper()
+	if rec.Code != http.StatusSeeOther || rec.Header().Get("Location") != "/books" {
+		t.Fatalf("status = %d, location = %q, want a redirect to /books:\n%s", rec.Code, rec.Header().Get("Location"), rec.Body)
+	}
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == sessionName && c.Value != "" {
+			return
+		}
+	}
+	t.Error("no session cookie set")
+}
+
+func assertLoginError(t *testing.T, rec *httptest.ResponseRecorder, msg string) {
+	t.Helper()
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), msg) {
+		t.Errorf("status = %d, body does not contain %q:\n%s", rec.Code, msg, rec.Body)
+	}
+}
+
+func TestOIDCCallbackState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	h, db := newOIDCHandler(t, issuer)
+
+	claims := func(l oidcLogin) map[string]any {
+		return map[string]any{"sub": "s-alice", "preferred_username": "alice", "nonce": l.nonce}
+	}
+
+	l := startOIDCLogin(t, h, "open")
+	assertLoginError(t, l.callback(t, h, issuer.code(claims(l)), "forged-state"), "The login has expired")
+
+	// the login at another provider does not finish this one
+	l = startOIDCLogin(t, h, "open")
+	l.provider = "closed"
+	assertLoginError(t, l.callback(t, h, issuer.code(claims(l)), l.state), "The login has expired")
+
+	// the callback ends the login, a replay with the flow cookie kept is
+	// refused by the provider, which redeems a code once
+	l = startOIDCLogin(t, h, "open")
+	code := issuer.code(claims(l))
+	assertSignedIn(t, l.callback(t, h, code, l.state))
+	assertLoginError(t, l.callback(t, h, code, l.state), "Signing in with Open failed")
+	l.cookies = nil
+	assertLoginError(t, l.callback(t, h, code, l.state), "The login has expired")
+
+	l = startOIDCLogin(t, h, "open")
+	wrong := claims(l)
+	wrong["sub"], wrong["nonce"] = "s-mallory", "another-nonce"
+	assertLoginError(t, l.callback(t, h, issuer.code(wrong), l.state), "Signing in with Open failed")
+	if identityUser(t, db, "open", "s-mallory") != nil {
+		t.Error("identity with the wrong nonce signed in")
+	}
+}
+
+func TestOIDCProvisionUser(t *testing.T) {
+	issuer := newMockIssuer(t)
+	h, db := newOIDCHandler(t, issuer)
+
+	login := func(provider, subject, username string) *httptest.ResponseRecorder {
+		t.Helper()
+		l := startOIDCLogin(t, h, provider)
+		code := issuer.code(map[string]any{"sub": subject, "preferred_username": username, "nonce": l.nonce})
+		return l.callback(t, h, code, l.state)
+	}
+
+	// the first user becomes the admin, even at a provider without
+	// provisioning
+	assertSignedIn(t, login("closed", "s-admin", "admin"))
+	admin := identityUser(t, db, "closed", "s-admin")
+	if admin == nil || admin.Username != "admin" || admin.Role != model.RoleAdmin {
+		t.Fatalf("first user = %+v, want the admin", admin)
+	}
+
+	assertLoginError(t, login("closed", "s-bob", "bob"), "No account is linked to this Closed login")
+	if identityUser(t, db, "closed", "s-bob") != nil {
+		t.Error("closed provider provisioned a user")
+	}
+
+	assertSignedIn(t, login("open", "s-bob", "bob"))
+	if bob := identityUser(t, db, "open", "s-bob"); bob == nil || bob.Username != "bob" || bob.Role != model.RoleUser {
+		t.Errorf("provisioned user = %+v, want bob as a user", bob)
+	}
+
+	// signing in again finds the linked user
+	assertSignedIn(t, login("open", "s-bob", "bob"))
+
+	carol := dbtest.User(t, db, "carol", model.RoleUser)
+	assertSignedIn(t, login("open", "s-carol", "carol"))
+	if linked := identityUser(t, db, "open", "s-carol"); linked == nil || linked.ID != carol.ID {
+		t.Errorf("identity linked to %+v, want carol", linked)
+	}
+
+	assertLoginError(t, login("open", "s-x", "x"), "did not provide a username of 3 to 30 characters")
+
+	users, err := db.NewSelect().Model((*model.User)(nil)).Count(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if users != 3 {
+		t.Errorf("%d users, want admin, bob and carol", users)
+	}
+}
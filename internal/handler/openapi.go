@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"xiazki/internal/api"
+	"xiazki/web/template/apidocs"
+	"xiazki/web/template/books"
+
+	"github.com/labstack/echo/v4"
+)
+
+// nameQuery is the query of the endpoints filtering by a part of a name or
+// text.
+type nameQuery struct {
+	Q string `query:"q"`
+}
+
+// APIEndpoints are the endpoints of the API under /api/v1, from which the
+// OpenAPI document is generated. Every route of the API must be listed here,
+// `xiazki openapi check` compares them.
+var APIEndpoints = []api.Endpoint{
+	{Method: http.MethodGet, Path: "/books", Tag: "books", Summary: "List the books in your library",
+		Query: books.FilterValues{}, Paged: true, Status: http.StatusOK, Response: api.Book{}},
+	{Method: http.MethodPost, Path: "/books", Tag: "books", Summary: "Add a book to your library, and to the catalog if it is new",
+		Body: api.BookInput{}, Status: http.StatusCreated, Response: api.Book{}},
+	{Method: http.MethodGet, Path: "/books/:id", Tag: "books", Summary: "Get a book with your events",
+		Status: http.StatusOK, Response: api.Book{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/books/:id", Tag: "books", Summary: "Change a book you added, fields left out are cleared",
+		Body: api.BookInput{}, Status: http.StatusOK, Response: api.Book{}, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/books/:id", Tag: "books", Summary: "Remove a book from your library, it stays in the catalog",
+		Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/books/:id/events", Tag: "events", Summary: "List your events of a book",
+		Status: http.StatusOK, Response: []api.Event{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/books/:id/events", Tag: "events", Summary: "Add an event to a book",
+		Body: api.EventInput{}, Status: http.StatusCreated, Response: api.Event{}, Errors: []int{http.StatusNotFound, http.StatusConflict}},
+	{Method: http.MethodDelete, Path: "/events/:id", Tag: "events", Summary: "Delete one of your events",
+		Status: http.StatusNoContent, Errors: []int{http.StatusForbidden, http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/books/:id/reviews", Tag: "reviews", Summary: "List the reviews of a book by all users",
+		Paged: true, Status: http.StatusOK, Response: api.Review{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/books/:id/review", Tag: "reviews", Summary: "Get your review of a book",
+		Status: http.StatusOK, Response: api.Review{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/books/:id/review", Tag: "reviews", Summary: "Rate or review a book",
+		Body: api.ReviewInput{}, Status: http.StatusOK, Response: api.Review{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/books/:id/review", Tag: "reviews", Summary: "Delete your review of a book",
+		Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/books/:id/quotes", Tag: "quotes", Summary: "List your quotes from a book",
+		Paged: true, Status: http.StatusOK, Response: api.Quote{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPost, Path: "/books/:id/quotes", Tag: "quotes", Summary: "Add a quote from a book",
+		Body: api.QuoteInput{}, Status: http.StatusCreated, Response: api.Quote{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/books/:id/quotes/:quote_id", Tag: "quotes", Summary: "Get one of your quotes",
+		Status: http.StatusOK, Response: api.Quote{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodPut, Path: "/books/:id/quotes/:quote_id", Tag: "quotes", Summary: "Change one of your quotes",
+		Body: api.QuoteInput{}, Status: http.StatusOK, Response: api.Quote{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodDelete, Path: "/books/:id/quotes/:quote_id", Tag: "quotes", Summary: "Delete one of your quotes",
+		Status: http.StatusNoContent, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/quotes", Tag: "quotes", Summary: "List your quotes, the newest first",
+		Query: nameQuery{}, Paged: true, Status: http.StatusOK, Response: api.Quote{}},
+	{Method: http.MethodGet, Path: "/authors", Tag: "authors", Summary: "List the authors",
+		Query: nameQuery{}, Paged: true, Status: http.StatusOK, Response: api.Author{}},
+	{Method: http.MethodGet, Path: "/authors/:id", Tag: "authors", Summary: "Get an author with their books",
+		Status: http.StatusOK, Response: api.Author{}, Errors: []int{http.StatusNotFound}},
+	{Method: http.MethodGet, Path: "/tags", Tag: "tags", Summary: "List the tags",
+		Query: nameQuery{}, Paged: true, Status: http.StatusOK, Response: api.Name{}},
+	{Method: http.MethodGet, Path: "/search", Tag: "search", Summary: "Search books, reviews and your quotes",
+		Query: nameQuery{}, Status: http.StatusOK, Response: api.SearchResults{}},
+}
+
+// openAPIDocument returns the OpenAPI document of the API as served under the
+// base path.
+func openAPIDocument(c echo.Context) *api.Document {
+	return api.NewDocument(withBasePath(c, "/api/"+api.Version), APIEndpoints)
+}
+
+func (h *Handler) GetOpenAPI(c echo.Context) error {
+	return c.JSON(http.StatusOK, openAPIDocument(c))
+}
+
+// GetAPIDocs shows the endpoints of the API.
+func (h *Handler) GetAPIDocs(c echo.Context) error {
+	return Render(c, apidocs.Show(openAPIDocument(c)))
+}
+
+// CheckOpenAPI compares the routes registered under prefix, the path of the
+// API, with the OpenAPI document and returns how they differ.
+func CheckOpenAPI(routes []*echo.Route, prefix string) []string {
+	doc := api.NewDocument(prefix, APIEndpoints)
+
+	var problems []string
+	registered := make(map[string]bool)
+	for _, r := range routes {
+		path, ok := strings.CutPrefix(r.Path, prefix)
+		if !ok || r.Method == echo.RouteNotFound || path == "" || path == "/*" {
+			continue
+		}
+		path = api.OpenAPIPath(path)
+		registered[r.Method+" "+path] = true
+		if doc.Paths[path][strings.ToLower(r.Method)] == nil {
+			problems = append(problems, fmt.Sprintf("%s %s is not in the document", r.Method, path))
+		}
+	}
+
+	ids := make(map[string]string)
+	for _, path := range slices.Sorted(maps.Keys(doc.Paths)) {
+		for _, method := range slices.Sorted(maps.Keys(doc.Paths[path])) {
+			op := doc.Paths[path][method]
+			name := strings.ToUpper(method) + " " + path
+			if !registered[name] {
+				problems = append(problems, fmt.Sprintf("%s is in the document but not routed", name))
+			}
+			if other, ok := ids[op.OperationID]; ok {
+				problems = append(problems, fmt.Sprintf("%s and %s have the same operation ID %s", other, name, op.OperationID))
+			}
+			ids[op.OperationID] = name
+		}
+	}
+	return problems
+}
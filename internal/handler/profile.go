@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"fmt"
 	"net/http"
+	"slices"
+	"time"
 
+	"xiazki/internal/exporter"
 	"xiazki/web/template/profile"
 
 	"github.com/labstack/echo/v4"
@@ -14,10 +18,45 @@ func (h *Handler) GetProfile(c echo.Context) error {
 		return err
 	}
 
-	return Render(c, profile.Show(profile.Data{User: user}))
+	providers, err := h.profileProviders(c, user)
+	if err != nil {
+		c.Logger().Error("Failed to fetch identities: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch identities")
+	}
+
+	totp, err := h.totpData(c, user)
+	if err != nil {
+		c.Logger().Error("Failed to count recovery codes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count recovery codes")
+	}
+
+	sessions, err := h.profileSessions(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch sessions")
+	}
+
+	apiTokens, err := h.profileAPITokens(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch API tokens: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch API tokens")
+	}
+
+	return Render(c, profile.Show(profile.Data{
+		User:          user,
+		PasswordLogin: h.cfg.Auth.PasswordLogin,
+		Providers:     providers,
+		TOTP:          totp,
+		Sessions:      sessions,
+		APITokens:     apiTokens,
+	}))
 }
 
 func (h *Handler) PostUserChangePassword(c echo.Context) error {
+	if !h.cfg.Auth.PasswordLogin {
+		return errPasswordLoginDisabled
+	}
+
 	user, err := h.currentUser(c)
 	if err != nil {
 		return err
@@ -41,11 +80,46 @@ func (h *Handler) PostUserChangePassword(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to set new password")
 	}
 
-	if _, err := h.db.NewUpdate().Model(user).Column("password").WherePK().Exec(c.Request().Context()); err != nil {
+	ctx := c.Request().Context()
+	if _, err := h.db.NewUpdate().Model(user).Column("password").WherePK().Exec(ctx); err != nil {
 		c.Logger().Error("Failed to update password: ", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update password")
 	}
 
+	// whoever knew the old password is signed out
+	if s, err := h.currentSession(c); err != nil {
+		return err
+	} else if err := h.db.DeleteUserSessions(ctx, user.ID, s.ID); err != nil {
+		c.Logger().Error("Failed to delete sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to sign out other devices")
+	}
+
 	// TODO: flash message "Password changed successfully"
 	return HxRedirect(c, "/profile")
 }
+
+func (h *Handler) GetProfileExport(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	format := exporter.Format(c.QueryParam("format"))
+	if format == "" {
+		format = exporter.FormatJSON
+	}
+	if !slices.Contains(exporter.Formats, format) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid export format")
+	}
+
+	filename := fmt.Sprintf("xiazki-%s-%s.%s", format, time.Now().Format("2006-01-02"), format.Extension())
+	c.Response().Header().Set(echo.HeaderContentType, format.ContentType())
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="`+filename+`"`)
+	c.Response().WriteHeader(http.StatusOK)
+
+	if err := exporter.Export(c.Request().Context(), h.db, user.ID, format, c.Response()); err != nil {
+		// the response has already started, so only log the failure
+		c.Logger().Error("Failed to export library: ", err)
+	}
+	return nil
+}
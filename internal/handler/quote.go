@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/web/template/quotes"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+func (h *Handler) GetBookQuotes(c echo.Context) error {
+	idStr := c.Param("id")
+	bookID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var b model.Book
+	err = h.db.NewSelect().
+		Model(&b).
+		Where("id = ?", bookID).
+		Column("id", "title", "cover_url").
+		Relation("Authors").
+		Limit(1).
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch book details: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book details")
+	}
+
+	var q []*model.Quote
+	err = h.db.NewSelect().
+		Model(&q).
+		Where("book_id = ? AND user_id = ?", bookID, user.ID).
+		OrderExpr("CASE WHEN page IS NULL THEN 1 ELSE 0 END ASC").
+		OrderExpr("page ASC").
+		OrderExpr("created_at ASC").
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch quotes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotes")
+	}
+
+	return Render(c, quotes.Show(quotes.Data{
+		Book:   &b,
+		Quotes: q,
+	}))
+}
+
+func (h *Handler) PostBookQuote(c echo.Context) error {
+	idStr := c.Param("id")
+	bookID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	var qfv quotes.QuoteFormValues
+	if err := c.Bind(&qfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	if errors := qfv.Validate(); len(errors) > 0 {
+		return Render(c, quotes.Form(quotes.Data{
+			Book:   &model.Book{ID: bookID},
+			Values: qfv,
+			Errors: errors,
+		}))
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	exists, err := h.db.NewSelect().
+		Model((*model.Book)(nil)).
+		Where("id = ?", bookID).
+		Exists(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book")
+	} else if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+
+	quote := qfv.ToQuote()
+	quote.UserID = user.ID
+	quote.BookID = bookID
+	if err := h.db.InsertQuote(c.Request().Context(), quote); err != nil {
+		c.Logger().Error("Failed to add quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add quote")
+	}
+
+	return HxRedirect(c, "/book/"+idStr+"/quotes")
+}
+
+func (h *Handler) GetBookQuoteEdit(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+
+	return Render(c, quotes.EditModal(quotes.EditData{
+		Quote:  quote,
+		Values: quotes.QuoteToQuoteFormValues(*quote),
+	}))
+}
+
+func (h *Handler) PutBookQuote(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+
+	var qfv quotes.QuoteFormValues
+	if err := c.Bind(&qfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	if errors := qfv.Validate(); len(errors) > 0 {
+		return Render(c, quotes.EditModal(quotes.EditData{
+			Quote:  quote,
+			Values: qfv,
+			Errors: errors,
+		}))
+	}
+
+	updated := qfv.ToQuote()
+	updated.ID = quote.ID
+	updated.UserID = quote.UserID
+	if err := h.db.UpdateQuote(c.Request().Context(), updated); err != nil {
+		c.Logger().Error("Failed to update quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update quote")
+	}
+
+	return HxRedirect(c, "/book/"+strconv.FormatInt(quote.BookID, 10)+"/quotes")
+}
+
+func (h *Handler) DeleteBookQuote(c echo.Context) error {
+	quote, err := h.userQuote(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DeleteQuote(c.Request().Context(), quote.ID, quote.UserID); err != nil {
+		c.Logger().Error("Failed to delete quote: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete quote")
+	}
+
+	return HxRedirect(c, c.Request().Referer())
+}
+
+func (h *Handler) GetQuotes(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+
+	var q []*model.Quote
+	err = h.db.NewSelect().
+		Model(&q).
+		Relation("Book", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Column("id", "title")
+		}).
+		Where("quote.user_id = ?", user.ID).
+		Apply(func(q *bun.SelectQuery) *bun.SelectQuery {
+			if query == "" {
+				return q
+			}
+			pattern := database.Contains(query)
+			return q.WhereGroup(" AND ", func(q *bun.SelectQuery) *bun.SelectQuery {
+				return q.
+					Where("LOWER(quote.quote) LIKE ? ESCAPE '\\'", pattern).
+					WhereOr("LOWER(quote.note) LIKE ? ESCAPE '\\'", pattern).
+					WhereOr("LOWER(book.title) LIKE ? ESCAPE '\\'", pattern)
+			})
+		}).
+		Order("quote.created_at DESC").
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch quotes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quotes")
+	}
+
+	return Render(c, quotes.List(quotes.ListData{
+		Query:  query,
+		Quotes: q,
+	}))
+}
+
+// userQuote fetches the quote from the :id and :quote_id route parameters and
+// makes sure it belongs to the current user.
+func (h *Handler) userQuote(c echo.Context) (*model.Quote, error) {
+	bookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+	quoteID, err := strconv.ParseInt(c.Param("quote_id"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid quote ID")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var quote model.Quote
+	err = h.db.NewSelect().
+		Model(&quote).
+		Where("id = ? AND book_id = ? AND user_id = ?", quoteID, bookID, user.ID).
+		Limit(1).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Quote not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch quote: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quote")
+	}
+
+	return &quote, nil
+}
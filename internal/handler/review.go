@@ -130,7 +130,7 @@ func (h *Handler) PostBookReview(c echo.Context) error {
 		BookID:  bookID,
 		Rating:  rating,
 		Opinion: opinion,
-	}, true); err != nil {
+	}, true, true); err != nil {
 		c.Logger().Error("Failed to submit review: ", err)
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to submit review")
 	}
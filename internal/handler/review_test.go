@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestReviewOwnership(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	b := insertBook(t, db, u.alice, "Dune")
+	id := strconv.FormatInt(b.ID, 10)
+	ctx := context.Background()
+
+	review := func(user *model.User) *model.Review {
+		t.Helper()
+		var r model.Review
+		err := db.NewSelect().Model(&r).Where("user_id = ? AND book_id = ?", user.ID, b.ID).Scan(ctx)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		return &r
+	}
+
+	form := url.Values{"rating": {"2"}, "opinion": {"changed"}}
+	handlers := []struct {
+		name string
+		fn   echo.HandlerFunc
+		req  func() *http.Request
+		// deletes is set if the handler deletes the review of the user
+		deletes bool
+	}{
+		{"review", h.PostBookReview, func() *http.Request { return formRequest(http.MethodPost, "/book/"+id+"/review", form) }, false},
+		{"rate", h.PostBookRate, func() *http.Request { return formRequest(http.MethodPost, "/book/"+id+"/rate", form) }, false},
+		{"api put", h.PutAPIBookReview, func() *http.Request {
+			return jsonRequest(http.MethodPut, "/api/v1/books/"+id+"/review", `{"rating": 2, "opinion": "changed"}`)
+		}, false},
+		{"api delete", h.DeleteAPIBookReview, func() *http.Request {
+			return jsonRequest(http.MethodDelete, "/api/v1/books/"+id+"/review", "")
+		}, true},
+	}
+
+	// reviews are always those of the current user, so neither the adder of
+	// the book nor an admin can change the review of bob
+	for _, handler := range handlers {
+		for _, user := range []*model.User{u.alice, u.carol, u.bob} {
+			t.Run(handler.name+"/"+user.Username, func(t *testing.T) {
+				if _, err := db.NewDelete().Model((*model.Review)(nil)).Where("book_id = ?", b.ID).Exec(ctx); err != nil {
+					t.Fatal(err)
+				}
+				if err := db.InsertOrUpdateReview(ctx, &model.Review{UserID: u.bob.ID, BookID: b.ID, Rating: 8, Opinion: "spice"}, true, true); err != nil {
+					t.Fatal(err)
+				}
+
+				rec := serve(t, handler.fn, user, handler.req(), "id", id)
+				owner := user == u.bob
+				switch {
+				case handler.deletes && !owner:
+					if rec.Code != http.StatusNotFound {
+						t.Errorf("status = %d, want %d for no review of their own", rec.Code, http.StatusNotFound)
+					}
+				case rec.Code >= 300:
+					t.Errorf("status = %d:\n%s", rec.Code, rec.Body)
+				}
+
+				got := review(u.bob)
+				switch {
+				case !owner && (got == nil || got.Rating != 8 || got.Opinion != "spice"):
+					t.Errorf("review of bob = %+v, want it unchanged", got)
+				case owner && handler.deletes && got != nil:
+					t.Error("review of bob not deleted by bob")
+				case owner && !handler.deletes && (got == nil || got.Rating != 2):
+					t.Errorf("review of bob = %+v, want rating 2", got)
+				}
+				if !owner && !handler.deletes {
+					if own := review(user); own == nil || own.Rating != 2 {
+						t.Errorf("own review = %+v, want rating 2", own)
+					}
+				}
+			})
+		}
+	}
+}
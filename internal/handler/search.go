@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"xiazki/web/template/search"
+
+	"github.com/labstack/echo/v4"
+)
+
+const searchLimit = 20
+
+func (h *Handler) GetSearch(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	query := strings.TrimSpace(c.QueryParam("q"))
+
+	results, err := h.db.Search(c.Request().Context(), user.ID, query, searchLimit)
+	if err != nil {
+		c.Logger().Error("Failed to search: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to search")
+	}
+
+	return Render(c, search.Show(search.Data{
+		Query:   query,
+		Results: results,
+	}))
+}
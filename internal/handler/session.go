@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"xiazki/web/template/profile"
+
+	"github.com/labstack/echo/v4"
+)
+
+// profileSessions returns the devices the user is signed in on for the
+// profile page.
+func (h *Handler) profileSessions(c echo.Context) (profile.Sessions, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return profile.Sessions{}, err
+	}
+	current, err := h.currentSession(c)
+	if err != nil {
+		return profile.Sessions{}, err
+	}
+
+	sessions, err := h.db.UserSessions(c.Request().Context(), user.ID)
+	return profile.Sessions{Sessions: sessions, Current: current.ID}, err
+}
+
+// DeleteProfileSession signs the user out on another device.
+func (h *Handler) DeleteProfileSession(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid session ID")
+	}
+
+	if current, err := h.currentSession(c); err != nil {
+		return err
+	} else if id == current.ID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Sign out to end the current session")
+	}
+
+	if err := h.db.DeleteSession(c.Request().Context(), user.ID, id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete session: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete session")
+	}
+
+	data, err := h.profileSessions(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch sessions")
+	}
+	return Render(c, profile.SessionList(data))
+}
+
+// DeleteProfileSessions signs the user out everywhere, this device included.
+func (h *Handler) DeleteProfileSessions(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DeleteUserSessions(c.Request().Context(), user.ID, 0); err != nil {
+		c.Logger().Error("Failed to delete sessions: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete sessions")
+	}
+	_ = h.clearSession(c)
+	return HxRedirect(c, "/login")
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+
+	"github.com/labstack/echo/v4"
+)
+
+// sessionIDs returns the IDs of the sessions of user, the last used first.
+func sessionIDs(t *testing.T, db *database.DB, user *model.User) []int64 {
+	t.Helper()
+	sessions, err := db.UserSessions(context.Background(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make([]int64, len(sessions))
+	for i, s := range sessions {
+		ids[i] = s.ID
+	}
+	return ids
+}
+
+// signedIn reports whether the cookies still belong to a valid session.
+func signedIn(t *testing.T, h *Handler, cookies []*http.Cookie) bool {
+	t.Helper()
+	ok := h.RequireAuth(func(c echo.Context) error { return c.NoContent(http.StatusNoContent) })
+	rec := serve(t, ok, nil, withCookies(httptest.NewRequest(http.MethodGet, "/books", nil), cookies))
+	return rec.Code == http.StatusNoContent
+}
+
+func TestProfileSessions(t *testing.T) {
+	h, db := newTestHandler(t)
+	alice := dbtest.User(t, db, "alice", model.RoleUser)
+	bob := dbtest.User(t, db, "bob", model.RoleUser)
+
+	laptop := startSession(t, h, alice, true)
+	phone := startSession(t, h, alice, true)
+	bobs := startSession(t, h, bob, true)
+	ids := sessionIDs(t, db, alice)
+	if len(ids) != 2 {
+		t.Fatalf("alice has %d sessions, want 2", len(ids))
+	}
+	phoneID, laptopID := strconv.FormatInt(ids[0], 10), strconv.FormatInt(ids[1], 10)
+	bobID := strconv.FormatInt(sessionIDs(t, db, bob)[0], 10)
+
+	// the profile lists the other session to sign out
+	rec := serve(t, h.GetProfile, nil, withCookies(httptest.NewRequest(http.MethodGet, "/profile", nil), laptop))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("profile: status = %d:\n%s", rec.Code, rec.Body)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "/profile/sessions/"+phoneID) || strings.Contains(body, "/profile/sessions/"+laptopID) {
+		t.Errorf("profile does not offer to sign out exactly the phone:\n%s", body)
+	}
+
+	for _, tt := range []struct {
+		name string
+		id   string
+		want int
+	}{
+		{"invalid", "phone", http.StatusBadRequest},
+		{"current", laptopID, http.StatusBadRequest},
+		{"other user", bobID, http.StatusNotFound},
+		{"other device", phoneID, http.StatusOK},
+		{"signed out", phoneID, http.StatusNotFound},
+	} {
+		req := withCookies(formRequest(http.MethodDelete, "/profile/sessions/"+tt.id, nil), laptop)
+		if rec := serve(t, h.DeleteProfileSession, nil, req, "id", tt.id); rec.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d:\n%s", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+	if signedIn(t, h, phone) {
+		t.Error("the phone is still signed in")
+	}
+	if !signedIn(t, h, laptop) || !signedIn(t, h, bobs) {
+		t.Fatal("signing out the phone signed out other sessions")
+	}
+
+	rec = serve(t, h.DeleteProfileSessions, nil, withCookies(formRequest(http.MethodDelete, "/profile/sessions", nil), laptop))
+	if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/login" {
+		t.Errorf("sign out everywhere: status = %d, HX-Redirect = %q", rec.Code, rec.Header().Get("HX-Redirect"))
+	}
+	if signedIn(t, h, laptop) || len(sessionIDs(t, db, alice)) != 0 {
+		t.Error("alice is still signed in somewhere")
+	}
+	if !signedIn(t, h, bobs) {
+		t.Error("bob was signed out too")
+	}
+}
@@ -0,0 +1,348 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"xiazki/internal/model"
+	"xiazki/web/template/shelves"
+
+	"github.com/labstack/echo/v4"
+	"github.com/uptrace/bun"
+)
+
+func (h *Handler) GetShelves(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	s, err := h.userShelves(c, user)
+	if err != nil {
+		return err
+	}
+
+	return Render(c, shelves.List(shelves.ListData{
+		Shelves: s,
+	}))
+}
+
+func (h *Handler) PostShelf(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var sfv shelves.ShelfFormValues
+	if err := c.Bind(&sfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	errs := sfv.Validate()
+	if len(errs) == 0 {
+		if taken, err := h.shelfNameTaken(c, user, sfv.Name, 0); err != nil {
+			return err
+		} else if taken {
+			errs["name"] = "You already have a shelf with this name"
+		}
+	}
+	if len(errs) > 0 {
+		return Render(c, shelves.CreateForm(sfv, errs))
+	}
+
+	shelf := sfv.ToShelf()
+	shelf.UserID = user.ID
+	if err := h.db.InsertShelf(c.Request().Context(), shelf); err != nil {
+		c.Logger().Error("Failed to create shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create shelf")
+	}
+
+	return HxRedirect(c, "/shelf/"+strconv.FormatInt(shelf.ID, 10))
+}
+
+func (h *Handler) GetShelf(c echo.Context) error {
+	shelf, err := h.userShelf(c)
+	if err != nil {
+		return err
+	}
+
+	var b []*model.Book
+	err = h.db.NewSelect().
+		Model(&b).
+		Join("JOIN shelf_books AS sb ON sb.book_id = book.id").
+		Where("sb.shelf_id = ?", shelf.ID).
+		Relation("Authors").
+		Relation("Events", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Where("user_id = ?", shelf.UserID).OrderExpr("CASE WHEN type = ? THEN 3 WHEN type = ? THEN 2 WHEN type = ? THEN 1 ELSE 0 END ASC", model.EventFinished, model.EventDropped, model.EventReading).OrderExpr("date ASC")
+		}).
+		OrderExpr("sb.position ASC").
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch shelf books: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch shelf books")
+	}
+
+	return Render(c, shelves.Show(shelves.Data{
+		Shelf:  shelf,
+		Books:  b,
+		Values: shelves.ShelfToShelfFormValues(*shelf),
+	}))
+}
+
+func (h *Handler) PutShelf(c echo.Context) error {
+	shelf, err := h.userShelf(c)
+	if err != nil {
+		return err
+	}
+
+	var sfv shelves.ShelfFormValues
+	if err := c.Bind(&sfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	errs := sfv.Validate()
+	if len(errs) == 0 {
+		user := &model.User{ID: shelf.UserID}
+		if taken, err := h.shelfNameTaken(c, user, sfv.Name, shelf.ID); err != nil {
+			return err
+		} else if taken {
+			errs["name"] = "You already have a shelf with this name"
+		}
+	}
+	if len(errs) > 0 {
+		return Render(c, shelves.RenameForm(shelf, sfv, errs))
+	}
+
+	name := sfv.ToShelf().Name
+	if err := h.db.RenameShelf(c.Request().Context(), shelf.ID, shelf.UserID, name); err != nil {
+		c.Logger().Error("Failed to rename shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to rename shelf")
+	}
+
+	return HxRedirect(c, "/shelf/"+strconv.FormatInt(shelf.ID, 10))
+}
+
+func (h *Handler) DeleteShelf(c echo.Context) error {
+	shelf, err := h.userShelf(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DeleteShelf(c.Request().Context(), shelf.ID, shelf.UserID); err != nil {
+		c.Logger().Error("Failed to delete shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete shelf")
+	}
+
+	return HxRedirect(c, "/shelves")
+}
+
+func (h *Handler) DeleteShelfBook(c echo.Context) error {
+	shelf, err := h.userShelf(c)
+	if err != nil {
+		return err
+	}
+
+	bookID, err := strconv.ParseInt(c.Param("book_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	if err := h.db.RemoveShelfBook(c.Request().Context(), shelf.ID, bookID); err != nil {
+		c.Logger().Error("Failed to remove book from shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove book from shelf")
+	}
+
+	return HxRedirect(c, "/shelf/"+strconv.FormatInt(shelf.ID, 10))
+}
+
+func (h *Handler) PostShelfBookMove(c echo.Context) error {
+	shelf, err := h.userShelf(c)
+	if err != nil {
+		return err
+	}
+
+	bookID, err := strconv.ParseInt(c.Param("book_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	var up bool
+	switch c.FormValue("direction") {
+	case "up":
+		up = true
+	case "down":
+		up = false
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid direction")
+	}
+
+	err = h.db.MoveShelfBook(c.Request().Context(), shelf.ID, bookID, up)
+	if errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Book is not on this shelf")
+	} else if err != nil {
+		c.Logger().Error("Failed to move book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to move book")
+	}
+
+	return HxRedirect(c, "/shelf/"+strconv.FormatInt(shelf.ID, 10))
+}
+
+func (h *Handler) GetBookShelves(c echo.Context) error {
+	bookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	return h.renderBookShelves(c, bookID)
+}
+
+func (h *Handler) PostBookShelf(c echo.Context) error {
+	bookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	shelf, err := h.userShelfByParam(c, "shelf_id")
+	if err != nil {
+		return err
+	}
+
+	exists, err := h.db.NewSelect().
+		Model((*model.Book)(nil)).
+		Where("id = ?", bookID).
+		Exists(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch book: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book")
+	} else if !exists {
+		return echo.NewHTTPError(http.StatusNotFound, "Book not found")
+	}
+
+	if err := h.db.AddShelfBook(c.Request().Context(), shelf.ID, bookID); err != nil {
+		c.Logger().Error("Failed to add book to shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add book to shelf")
+	}
+
+	return h.renderBookShelves(c, bookID)
+}
+
+func (h *Handler) DeleteBookShelf(c echo.Context) error {
+	bookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid book ID")
+	}
+
+	shelf, err := h.userShelfByParam(c, "shelf_id")
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.RemoveShelfBook(c.Request().Context(), shelf.ID, bookID); err != nil {
+		c.Logger().Error("Failed to remove book from shelf: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove book from shelf")
+	}
+
+	return h.renderBookShelves(c, bookID)
+}
+
+// renderBookShelves renders the modal listing all shelves of the current user
+// with the ones containing the book marked.
+func (h *Handler) renderBookShelves(c echo.Context, bookID int64) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	s, err := h.userShelves(c, user)
+	if err != nil {
+		return err
+	}
+
+	var ids []int64
+	err = h.db.NewSelect().
+		Model((*model.ShelfBook)(nil)).
+		Column("shelf_book.shelf_id").
+		Join("JOIN shelves AS s ON s.id = shelf_book.shelf_id").
+		Where("shelf_book.book_id = ? AND s.user_id = ?", bookID, user.ID).
+		Scan(c.Request().Context(), &ids)
+	if err != nil {
+		c.Logger().Error("Failed to fetch book shelves: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch book shelves")
+	}
+
+	on := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		on[id] = true
+	}
+
+	return Render(c, shelves.BookModal(shelves.BookData{
+		BookID:  bookID,
+		Shelves: s,
+		On:      on,
+	}))
+}
+
+// userShelves fetches all shelves of the user ordered by name, together with
+// the number of books on each of them.
+func (h *Handler) userShelves(c echo.Context, user *model.User) ([]shelves.ShelfCount, error) {
+	var s []shelves.ShelfCount
+	err := h.db.NewSelect().
+		Model(&s).
+		ColumnExpr("shelf.*").
+		ColumnExpr("(SELECT COUNT(*) FROM shelf_books AS sb JOIN books AS b ON b.id = sb.book_id WHERE sb.shelf_id = shelf.id) AS book_count").
+		Where("shelf.user_id = ?", user.ID).
+		OrderExpr("LOWER(shelf.name) ASC").
+		Scan(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch shelves: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch shelves")
+	}
+	return s, nil
+}
+
+func (h *Handler) shelfNameTaken(c echo.Context, user *model.User, name string, exceptID int64) (bool, error) {
+	taken, err := h.db.NewSelect().
+		Model((*model.Shelf)(nil)).
+		Where("user_id = ? AND name = ? AND id != ?", user.ID, shelves.ShelfFormValues{Name: name}.ToShelf().Name, exceptID).
+		Exists(c.Request().Context())
+	if err != nil {
+		c.Logger().Error("Failed to fetch shelves: ", err)
+		return false, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch shelves")
+	}
+	return taken, nil
+}
+
+// userShelf fetches the shelf from the :id route parameter and makes sure it
+// belongs to the current user.
+func (h *Handler) userShelf(c echo.Context) (*model.Shelf, error) {
+	return h.userShelfByParam(c, "id")
+}
+
+func (h *Handler) userShelfByParam(c echo.Context, param string) (*model.Shelf, error) {
+	shelfID, err := strconv.ParseInt(c.Param(param), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid shelf ID")
+	}
+
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Unauthorized")
+	}
+
+	var shelf model.Shelf
+	err = h.db.NewSelect().
+		Model(&shelf).
+		Where("id = ? AND user_id = ?", shelfID, user.ID).
+		Limit(1).
+		Scan(c.Request().Context())
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Shelf not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch shelf: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch shelf")
+	}
+
+	return &shelf, nil
+}
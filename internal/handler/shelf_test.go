@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+)
+
+// newShelf adds a shelf of user.
+func newShelf(t *testing.T, db *database.DB, user *model.User, name string) *model.Shelf {
+	t.Helper()
+	shelf := &model.Shelf{Name: name, UserID: user.ID}
+	if err := db.InsertShelf(context.Background(), shelf); err != nil {
+		t.Fatal(err)
+	}
+	return shelf
+}
+
+// shelfOrder returns the books of a shelf in their order.
+func shelfOrder(t *testing.T, db *database.DB, shelf *model.Shelf) []int64 {
+	t.Helper()
+	var ids []int64
+	err := db.NewSelect().
+		Model((*model.ShelfBook)(nil)).
+		Column("book_id").
+		Where("shelf_id = ?", shelf.ID).
+		Order("position").
+		Scan(context.Background(), &ids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ids
+}
+
+func TestShelfOrder(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	shelf := newShelf(t, db, u.alice, "Favourites")
+	id := strconv.FormatInt(shelf.ID, 10)
+	a, b, c := insertBook(t, db, u.alice, "Dune"), insertBook(t, db, u.alice, "Emma"), insertBook(t, db, u.alice, "Ulysses")
+
+	add := func(book *model.Book) {
+		t.Helper()
+		bookID := strconv.FormatInt(book.ID, 10)
+		req := formRequest(http.MethodPost, "/book/"+bookID+"/shelves/"+id, nil)
+		if rec := serve(t, h.PostBookShelf, u.alice, req, "id", bookID, "shelf_id", id); rec.Code != http.StatusOK {
+			t.Fatalf("add %s: status = %d:\n%s", book.Title, rec.Code, rec.Body)
+		}
+	}
+	move := func(book *model.Book, direction string) int {
+		t.Helper()
+		bookID := strconv.FormatInt(book.ID, 10)
+		req := formRequest(http.MethodPost, "/shelf/"+id+"/books/"+bookID+"/move", url.Values{"direction": {direction}})
+		return serve(t, h.PostShelfBookMove, u.alice, req, "id", id, "book_id", bookID).Code
+	}
+	assertOrder := func(step string, want ...*model.Book) {
+		t.Helper()
+		ids := make([]int64, len(want))
+		for i, book := range want {
+			ids[i] = book.ID
+		}
+		if got := shelfOrder(t, db, shelf); !slices.Equal(got, ids) {
+			t.Fatalf("%s: order = %v, want %v", step, got, ids)
+		}
+	}
+
+	add(a)
+	add(b)
+	add(c)
+	// adding a book again keeps its place
+	add(a)
+	assertOrder("added", a, b, c)
+
+	if code := move(c, "up"); code != http.StatusOK {
+		t.Fatalf("move up: status = %d", code)
+	}
+	assertOrder("moved up", a, c, b)
+	if code := move(a, "down"); code != http.StatusOK {
+		t.Fatalf("move down: status = %d", code)
+	}
+	assertOrder("moved down", c, a, b)
+
+	// the ends stay where they are
+	move(c, "up")
+	move(b, "down")
+	assertOrder("moved past the ends", c, a, b)
+
+	if code := move(a, "sideways"); code != http.StatusBadRequest {
+		t.Errorf("invalid direction: status = %d, want %d", code, http.StatusBadRequest)
+	}
+	other := insertBook(t, db, u.alice, "Walden")
+	if code := move(other, "up"); code != http.StatusNotFound {
+		t.Errorf("book not on the shelf: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	// the shelf page lists the books in their order
+	rec := serve(t, h.GetShelf, u.alice, httptest.NewRequest(http.MethodGet, "/shelf/"+id, nil), "id", id)
+	body := rec.Body.String()
+	if i, j, k := strings.Index(body, "Ulysses"), strings.Index(body, "Dune"), strings.Index(body, "Emma"); rec.Code != http.StatusOK || i < 0 || !(i < j && j < k) {
+		t.Errorf("shelf page: status = %d, books not in the order of the shelf:\n%s", rec.Code, body)
+	}
+
+	// a removed book comes back at the end
+	aID := strconv.FormatInt(a.ID, 10)
+	req := formRequest(http.MethodDelete, "/shelf/"+id+"/books/"+aID, nil)
+	if rec := serve(t, h.DeleteShelfBook, u.alice, req, "id", id, "book_id", aID); rec.Code != http.StatusOK {
+		t.Fatalf("remove: status = %d", rec.Code)
+	}
+	assertOrder("removed", c, b)
+	add(a)
+	assertOrder("added again", c, b, a)
+}
+
+func TestShelfOwnership(t *testing.T) {
+	h, db := newTestHandler(t)
+	u := newBookUsers(t, db)
+	shelf := newShelf(t, db, u.alice, "Favourites")
+	id := strconv.FormatInt(shelf.ID, 10)
+	a, b := insertBook(t, db, u.alice, "Dune"), insertBook(t, db, u.alice, "Emma")
+	for _, book := range []*model.Book{a, b} {
+		if err := db.AddShelfBook(context.Background(), shelf.ID, book.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	aID, bID := strconv.FormatInt(a.ID, 10), strconv.FormatInt(b.ID, 10)
+	other := insertBook(t, db, u.bob, "Walden")
+	otherID := strconv.FormatInt(other.ID, 10)
+
+	requests := []struct {
+		name  string
+		serve func(user *model.User) int
+	}{
+		{"show", func(user *model.User) int {
+			return serve(t, h.GetShelf, user, httptest.NewRequest(http.MethodGet, "/shelf/"+id, nil), "id", id).Code
+		}},
+		{"rename", func(user *model.User) int {
+			req := formRequest(http.MethodPut, "/shelf/"+id, url.Values{"name": {"Mine"}})
+			return serve(t, h.PutShelf, user, req, "id", id).Code
+		}},
+		{"delete", func(user *model.User) int {
+			return serve(t, h.DeleteShelf, user, formRequest(http.MethodDelete, "/shelf/"+id, nil), "id", id).Code
+		}},
+		{"remove book", func(user *model.User) int {
+			req := formRequest(http.MethodDelete, "/shelf/"+id+"/books/"+aID, nil)
+			return serve(t, h.DeleteShelfBook, user, req, "id", id, "book_id", aID).Code
+		}},
+		{"move book", func(user *model.User) int {
+			req := formRequest(http.MethodPost, "/shelf/"+id+"/books/"+bID+"/move", url.Values{"direction": {"up"}})
+			return serve(t, h.PostShelfBookMove, user, req, "id", id, "book_id", bID).Code
+		}},
+		{"add book", func(user *model.User) int {
+			req := formRequest(http.MethodPost, "/book/"+otherID+"/shelves/"+id, nil)
+			return serve(t, h.PostBookShelf, user, req, "id", otherID, "shelf_id", id).Code
+		}},
+		{"take book off", func(user *model.User) int {
+			req := formRequest(http.MethodDelete, "/book/"+aID+"/shelves/"+id, nil)
+			return serve(t, h.DeleteBookShelf, user, req, "id", aID, "shelf_id", id).Code
+		}},
+	}
+
+	// shelves are private, not even admins may see or change those of others
+	for _, user := range []*model.User{u.bob, u.carol} {
+		for _, r := range requests {
+			if code := r.serve(user); code != http.StatusNotFound {
+				t.Errorf("%s by %s: status = %d, want %d", r.name, user.Username, code, http.StatusNotFound)
+			}
+		}
+	}
+
+	var got model.Shelf
+	if err := db.NewSelect().Model(&got).Where("id = ?", shelf.ID).Scan(context.Background()); err != nil {
+		t.Fatalf("shelf gone: %v", err)
+	}
+	if got.Name != "Favourites" {
+		t.Errorf("shelf renamed to %q", got.Name)
+	}
+	if order := shelfOrder(t, db, shelf); !slices.Equal(order, []int64{a.ID, b.ID}) {
+		t.Errorf("books on the shelf = %v, want %v", order, []int64{a.ID, b.ID})
+	}
+}
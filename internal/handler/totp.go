@@ -0,0 +1,246 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"xiazki/internal/login"
+	"xiazki/internal/model"
+	"xiazki/web/template/auth"
+	"xiazki/web/template/profile"
+
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// totpPendingKey keeps the secret of an authenticator being set up in the
+// session until the user confirms it with a code.
+const totpPendingKey = "totp_pending"
+
+// pendingUser returns the user of a session who still has to pass the second
+// factor. ok is false if there is no such user.
+func (h *Handler) pendingUser(c echo.Context) (user *model.User, ok bool, err error) {
+	user, s, err := h.sessionUser(c)
+	if isUnauthorized(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return user, user.HasTOTP() && !s.SecondFactor, nil
+}
+
+func (h *Handler) GetLoginTOTP(c echo.Context) error {
+	_, ok, err := h.pendingUser(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch user: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+	} else if !ok {
+		return c.Redirect(http.StatusSeeOther, withBasePath(c, "/login"))
+	}
+	return Render(c, auth.SecondFactor(""))
+}
+
+// PostLoginTOTP completes the login of a user who set up a second factor with
+// an authentication or recovery code.
+func (h *Handler) PostLoginTOTP(c echo.Context) error {
+	user, ok, err := h.pendingUser(c)
+	if err != nil {
+		c.Logger().Error("Failed to fetch user: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
+	} else if !ok {
+		return HxRedirect(c, "/login")
+	}
+
+	ctx := c.Request().Context()
+	attempt, wait, err := h.throttle.Begin(ctx, user.Username, c.RealIP())
+	if err != nil {
+		c.Logger().Error("Failed to check login attempts: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check login attempts")
+	} else if wait > 0 {
+		wait = wait.Truncate(time.Second) + time.Second
+		c.Response().Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())))
+		return Render(c, auth.SecondFactorForm(fmt.Sprintf("Too many failed attempts, try again in %s", wait)))
+	}
+
+	valid, err := login.CheckSecondFactor(ctx, h.db, user, c.FormValue("code"))
+	if err != nil {
+		c.Logger().Error("Failed to check authentication code: ", err)
+		if err := attempt.Cancel(ctx); err != nil {
+			c.Logger().Error("Failed to record login attempt: ", err)
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check authentication code")
+	}
+	if !valid {
+		return Render(c, auth.SecondFactorForm("Invalid code"))
+	}
+	if err := attempt.Succeed(ctx); err != nil {
+		c.Logger().Error("Failed to record login attempt: ", err)
+	}
+
+	if err := h.createSession(c, user.ID, true); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+	return HxRedirect(c, "/books")
+}
+
+// signIn starts the session of a user who passed the first factor and
+// redirects them to the second one if they have set it up.
+func (h *Handler) signIn(c echo.Context, user *model.User) (string, error) {
+	if err := h.createSession(c, user.ID, false); err != nil {
+		return "", echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
+	}
+	if user.HasTOTP() {
+		return "/login/totp", nil
+	}
+	return "/books", nil
+}
+
+// totpData returns the state of the two-factor section of the profile page.
+func (h *Handler) totpData(c echo.Context, user *model.User) (profile.TOTP, error) {
+	if !user.HasTOTP() {
+		return profile.TOTP{}, nil
+	}
+	left, err := h.db.RecoveryCodesLeft(c.Request().Context(), user.ID)
+	return profile.TOTP{Enabled: true, RecoveryCodesLeft: left}, err
+}
+
+// PostProfileTOTP starts setting up an authenticator app.
+func (h *Handler) PostProfileTOTP(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.HasTOTP() {
+		return echo.NewHTTPError(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+
+	key, err := login.NewTOTPKey(user.Username)
+	if err != nil {
+		c.Logger().Error("Failed to generate TOTP key: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate key")
+	}
+
+	sess, err := session.Get(sessionName, c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch session")
+	}
+	sess.Values[totpPendingKey] = key.Secret
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+
+	return Render(c, profile.TwoFactor(profile.TOTP{Secret: key.Secret, QRCode: key.QRCode}))
+}
+
+// PostProfileTOTPConfirm enables the authenticator being set up once the user
+// entered a code of it, and shows the recovery codes.
+func (h *Handler) PostProfileTOTPConfirm(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	if user.HasTOTP() {
+		return echo.NewHTTPError(http.StatusConflict, "Two-factor authentication is already enabled")
+	}
+
+	sess, err := session.Get(sessionName, c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch session")
+	}
+	secret, _ := sess.Values[totpPendingKey].(string)
+	if secret == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Set up two-factor authentication again")
+	}
+
+	step, ok := login.CheckTOTP(secret, c.FormValue("code"), time.Now())
+	if !ok {
+		key, err := login.TOTPKeyOf(user.Username, secret)
+		if err != nil {
+			c.Logger().Error("Failed to decode TOTP key: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to decode key")
+		}
+		return Render(c, profile.TwoFactor(profile.TOTP{
+			Secret: key.Secret,
+			QRCode: key.QRCode,
+			Error:  "Invalid code, check the time of your device",
+		}))
+	}
+
+	recoveryCodes, codes, err := model.NewRecoveryCodes(user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to generate recovery codes: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate recovery codes")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.db.EnableTOTP(ctx, user, secret, recoveryCodes); err != nil {
+		c.Logger().Error("Failed to enable TOTP: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to enable two-factor authentication")
+	}
+	if _, err := h.db.UseTOTPStep(ctx, user.ID, step); err != nil {
+		c.Logger().Error("Failed to record TOTP step: ", err)
+	}
+
+	// the session would not count as signed in anymore otherwise
+	if s, err := h.currentSession(c); err != nil {
+		return err
+	} else if err := h.db.PassSecondFactor(ctx, s.ID); err != nil {
+		c.Logger().Error("Failed to update session: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update session")
+	}
+	delete(sess.Values, totpPendingKey)
+	if err := sess.Save(c.Request(), c.Response()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save session")
+	}
+
+	return Render(c, profile.TwoFactor(profile.TOTP{
+		Enabled:           true,
+		RecoveryCodes:     codes,
+		RecoveryCodesLeft: len(codes),
+	}))
+}
+
+// PostProfileTOTPDisable turns off the second factor of the user, who has to
+// enter a code of it.
+func (h *Handler) PostProfileTOTPDisable(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	if valid, err := login.CheckSecondFactor(ctx, h.db, user, c.FormValue("code")); err != nil {
+		c.Logger().Error("Failed to check authentication code: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check authentication code")
+	} else if !valid {
+		data, err := h.totpData(c, user)
+		if err != nil {
+			c.Logger().Error("Failed to count recovery codes: ", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count recovery codes")
+		}
+		data.Error = "Invalid code"
+		return Render(c, profile.TwoFactor(data))
+	}
+
+	if err := h.db.DisableTOTP(ctx, user.ID); err != nil {
+		c.Logger().Error("Failed to disable TOTP: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to disable two-factor authentication")
+	}
+	return HxRedirect(c, "/profile")
+}
+
+// PostAdminUserTOTPReset turns off the second factor of a user who lost their
+// authenticator and recovery codes.
+func (h *Handler) PostAdminUserTOTPReset(c echo.Context) error {
+	target, err := h.adminTargetUser(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.db.DisableTOTP(c.Request().Context(), target.ID); err != nil {
+		return adminUpdateError(c, err)
+	}
+	return HxRedirect(c, "/admin")
+}
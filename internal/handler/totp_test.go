@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/login"
+	"xiazki/internal/model"
+
+	"github.com/gorilla/sessions"
+	"github.com/pquerna/otp/totp"
+)
+
+// pendingTOTPSecret returns the secret of the authenticator being set up in
+// the session of the cookies.
+func pendingTOTPSecret(t *testing.T, cookies []*http.Cookie) string {
+	t.Helper()
+	req := withCookies(httptest.NewRequest(http.MethodGet, "/", nil), cookies)
+	sess, err := sessions.NewCookieStore([]byte(testSessionSecret)).Get(req, sessionName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, _ := sess.Values[totpPendingKey].(string)
+	if secret == "" {
+		t.Fatal("no authenticator being set up in the session")
+	}
+	return secret
+}
+
+// totpCodes returns a valid code of secret and one which is not.
+func totpCodes(t *testing.T, secret string) (valid, invalid string) {
+	t.Helper()
+	now := time.Now()
+	valid, err := totp.GenerateCode(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, invalid := range []string{"000000", "111111", "222222"} {
+		if _, ok := login.CheckTOTP(secret, invalid, now); !ok {
+			return valid, invalid
+		}
+	}
+	t.Fatal("no invalid code found")
+	return "", ""
+}
+
+func reloadUser(t *testing.T, db *database.DB, user *model.User) *model.User {
+	t.Helper()
+	var u model.User
+	if err := db.NewSelect().Model(&u).Where("id = ?", user.ID).Scan(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return &u
+}
+
+func TestTOTP(t *testing.T) {
+	h, db := newTestHandler(t)
+	alice := dbtest.User(t, db, "alice", model.RoleUser)
+	cookies := startSession(t, h, alice, false)
+
+	confirm := func(code string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := formRequest(http.MethodPost, "/profile/totp/confirm", url.Values{"code": {code}})
+		return serve(t, h.PostProfileTOTPConfirm, nil, withCookies(req, cookies))
+	}
+
+	if rec := confirm("123456"); rec.Code != http.StatusBadRequest {
+		t.Errorf("confirm before the setup: status = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+
+	rec := serve(t, h.PostProfileTOTP, nil, withCookies(formRequest(http.MethodPost, "/profile/totp", nil), cookies))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("setup: status = %d:\n%s", rec.Code, rec.Body)
+	}
+	cookies = rec.Result().Cookies()
+	secret := pendingTOTPSecret(t, cookies)
+	code, wrong := totpCodes(t, secret)
+
+	if rec := confirm(wrong); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "Invalid code") {
+		t.Errorf("confirm with a wrong code: status = %d, body does not show the error:\n%s", rec.Code, rec.Body)
+	}
+	if reloadUser(t, db, alice).HasTOTP() {
+		t.Fatal("TOTP enabled with a wrong code")
+	}
+
+	rec = confirm(code)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "recovery codes") {
+		t.Fatalf("confirm: status = %d, body does not show the recovery codes:\n%s", rec.Code, rec.Body)
+	}
+	alice = reloadUser(t, db, alice)
+	if !alice.HasTOTP() || alice.TOTPSecret != secret {
+		t.Fatal("TOTP not enabled with the secret set up")
+	}
+
+	// signing in again asks for a code
+	cookies = startSession(t, h, alice, false)
+	verify := func(code string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := formRequest(http.MethodPost, "/login/totp", url.Values{"code": {code}})
+		return serve(t, h.PostLoginTOTP, nil, withCookies(req, cookies))
+	}
+	rejected := func(name string, rec *httptest.ResponseRecorder) {
+		t.Helper()
+		if rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "" || !strings.Contains(rec.Body.String(), "Invalid code") {
+			t.Errorf("%s: status = %d, HX-Redirect = %q, want the code rejected:\n%s", name, rec.Code, rec.Header().Get("HX-Redirect"), rec.Body)
+		}
+	}
+
+	rejected("wrong code", verify(wrong))
+	// the code confirming the setup was used up
+	rejected("code of the setup", verify(code))
+
+	// a code of a step not used yet passes, once
+	if _, err := db.NewUpdate().Model((*model.User)(nil)).Set("totp_step = 0").Where("id = ?", alice.ID).Exec(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if rec := verify(code); rec.Code != http.StatusOK || rec.Header().Get("HX-Redirect") != "/books" {
+		t.Fatalf("fresh code: status = %d, HX-Redirect = %q:\n%s", rec.Code, rec.Header().Get("HX-Redirect"), rec.Body)
+	}
+	// on another device, as the login replaced the session
+	cookies = startSession(t, h, alice, false)
+	rejected("reused code", verify(code))
+}
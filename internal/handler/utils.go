@@ -4,10 +4,13 @@ import (
 	"context"
 	"net/http"
 
+	"xiazki/internal/config"
 	"xiazki/internal/database"
+	"xiazki/internal/login"
 	"xiazki/internal/model"
 	"xiazki/internal/services/googlebooks"
 	"xiazki/internal/services/openlibrary"
+	"xiazki/web/template/layout"
 
 	"github.com/a-h/templ"
 	"github.com/labstack/echo/v4"
@@ -19,26 +22,49 @@ type Fetcher interface {
 
 type Handler struct {
 	db      *database.DB
+	cfg     *config.Config
 	fetcher []Fetcher
+	// authenticator checks the login form, providers are the OpenID Connect
+	// providers by name.
+	authenticator login.Chain
+	throttle      *login.Throttle
+	providers     map[string]*loginProvider
 }
 
-func NewHandler(db *database.DB, gbAPIKey string) *Handler {
+func NewHandler(db *database.DB, cfg *config.Config) *Handler {
 	return &Handler{
-		db: db,
+		db:  db,
+		cfg: cfg,
 		fetcher: []Fetcher{
-			googlebooks.NewFetcher(gbAPIKey),
-			openlibrary.NewFetcher(),
+			googlebooks.NewFetcher(cfg.Metadata.GoogleBooksAPIKey, cfg.Metadata.Timeout),
+			openlibrary.NewFetcher(cfg.Metadata.Timeout),
 		},
+		authenticator: newAuthenticator(db, cfg),
+		throttle:      login.NewThrottle(db, cfg.Auth.MaxFailures, cfg.Auth.Lockout),
+		providers:     newLoginProviders(cfg.Auth.OIDC),
 	}
 }
 
 func Render(c echo.Context, component templ.Component) error {
 	csrf := c.Get("csrf")
 	ctx := context.WithValue(c.Request().Context(), "X-CSRF-Token", csrf)
+	ctx = layout.WithBasePath(ctx, basePath(c))
+	if user, ok := c.Get(userKey).(*model.User); ok {
+		ctx = layout.WithUser(ctx, user)
+	}
 	return component.Render(ctx, c.Response())
 }
 
+// isHtmxFragment reports whether the request was made by htmx to swap in a part
+// of the page, as opposed to a boosted navigation which needs the whole page.
+func isHtmxFragment(c echo.Context) bool {
+	h := c.Request().Header
+	return h.Get("HX-Request") == "true" && h.Get("HX-Boosted") != "true"
+}
+
+// HxRedirect redirects the browser to path. Paths starting with a slash are
+// relative to the base path.
 func HxRedirect(c echo.Context, path string) error {
-	c.Response().Header().Set("HX-Redirect", path)
+	c.Response().Header().Set("HX-Redirect", withBasePath(c, path))
 	return c.NoContent(http.StatusOK)
 }
@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo-contrib/session"
+	"github.com/labstack/echo/v4"
+)
+
+// newTestHandler returns a handler on a fresh database with the default
+// configuration.
+func newTestHandler(t *testing.T) (*Handler, *database.DB) {
+	t.Helper()
+	db := dbtest.New(t)
+	return NewHandler(db, config.Default()), db
+}
+
+// formRequest returns an htmx request with the form values as body.
+func formRequest(method, target string, values url.Values) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(values.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	req.Header.Set("HX-Request", "true")
+	return req
+}
+
+// jsonRequest returns an API request with body as JSON.
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	return req
+}
+
+// testSessionSecret signs the session cookies of serve.
+const testSessionSecret = "test-session-secret"
+
+// serve runs fn for the request as user, or signed out if user is nil, and
+// returns the response. The path parameters are given as name and value
+// pairs. Returned errors are written the way the server does.
+func serve(t *testing.T, fn echo.HandlerFunc, user *model.User, req *http.Request, params ...string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	c := e.NewContext(req, rec)
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	// the token the CSRF middleware leaves for the templates
+	c.Set("csrf", "test-csrf-token")
+	if user != nil {
+		c.Set(userKey, user)
+	}
+
+	store := sessions.NewCookieStore([]byte(testSessionSecret))
+	if err := session.Middleware(store)(fn)(c); err != nil {
+		e.HTTPErrorHandler(err, c)
+	}
+	return rec
+}
+
+// startSession starts a session of user like a login does and returns its
+// cookies. With secondFactor unset, a user with TOTP still has to enter a
+// code.
+func startSession(t *testing.T, h *Handler, user *model.User, secondFactor bool) []*http.Cookie {
+	t.Helper()
+	rec := serve(t, func(c echo.Context) error {
+		return h.createSession(c, user.ID, secondFactor)
+	}, nil, httptest.NewRequest(http.MethodPost, "/login", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("start session: status = %d:\n%s", rec.Code, rec.Body)
+	}
+	return rec.Result().Cookies()
+}
+
+// withCookies adds the cookies to req and returns it.
+func withCookies(req *http.Request, cookies []*http.Cookie) *http.Request {
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	return req
+}
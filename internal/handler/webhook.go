@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"xiazki/internal/model"
+	"xiazki/internal/webhook"
+	"xiazki/web/template/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+// webhookDeliveryLimit is how many deliveries the delivery log shows.
+const webhookDeliveryLimit = 100
+
+func (h *Handler) GetWebhooks(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	hooks, err := h.db.UserWebhooks(c.Request().Context(), user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to fetch webhooks: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhooks")
+	}
+
+	var values webhooks.WebhookFormValues
+	for _, e := range model.WebhookEvents {
+		values.Events = append(values.Events, string(e))
+	}
+	return Render(c, webhooks.List(webhooks.ListData{Webhooks: hooks, Values: values}))
+}
+
+// PostWebhook adds a webhook, whose secret is shown once.
+func (h *Handler) PostWebhook(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var wfv webhooks.WebhookFormValues
+	if err := c.Bind(&wfv); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid form data")
+	}
+
+	ctx := c.Request().Context()
+	hooks, err := h.db.UserWebhooks(ctx, user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to fetch webhooks: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhooks")
+	}
+
+	if errors := wfv.Validate(); len(errors) > 0 {
+		return Render(c, webhooks.Webhooks(webhooks.ListData{Webhooks: hooks, Values: wfv, Errors: errors}))
+	}
+
+	hook, err := model.NewWebhook(user.ID, strings.TrimSpace(wfv.URL), wfv.EventList())
+	if err != nil {
+		c.Logger().Error("Failed to generate webhook secret: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add webhook")
+	}
+	if err := h.db.InsertWebhook(ctx, hook); err != nil {
+		c.Logger().Error("Failed to add webhook: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add webhook")
+	}
+
+	return Render(c, webhooks.Webhooks(webhooks.ListData{
+		Webhooks: append([]*model.Webhook{hook}, hooks...),
+		Secret:   hook.Secret,
+		Values:   webhooks.WebhookFormValues{Events: wfv.Events},
+	}))
+}
+
+func (h *Handler) DeleteWebhook(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
+	}
+
+	ctx := c.Request().Context()
+	if err := h.db.DeleteWebhook(ctx, user.ID, id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to delete webhook: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete webhook")
+	}
+
+	hooks, err := h.db.UserWebhooks(ctx, user.ID)
+	if err != nil {
+		c.Logger().Error("Failed to fetch webhooks: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhooks")
+	}
+	return Render(c, webhooks.Webhooks(webhooks.ListData{Webhooks: hooks}))
+}
+
+// GetWebhook shows the delivery log of a webhook.
+func (h *Handler) GetWebhook(c echo.Context) error {
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	deliveries, err := h.db.WebhookDeliveries(c.Request().Context(), hook.ID, webhookDeliveryLimit)
+	if err != nil {
+		c.Logger().Error("Failed to fetch webhook deliveries: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch deliveries")
+	}
+	return Render(c, webhooks.Show(webhooks.Data{Webhook: hook, Deliveries: deliveries}))
+}
+
+// PostWebhookPing queues a ping to try a webhook.
+func (h *Handler) PostWebhookPing(c echo.Context) error {
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	payload, err := webhook.PingPayload(user.Username)
+	if err != nil {
+		c.Logger().Error("Failed to build webhook ping: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send ping")
+	}
+	if err := h.db.PingWebhook(c.Request().Context(), hook, payload); err != nil {
+		c.Logger().Error("Failed to queue webhook ping: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to send ping")
+	}
+	return HxRedirect(c, "/webhooks/"+strconv.FormatInt(hook.ID, 10))
+}
+
+// PostWebhookRetry queues a delivery which failed or was delivered again.
+func (h *Handler) PostWebhookRetry(c echo.Context) error {
+	hook, err := h.userWebhook(c)
+	if err != nil {
+		return err
+	}
+
+	id, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid delivery ID")
+	}
+
+	if err := h.db.RetryWebhookDelivery(c.Request().Context(), hook.ID, id); errors.Is(err, sql.ErrNoRows) {
+		return echo.NewHTTPError(http.StatusNotFound, "Delivery not found or still pending")
+	} else if err != nil {
+		c.Logger().Error("Failed to retry webhook delivery: ", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to retry delivery")
+	}
+	return HxRedirect(c, "/webhooks/"+strconv.FormatInt(hook.ID, 10))
+}
+
+// userWebhook returns the webhook of the id parameter if it belongs to the
+// current user.
+func (h *Handler) userWebhook(c echo.Context) (*model.Webhook, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
+	}
+
+	hook, err := h.db.UserWebhook(c.Request().Context(), user.ID, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+	} else if err != nil {
+		c.Logger().Error("Failed to fetch webhook: ", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	}
+	return hook, nil
+}
@@ -0,0 +1,239 @@
+package importer
+
+// https://www.goodreads.com/review/import
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"xiazki/internal/model"
+	"xiazki/web/template/add_book"
+)
+
+const (
+	goodreadsShelfRead    = "read"
+	goodreadsShelfReading = "currently-reading"
+	goodreadsShelfToRead  = "to-read"
+)
+
+// goodreadsSeries matches titles like "Dune Messiah (Dune Chronicles, #2)".
+var goodreadsSeries = regexp.MustCompile(`^(.+?)\s*\(([^()]+),\s*#(\d+)[^()]*\)$`)
+
+func ParseGoodreads(r io.Reader) ([]*Record, error) {
+	_, rows, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, goodreadsRecord(row))
+	}
+	return records, nil
+}
+
+func goodreadsRecord(row csvRow) *Record {
+	record := &Record{Line: row.line}
+
+	title := row.get("Title")
+	bfv := add_book.BookFormValues{
+		Title:     title,
+		Authors:   joinNonEmpty(row.get("Author"), row.get("Additional Authors")),
+		ISBN10:    goodreadsISBN(row.get("ISBN")),
+		ISBN13:    goodreadsISBN(row.get("ISBN13")),
+		Publisher: row.get("Publisher"),
+		PageCount: row.get("Number of Pages"),
+	}
+	if m := goodreadsSeries.FindStringSubmatch(title); m != nil {
+		bfv.Title, bfv.SeriesName, bfv.SeriesNumber = m[1], m[2], m[3]
+	}
+	if year := firstNonEmpty(row.get("Original Publication Year"), row.get("Year Published")); year != "" {
+		bfv.PublishDate = year + "-01-01"
+	}
+
+	shelf := row.get("Exclusive Shelf")
+	var tags []string
+	for tag := range strings.SplitSeq(row.get("Bookshelves"), ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" && tag != shelf && !isGoodreadsShelf(tag) {
+			tags = append(tags, tag)
+		}
+	}
+	bfv.Tags = strings.Join(tags, ", ")
+
+	if err := validate(bfv); err != nil {
+		record.Err = err
+		return record
+	}
+	record.Book = bfv.ToBook()
+
+	rating, err := parseOptionalInt(row.get("My Rating"))
+	if err != nil || rating < 0 || rating > 5 {
+		record.Err = fmt.Errorf("invalid rating: %q", row.get("My Rating"))
+		return record
+	}
+	opinion := goodreadsReview(row.get("My Review"))
+	if rating > 0 || opinion != "" {
+		record.Review = &model.Review{Rating: rating * 2, Opinion: opinion}
+	}
+
+	dateRead, err := parseOptionalDate(row.get("Date Read"))
+	if err != nil {
+		record.Err = fmt.Errorf("invalid read date: %w", err)
+		return record
+	}
+	dateAdded, err := parseOptionalDate(row.get("Date Added"))
+	if err != nil {
+		record.Err = fmt.Errorf("invalid added date: %w", err)
+		return record
+	}
+
+	switch shelf {
+	case goodreadsShelfRead:
+		date := dateRead
+		if date.IsZero() {
+			date = dateAdded
+		}
+		if date.IsZero() {
+			record.Err = errors.New("book is on the read shelf but has no read date")
+			return record
+		}
+		record.Events = append(record.Events, &model.Event{Type: model.EventFinished, Date: date})
+	case goodreadsShelfReading:
+		if dateAdded.IsZero() {
+			dateAdded = time.Now()
+		}
+		record.Events = append(record.Events, &model.Event{Type: model.EventReading, Date: dateAdded})
+	case goodreadsShelfToRead:
+		if dateAdded.IsZero() {
+			dateAdded = time.Now()
+		}
+		record.Events = append(record.Events, &model.Event{Type: model.EventToRead, Date: dateAdded})
+	}
+
+	return record
+}
+
+func isGoodreadsShelf(shelf string) bool {
+	return shelf == goodreadsShelfRead || shelf == goodreadsShelfReading || shelf == goodreadsShelfToRead
+}
+
+// goodreadsISBN strips the spreadsheet formula goodreads wraps ISBNs in, e.g.
+// `="0441172717"`.
+func goodreadsISBN(isbn string) string {
+	return strings.Trim(isbn, `="`)
+}
+
+func goodreadsReview(review string) string {
+	review = strings.NewReplacer("<br/>", "\n", "<br />", "\n", "<br>", "\n").Replace(review)
+	return strings.TrimSpace(review)
+}
+
+// csvRow is a single CSV record with its columns accessible by header name.
+type csvRow struct {
+	line   int
+	header map[string]int
+	fields []string
+}
+
+func (r csvRow) get(column string) string {
+	if i, ok := r.header[column]; ok && i < len(r.fields) {
+		return strings.TrimSpace(r.fields[i])
+	}
+	return ""
+}
+
+// readCSV reads a CSV file with a header, it returns the trimmed column names
+// in their order and the records.
+func readCSV(r io.Reader) ([]string, []csvRow, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	names, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("read header: %w", err)
+	}
+	header := make(map[string]int, len(names))
+	for i, name := range names {
+		names[i] = strings.TrimSpace(strings.TrimPrefix(name, "\ufeff"))
+		header[names[i]] = i
+	}
+
+	var rows []csvRow
+	for {
+		fields, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("read csv: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		rows = append(rows, csvRow{line: line, header: header, fields: fields})
+	}
+	return names, rows, nil
+}
+
+// validate runs the same validation as the add book form and turns the
+// resulting field errors into a single error.
+func validate(bfv add_book.BookFormValues) error {
+	errs := bfv.Validate()
+	if len(errs) == 0 {
+		return nil
+	}
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	slices.Sort(fields)
+	messages := make([]string, len(fields))
+	for i, field := range fields {
+		messages[i] = errs[field]
+	}
+	return errors.New(strings.Join(messages, "; "))
+}
+
+func parseOptionalInt(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+func parseOptionalDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006/01/02", "2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized date: %q", s)
+}
+
+func joinNonEmpty(values ...string) string {
+	var parts []string
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
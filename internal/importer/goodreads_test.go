@@ -0,0 +1,90 @@
+package importer
+
+import (
+	"strings"
+	"testing"
+
+	"xiazki/internal/model"
+)
+
+const goodreadsCSV = `Book Id,Title,Author,Additional Authors,ISBN,ISBN13,My Rating,Publisher,Number of Pages,Year Published,Original Publication Year,Date Read,Date Added,Bookshelves,Exclusive Shelf,My Review
+1,"Dune Messiah (Dune Chronicles, #2)",Frank Herbert,,"=""0441172695""","=""9780441172696""",4,Ace,256,1987,1969,2024/02/03,2024/01/01,"sf, read",read,Better<br/>than<br />I thought
+2,Emma,Jane Austen,,,,0,,,,,,2024/03/04,to-read,to-read,
+3,Good Omens,Terry Pratchett,Neil Gaiman,,,5,,,,,,2024/05/06,currently-reading,currently-reading,
+4,,Nobody,,,,0,,,,,,,,,
+5,Dracula,Bram Stoker,,,,6,,,,,,,,,
+6,Ulysses,James Joyce,,,,0,,,,,,2024/01/01,read,read,
+7,Walden,Henry David Thoreau,,,,0,,,,,yesterday,2024/01/01,read,read,
+`
+
+func TestParseGoodreads(t *testing.T) {
+	records, err := ParseGoodreads(strings.NewReader(goodreadsCSV))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 7 {
+		t.Fatalf("got %d records, want 7", len(records))
+	}
+
+	dune := records[0]
+	if dune.Err != nil {
+		t.Fatal(dune.Err)
+	}
+	b := dune.Book
+	if b.Title != "Dune Messiah" || b.SeriesName != "Dune Chronicles" || b.SeriesNumber != 2 {
+		t.Errorf("title = %q of %q #%d, want Dune Messiah of Dune Chronicles #2", b.Title, b.SeriesName, b.SeriesNumber)
+	}
+	if b.ISBN10 != "0441172695" || b.ISBN13 != "9780441172696" {
+		t.Errorf("ISBNs = %q, %q, want them without the formula", b.ISBN10, b.ISBN13)
+	}
+	if b.PublishDate.Year() != 1969 {
+		t.Errorf("published in %d, want the original 1969", b.PublishDate.Year())
+	}
+	if len(b.Tags) != 1 || b.Tags[0].Name != "sf" {
+		t.Errorf("tags = %v, want only sf", b.Tags)
+	}
+	// ratings out of 5 are doubled and line breaks restored
+	if r := dune.Review; r == nil || r.Rating != 8 || r.Opinion != "Better\nthan\nI thought" {
+		t.Errorf("review = %+v, want rating 8 with line breaks", r)
+	}
+
+	for _, tt := range []struct {
+		line int
+		want model.EventType
+		date string
+	}{
+		{0, model.EventFinished, "2024-02-03"},
+		{1, model.EventToRead, "2024-03-04"},
+		{2, model.EventReading, "2024-05-06"},
+		// without a read date, the date it was added stands in
+		{5, model.EventFinished, "2024-01-01"},
+	} {
+		r := records[tt.line]
+		if r.Err != nil {
+			t.Errorf("line %d: %v", r.Line, r.Err)
+			continue
+		}
+		if len(r.Events) != 1 || r.Events[0].Type != tt.want || r.Events[0].Date.Format("2006-01-02") != tt.date {
+			t.Errorf("line %d: events = %v, want %s on %s", r.Line, r.Events, tt.want, tt.date)
+		}
+	}
+	if r := records[1].Review; r != nil {
+		t.Errorf("review of an unrated book = %+v, want none", r)
+	}
+	if a := records[2].Book.Authors; len(a) != 2 || a[1].Name != "Neil Gaiman" {
+		t.Errorf("authors = %v, want the additional author too", a)
+	}
+
+	// no title, a rating out of 5 and a date which is none
+	for _, i := range []int{3, 4, 6} {
+		if records[i].Err == nil {
+			t.Errorf("line %d: no error for a malformed row", records[i].Line)
+		}
+	}
+}
+
+func TestParseGoodreadsEmpty(t *testing.T) {
+	if _, err := ParseGoodreads(strings.NewReader("")); err == nil {
+		t.Error("no error for a file without a header")
+	}
+}
@@ -0,0 +1,165 @@
+package importer
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+
+	"github.com/uptrace/bun"
+)
+
+// Record is a single entry of an import file mapped onto the data model.
+// Records which could not be parsed have Err set and are never imported.
+type Record struct {
+	Line   int
+	Book   *model.Book
+	Review *model.Review
+	Events []*model.Event
+	Quotes []*model.Quote
+	Err    error
+}
+
+type Status string
+
+const (
+	StatusNew      Status = "new"
+	StatusExisting Status = "existing"
+	StatusFailed   Status = "failed"
+)
+
+type Result struct {
+	Record *Record
+	Status Status
+	Err    error
+}
+
+type Parser func(io.Reader) ([]*Record, error)
+
+// Parsers lists the supported import sources by name.
+var Parsers = map[string]Parser{
+	"goodreads": ParseGoodreads,
+	"jelu":      ParseJelu,
+	"xiazki":    ParseXiazki,
+}
+
+// Sources lists both the fixed import sources and the generic formats which
+// need a column mapping.
+func Sources() []string {
+	sources := make([]string, 0, len(Parsers)+len(tableReaders))
+	for name := range Parsers {
+		sources = append(sources, name)
+	}
+	for name := range tableReaders {
+		sources = append(sources, name)
+	}
+	slices.Sort(sources)
+	return sources
+}
+
+func Parse(source string, r io.Reader) ([]*Record, error) {
+	parse, ok := Parsers[source]
+	if !ok {
+		return nil, fmt.Errorf("unknown import source: %s", source)
+	}
+	return parse(r)
+}
+
+// Import stores the records for the given user and adds the books to their
+// library. Books already in the catalog are reused rather than duplicated.
+// With dryRun set nothing is written and the results only describe what would
+// happen. Imports are not posted to webhooks.
+func Import(ctx context.Context, db *database.DB, user *model.User, records []*Record, dryRun bool) []Result {
+	ctx = database.WithoutWebhooks(ctx)
+	results := make([]Result, 0, len(records))
+	for _, record := range records {
+		result := Result{Record: record}
+		if record.Err != nil {
+			result.Status, result.Err = StatusFailed, record.Err
+		} else if status, err := importRecord(ctx, db, user, record, dryRun); err != nil {
+			result.Status, result.Err = StatusFailed, err
+		} else {
+			result.Status = status
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// importRecord stores a record in a transaction of its own, so that a record
+// failing halfway leaves nothing behind.
+func importRecord(ctx context.Context, db *database.DB, user *model.User, record *Record, dryRun bool) (Status, error) {
+	status := StatusExisting
+	book, err := db.FindDuplicateBook(ctx, record.Book)
+	if errors.Is(err, sql.ErrNoRows) {
+		status, book = StatusNew, record.Book
+	} else if err != nil {
+		return StatusFailed, fmt.Errorf("find book: %w", err)
+	}
+
+	if dryRun {
+		return status, nil
+	}
+
+	err = db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if status == StatusNew {
+			book.AddedByID = user.ID
+			if err := db.InsertBook(ctx, book); err != nil {
+				return fmt.Errorf("insert book: %w", err)
+			}
+		}
+		if err := db.AddLibraryBook(ctx, user.ID, book.ID); err != nil {
+			return fmt.Errorf("add book to library: %w", err)
+		}
+
+		if review := record.Review; review != nil {
+			review.UserID = user.ID
+			review.BookID = book.ID
+			if err := db.InsertOrUpdateReview(ctx, review, review.Rating > 0, review.Opinion != ""); err != nil {
+				return fmt.Errorf("insert review: %w", err)
+			}
+		}
+
+		for _, event := range record.Events {
+			if err := db.InsertEvent(ctx, book, user, event); err != nil {
+				return fmt.Errorf("insert %s event: %w", event.Type, err)
+			}
+		}
+
+		for _, quote := range record.Quotes {
+			quote.UserID = user.ID
+			quote.BookID = book.ID
+			exists, err := tx.NewSelect().
+				Model((*model.Quote)(nil)).
+				Where("user_id = ? AND book_id = ? AND quote = ?", quote.UserID, quote.BookID, quote.Quote).
+				Exists(ctx)
+			if err != nil {
+				return fmt.Errorf("find quote: %w", err)
+			} else if exists {
+				continue
+			}
+			if err := db.InsertQuote(ctx, quote); err != nil {
+				return fmt.Errorf("insert quote: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return StatusFailed, err
+	}
+	return status, nil
+}
+
+// Summary counts the results by status.
+func Summary(results []Result) map[Status]int {
+	summary := make(map[Status]int)
+	for _, result := range results {
+		summary[result.Status]++
+	}
+	return summary
+}
@@ -0,0 +1,158 @@
+package importer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/exporter"
+	"xiazki/internal/model"
+)
+
+func date(s string) time.Time {
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// export exports the library of user in format.
+func export(t *testing.T, db *database.DB, user *model.User, format exporter.Format) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := exporter.Export(context.Background(), db, user.ID, format, &buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// exportBooks exports the library of user as JSON and returns its books.
+func exportBooks(t *testing.T, db *database.DB, user *model.User) []*exporter.Book {
+	t.Helper()
+	var library exporter.Library
+	if err := json.Unmarshal(export(t, db, user, exporter.FormatJSON), &library); err != nil {
+		t.Fatal(err)
+	}
+	return library.Books
+}
+
+// importAll imports records for user and fails the test unless all of them
+// are new.
+func importAll(t *testing.T, db *database.DB, user *model.User, records []*Record) {
+	t.Helper()
+	for _, result := range Import(context.Background(), db, user, records, false) {
+		if result.Status != StatusNew {
+			t.Fatalf("line %d: %s: %v", result.Record.Line, result.Status, result.Err)
+		}
+	}
+}
+
+func TestExportRoundTrip(t *testing.T) {
+	db := dbtest.New(t)
+	alice := dbtest.User(t, db, "alice", model.RoleUser)
+	importAll(t, db, alice, []*Record{
+		{Line: 1, Book: &model.Book{
+			Title:        "Dune",
+			Authors:      []*model.Author{{Name: "Frank Herbert"}},
+			Tags:         []*model.Tag{{Name: "sf"}, {Name: "classic"}},
+			Summary:      "Spice, sand\nand worms",
+			ISBN13:       "9780441172719",
+			Publisher:    "Ace",
+			PublishDate:  date("1965-08-01"),
+			PageCount:    412,
+			SeriesName:   "Dune",
+			SeriesNumber: 1,
+		},
+			Review: &model.Review{Rating: 8, Opinion: "Long, \"but\"\ngood"},
+			Events: []*model.Event{
+				{Type: model.EventReading, Date: date("2024-01-02")},
+				{Type: model.EventFinished, Date: date("2024-02-03")},
+			},
+			Quotes: []*model.Quote{
+				{Quote: "Fear is the mind-killer.", Page: 8, Note: "litany"},
+				{Quote: "The spice must flow."},
+			},
+		},
+		{Line: 2, Book: &model.Book{
+			Title:       "Good Omens",
+			Authors:     []*model.Author{{Name: "Terry Pratchett"}, {Name: "Neil Gaiman"}},
+			Translators: []*model.Translator{{Name: "Someone"}},
+			Language:    "en",
+		},
+			Events: []*model.Event{{Type: model.EventReading, Date: date("2024-03-04")}},
+		},
+	})
+	want := exportBooks(t, db, alice)
+	if len(want) != 2 || len(want[0].Events) != 2 || len(want[0].Quotes) != 2 || want[0].Review == nil {
+		t.Fatalf("exported %d books, want both with their events, review and quotes", len(want))
+	}
+
+	for _, tt := range []struct {
+		format exporter.Format
+		parse  func(data []byte) ([]*Record, error)
+	}{
+		{exporter.FormatJSON, func(data []byte) ([]*Record, error) {
+			return Parse("xiazki", bytes.NewReader(data))
+		}},
+		{exporter.FormatCSV, func(data []byte) ([]*Record, error) {
+			table, err := ReadTable("csv", bytes.NewReader(data))
+			if err != nil {
+				return nil, err
+			}
+			return table.Records(DefaultMapping(table.Columns)), nil
+		}},
+	} {
+		t.Run(string(tt.format), func(t *testing.T) {
+			records, err := tt.parse(export(t, db, alice, tt.format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			other := dbtest.New(t)
+			bob := dbtest.User(t, other, "bob", model.RoleUser)
+			importAll(t, other, bob, records)
+
+			got := exportBooks(t, other, bob)
+			if !reflect.DeepEqual(got, want) {
+				gotJSON, _ := json.MarshalIndent(got, "", "  ")
+				wantJSON, _ := json.MarshalIndent(want, "", "  ")
+				t.Errorf("imported books:\n%s\nwant:\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestImportRollsBackFailedRecord(t *testing.T) {
+	db := dbtest.New(t)
+	alice := dbtest.User(t, db, "alice", model.RoleUser)
+	ctx := context.Background()
+
+	// finishing before a later start conflicts, after the book, the review
+	// and the first event were written
+	results := Import(ctx, db, alice, []*Record{{Line: 1,
+		Book:   &model.Book{Title: "Dune", Authors: []*model.Author{{Name: "Frank Herbert"}}},
+		Review: &model.Review{Rating: 8},
+		Events: []*model.Event{
+			{Type: model.EventReading, Date: date("2024-02-01")},
+			{Type: model.EventFinished, Date: date("2024-01-01")},
+		},
+		Quotes: []*model.Quote{{Quote: "Fear is the mind-killer."}},
+	}}, false)
+	if len(results) != 1 || results[0].Status != StatusFailed {
+		t.Fatalf("results = %+v, want a failed record", results)
+	}
+
+	for _, table := range []any{
+		(*model.Book)(nil), (*model.UserBook)(nil), (*model.Review)(nil),
+		(*model.Event)(nil), (*model.Quote)(nil), (*model.BookAuthor)(nil),
+	} {
+		if n, err := db.NewSelect().Model(table).Count(ctx); err != nil || n != 0 {
+			t.Errorf("%T: %d rows, %v, want none", table, n, err)
+		}
+	}
+}
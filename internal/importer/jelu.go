@@ -0,0 +1,116 @@
+package importer
+
+// https://github.com/bayang/jelu
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"xiazki/internal/model"
+	"xiazki/web/template/add_book"
+)
+
+// jelu reading event types as found in the last_reading_event column.
+const (
+	jeluEventReading  = "CURRENTLY_READING"
+	jeluEventFinished = "FINISHED"
+	jeluEventDropped  = "DROPPED"
+)
+
+// ParseJelu reads the CSV export of jelu. Finished reads are listed in the
+// read_dates column, the last_reading_event columns describe the current
+// state of the book.
+func ParseJelu(r io.Reader) ([]*Record, error) {
+	_, rows, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]*Record, 0, len(rows))
+	for _, row := range rows {
+		records = append(records, jeluRecord(row))
+	}
+	return records, nil
+}
+
+func jeluRecord(row csvRow) *Record {
+	record := &Record{Line: row.line}
+
+	bfv := add_book.BookFormValues{
+		Title:        row.get("title"),
+		Authors:      row.get("authors"),
+		Tags:         row.get("tags"),
+		Translators:  row.get("translators"),
+		Narrators:    row.get("narrators"),
+		Summary:      row.get("summary"),
+		ISBN10:       row.get("isbn10"),
+		ISBN13:       row.get("isbn13"),
+		Language:     row.get("language"),
+		Publisher:    row.get("publisher"),
+		PageCount:    row.get("page_count"),
+		SeriesName:   row.get("series"),
+		SeriesNumber: row.get("number_in_series"),
+		CoverURL:     row.get("image"),
+	}
+	if date, err := parseOptionalDate(row.get("published_date")); err == nil && !date.IsZero() {
+		bfv.PublishDate = date.Format("2006-01-02")
+	}
+	// jelu stores series numbers as decimals, e.g. "2.0"
+	bfv.SeriesNumber, _, _ = strings.Cut(bfv.SeriesNumber, ".")
+
+	if err := validate(bfv); err != nil {
+		record.Err = err
+		return record
+	}
+	record.Book = bfv.ToBook()
+
+	rating, err := mappedRating(row.get("rating"), 10)
+	if err != nil {
+		record.Err = err
+		return record
+	}
+	if opinion := row.get("personal_notes"); rating > 0 || opinion != "" {
+		record.Review = &model.Review{Rating: rating, Opinion: opinion}
+	}
+
+	var finished time.Time
+	for date := range strings.SplitSeq(row.get("read_dates"), ",") {
+		read, err := parseOptionalDate(strings.TrimSpace(date))
+		if err != nil {
+			record.Err = fmt.Errorf("invalid read date: %w", err)
+			return record
+		} else if read.After(finished) {
+			finished = read
+		}
+	}
+
+	last, err := parseOptionalDate(row.get("last_reading_event_date"))
+	if err != nil {
+		record.Err = fmt.Errorf("invalid reading event date: %w", err)
+		return record
+	}
+
+	// Only the current state can be kept, as a book is either being read or
+	// has been finished or dropped.
+	switch row.get("last_reading_event") {
+	case jeluEventReading:
+		if !last.IsZero() {
+			record.Events = append(record.Events, &model.Event{Type: model.EventReading, Date: last})
+		}
+	case jeluEventDropped:
+		if !last.IsZero() {
+			record.Events = append(record.Events, &model.Event{Type: model.EventDropped, Date: last})
+		}
+	default:
+		if last.After(finished) && row.get("last_reading_event") == jeluEventFinished {
+			finished = last
+		}
+		if !finished.IsZero() {
+			record.Events = append(record.Events, &model.Event{Type: model.EventFinished, Date: finished})
+		}
+	}
+
+	return record
+}
@@ -0,0 +1,320 @@
+package importer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"xiazki/internal/exporter"
+	"xiazki/internal/model"
+	"xiazki/web/template/add_book"
+)
+
+// Field is a target the columns of a generic import file can be mapped onto.
+type Field struct {
+	Name  string
+	Label string
+}
+
+const (
+	fieldRating   = "rating"
+	fieldRating5  = "rating_5"
+	fieldReview   = "review"
+	fieldStarted  = "date_started"
+	fieldFinished = "date_finished"
+	fieldQuotes   = "quotes"
+)
+
+// Fields lists the book form fields followed by the fields which end up in
+// the reviews, events and quotes tables.
+var Fields = append(bookFormFields(),
+	Field{fieldRating, "Rating (1-10)"},
+	Field{fieldRating5, "Rating (1-5)"},
+	Field{fieldReview, "Review"},
+	Field{fieldStarted, "Date started"},
+	Field{fieldFinished, "Date finished"},
+	Field{fieldQuotes, "Quotes"},
+)
+
+func bookFormFields() []Field {
+	t := reflect.TypeFor[add_book.BookFormValues]()
+	fields := make([]Field, 0, t.NumField())
+	for i := range t.NumField() {
+		name := t.Field(i).Tag.Get("form")
+		fields = append(fields, Field{Name: name, Label: splitCamelCase(t.Field(i).Name)})
+	}
+	return fields
+}
+
+// splitCamelCase turns "PageCount" into "Page Count".
+func splitCamelCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if i > 0 && unicode.IsUpper(r) && unicode.IsLower(rune(s[i-1])) {
+			b.WriteByte(' ')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// Mapping maps field names onto the columns of an import file.
+type Mapping map[string]string
+
+// Table is a generic import file whose columns still have to be mapped onto
+// fields by the user.
+type Table struct {
+	Columns []string
+	Rows    []TableRow
+}
+
+type TableRow struct {
+	Line   int
+	Values map[string]string
+}
+
+type tableReader func(io.Reader) (*Table, error)
+
+// tableReaders lists the generic import formats by name.
+var tableReaders = map[string]tableReader{
+	"csv":  readCSVTable,
+	"json": readJSONTable,
+}
+
+func IsMapped(source string) bool {
+	_, ok := tableReaders[source]
+	return ok
+}
+
+func ReadTable(source string, r io.Reader) (*Table, error) {
+	read, ok := tableReaders[source]
+	if !ok {
+		return nil, fmt.Errorf("unknown import format: %s", source)
+	}
+	return read(r)
+}
+
+func readCSVTable(r io.Reader) (*Table, error) {
+	names, rows, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// columns are mapped by name, so unnamed columns are left out and names
+	// must be unique
+	table := &Table{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		} else if slices.Contains(table.Columns, name) {
+			return nil, fmt.Errorf("column %q appears more than once", name)
+		}
+		table.Columns = append(table.Columns, name)
+	}
+	for _, row := range rows {
+		values := make(map[string]string, len(table.Columns))
+		for _, column := range table.Columns {
+			values[column] = row.get(column)
+		}
+		table.Rows = append(table.Rows, TableRow{Line: row.line, Values: values})
+	}
+	return table, nil
+}
+
+// readJSONTable reads an array of objects, optionally wrapped in an object
+// under the "books" key. Nested arrays are joined with commas.
+func readJSONTable(r io.Reader) (*Table, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []map[string]any
+	if err := json.Unmarshal(data, &entries); err != nil {
+		var wrapped struct {
+			Books []map[string]any `json:"books"`
+		}
+		if err := json.Unmarshal(data, &wrapped); err != nil {
+			return nil, fmt.Errorf("read json: %w", err)
+		}
+		entries = wrapped.Books
+	}
+
+	table := &Table{}
+	for i, entry := range entries {
+		values := make(map[string]string, len(entry))
+		for key, value := range entry {
+			if !slices.Contains(table.Columns, key) {
+				table.Columns = append(table.Columns, key)
+			}
+			values[key] = jsonString(value)
+		}
+		table.Rows = append(table.Rows, TableRow{Line: i + 1, Values: values})
+	}
+	slices.Sort(table.Columns)
+	return table, nil
+}
+
+func jsonString(value any) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			if s := jsonString(item); s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, ", ")
+	default:
+		return ""
+	}
+}
+
+// DefaultMapping maps fields onto columns with a matching name, ignoring case,
+// spaces and punctuation.
+func DefaultMapping(columns []string) Mapping {
+	mapping := make(Mapping)
+	for _, field := range Fields {
+		for _, column := range columns {
+			if normalizeColumn(column) == normalizeColumn(field.Name) || normalizeColumn(column) == normalizeColumn(field.Label) {
+				mapping[field.Name] = column
+				break
+			}
+		}
+	}
+	return mapping
+}
+
+func normalizeColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, strings.ToLower(name))
+}
+
+func (t *Table) Records(mapping Mapping) []*Record {
+	records := make([]*Record, 0, len(t.Rows))
+	for _, row := range t.Rows {
+		records = append(records, mappedRecord(row, mapping))
+	}
+	return records
+}
+
+func mappedRecord(row TableRow, mapping Mapping) *Record {
+	record := &Record{Line: row.Line}
+	get := func(field string) string {
+		if column := mapping[field]; column != "" {
+			return row.Values[column]
+		}
+		return ""
+	}
+
+	var bfv add_book.BookFormValues
+	v := reflect.ValueOf(&bfv).Elem()
+	for i := range v.NumField() {
+		v.Field(i).SetString(get(v.Type().Field(i).Tag.Get("form")))
+	}
+	if date, err := parseOptionalDate(bfv.PublishDate); err == nil && !date.IsZero() {
+		bfv.PublishDate = date.Format("2006-01-02")
+	}
+
+	if err := validate(bfv); err != nil {
+		record.Err = err
+		return record
+	}
+	record.Book = bfv.ToBook()
+
+	rating, err := mappedRating(get(fieldRating), 10)
+	if err != nil {
+		record.Err = err
+		return record
+	}
+	if rating == 0 {
+		if rating, err = mappedRating(get(fieldRating5), 5); err != nil {
+			record.Err = err
+			return record
+		}
+	}
+	if opinion := get(fieldReview); rating > 0 || opinion != "" {
+		record.Review = &model.Review{Rating: rating, Opinion: opinion}
+	}
+
+	started, err := parseOptionalDate(get(fieldStarted))
+	if err != nil {
+		record.Err = fmt.Errorf("invalid start date: %w", err)
+		return record
+	}
+	finished, err := parseOptionalDate(get(fieldFinished))
+	if err != nil {
+		record.Err = fmt.Errorf("invalid finish date: %w", err)
+		return record
+	}
+	if !started.IsZero() {
+		record.Events = append(record.Events, &model.Event{Type: model.EventReading, Date: started})
+	}
+	if !finished.IsZero() {
+		if !started.IsZero() && finished.Before(started) {
+			record.Err = errors.New("finish date is before start date")
+			return record
+		}
+		record.Events = append(record.Events, &model.Event{Type: model.EventFinished, Date: finished})
+	}
+
+	quotes, err := mappedQuotes(get(fieldQuotes))
+	if err != nil {
+		record.Err = err
+		return record
+	}
+	record.Quotes = quotes
+
+	return record
+}
+
+// mappedQuotes parses the quotes of a book, either a JSON array like the
+// flat CSV export writes or a single quote as plain text.
+func mappedQuotes(s string) ([]*model.Quote, error) {
+	if s == "" {
+		return nil, nil
+	} else if !strings.HasPrefix(s, "[") {
+		return []*model.Quote{{Quote: s}}, nil
+	}
+
+	var list []*exporter.Quote
+	if err := json.Unmarshal([]byte(s), &list); err != nil {
+		return nil, fmt.Errorf("invalid quotes: %w", err)
+	}
+	quotes := make([]*model.Quote, 0, len(list))
+	for _, q := range list {
+		if q.Quote = strings.TrimSpace(q.Quote); q.Quote != "" {
+			quotes = append(quotes, &model.Quote{Quote: q.Quote, Page: q.Page, Note: q.Note})
+		}
+	}
+	return quotes, nil
+}
+
+// mappedRating parses a rating out of scale and rescales it to 1-10.
+func mappedRating(s string, scale float64) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	rating, err := strconv.ParseFloat(s, 64)
+	if err != nil || rating < 0 || rating > scale {
+		return 0, fmt.Errorf("invalid rating: %q", s)
+	}
+	return int64(math.Round(rating * 10 / scale)), nil
+}
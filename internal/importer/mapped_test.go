@@ -0,0 +1,42 @@
+package importer
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestReadCSVTable(t *testing.T) {
+	table, err := readCSVTable(strings.NewReader("\ufeffTitle, Author ,,Pages,\nDune,Frank Herbert,x,412,y\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"Title", "Author", "Pages"}; !slices.Equal(table.Columns, want) {
+		t.Errorf("columns = %q, want %q", table.Columns, want)
+	}
+	if len(table.Rows) != 1 {
+		t.Fatalf("got %d rows, want 1", len(table.Rows))
+	}
+	if got := table.Rows[0].Values["Author"]; got != "Frank Herbert" {
+		t.Errorf("Author = %q, want Frank Herbert", got)
+	}
+}
+
+func TestReadCSVTableOnlyHeader(t *testing.T) {
+	table, err := readCSVTable(strings.NewReader("Title,Author\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"Title", "Author"}; !slices.Equal(table.Columns, want) {
+		t.Errorf("columns = %q, want %q", table.Columns, want)
+	}
+}
+
+func TestReadCSVTableDuplicateColumns(t *testing.T) {
+	for _, header := range []string{"Title,Title", "Title, Title ,Author"} {
+		if _, err := readCSVTable(strings.NewReader(header + "\na,b,c\n")); err == nil {
+			t.Errorf("%q: no error for a repeated column", header)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package importer
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"xiazki/internal/exporter"
+	"xiazki/internal/model"
+	"xiazki/web/template/add_book"
+)
+
+// ParseXiazki reads the JSON export of xiazki itself.
+func ParseXiazki(r io.Reader) ([]*Record, error) {
+	var library exporter.Library
+	if err := json.NewDecoder(r).Decode(&library); err != nil {
+		return nil, fmt.Errorf("read json: %w", err)
+	}
+	if library.Version < 1 || library.Version > exporter.Version {
+		return nil, fmt.Errorf("unsupported export version: %d", library.Version)
+	}
+
+	records := make([]*Record, 0, len(library.Books))
+	for i, book := range library.Books {
+		records = append(records, xiazkiRecord(i+1, book))
+	}
+	return records, nil
+}
+
+func xiazkiRecord(line int, b *exporter.Book) *Record {
+	record := &Record{Line: line}
+
+	book := &model.Book{
+		Title:        strings.TrimSpace(b.Title),
+		Summary:      b.Summary,
+		ISBN10:       b.ISBN10,
+		ISBN13:       b.ISBN13,
+		Language:     b.Language,
+		Publisher:    b.Publisher,
+		PageCount:    b.PageCount,
+		SeriesName:   b.SeriesName,
+		SeriesNumber: b.SeriesNumber,
+		CoverURL:     b.CoverURL,
+	}
+	for _, name := range b.Authors {
+		book.Authors = append(book.Authors, &model.Author{Name: name})
+	}
+	for _, name := range b.Tags {
+		book.Tags = append(book.Tags, &model.Tag{Name: name})
+	}
+	for _, name := range b.Translators {
+		book.Translators = append(book.Translators, &model.Translator{Name: name})
+	}
+	for _, name := range b.Narrators {
+		book.Narrators = append(book.Narrators, &model.Narrator{Name: name})
+	}
+	if b.PublishDate != "" {
+		date, err := time.Parse("2006-01-02", b.PublishDate)
+		if err != nil {
+			record.Err = fmt.Errorf("invalid publish date: %q", b.PublishDate)
+			return record
+		}
+		book.PublishDate = date
+	}
+
+	if err := validate(add_book.BookToBookFormValues(*book)); err != nil {
+		record.Err = err
+		return record
+	}
+	record.Book = book
+
+	if b.Review != nil {
+		if b.Review.Rating < 0 || b.Review.Rating > 10 {
+			record.Err = fmt.Errorf("invalid rating: %d", b.Review.Rating)
+			return record
+		}
+		record.Review = &model.Review{Rating: b.Review.Rating, Opinion: b.Review.Opinion}
+	}
+
+	for _, e := range b.Events {
+		date, err := time.Parse("2006-01-02", e.Date)
+		if err != nil {
+			record.Err = fmt.Errorf("invalid event date: %q", e.Date)
+			return record
+		}
+		record.Events = append(record.Events, &model.Event{Type: e.Type, Date: date})
+	}
+	slices.SortStableFunc(record.Events, func(a, b *model.Event) int {
+		return a.Date.Compare(b.Date)
+	})
+
+	for _, q := range b.Quotes {
+		record.Quotes = append(record.Quotes, &model.Quote{Quote: q.Quote, Page: q.Page, Note: q.Note})
+	}
+
+	return record
+}
@@ -0,0 +1,132 @@
+package login
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+	"xiazki/internal/services/ldap"
+
+	"github.com/google/uuid"
+)
+
+// ldapProvider is the provider of the identities of directory users, their
+// subject is the DN of their entry.
+const ldapProvider = "ldap"
+
+// LDAP binds to a directory as the user. Directory users are linked to xiazki
+// users like the identities of OpenID Connect providers, and get their role
+// from their groups if admin groups are configured.
+type LDAP struct {
+	db  *database.DB
+	cfg config.LDAP
+	dir *ldap.Directory
+}
+
+func NewLDAP(db *database.DB, cfg config.LDAP, timeout time.Duration) *LDAP {
+	return &LDAP{
+		db:  db,
+		cfg: cfg,
+		dir: ldap.NewDirectory(ldap.Config{
+			URL:               cfg.URL,
+			StartTLS:          cfg.StartTLS,
+			BindDN:            cfg.BindDN,
+			BindPassword:      cfg.BindPassword,
+			BaseDN:            cfg.BaseDN,
+			UserFilter:        cfg.UserFilter,
+			UsernameAttribute: cfg.UsernameAttribute,
+			GroupAttribute:    cfg.GroupAttribute,
+		}, timeout),
+	}
+}
+
+func (l *LDAP) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	entry, err := l.dir.Authenticate(username, password)
+	if errors.Is(err, ldap.ErrInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+
+	if len(l.cfg.UserGroups) > 0 && !entry.MemberOf(l.cfg.UserGroups) && !entry.MemberOf(l.cfg.AdminGroups) {
+		return nil, ErrInvalidCredentials
+	}
+
+	user, err := l.db.IdentityUser(ctx, ldapProvider, entry.DN)
+	if errors.Is(err, sql.ErrNoRows) {
+		user, err = l.provisionUser(ctx, entry)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := l.syncRole(ctx, user, entry); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// provisionUser links the entry to the user with the same name or creates a
+// user for it, as configured.
+func (l *LDAP) provisionUser(ctx context.Context, entry *ldap.Entry) (*model.User, error) {
+	if l.cfg.LinkByUsername {
+		var user model.User
+		err := l.db.NewSelect().Model(&user).Where("username = ?", entry.Username).Scan(ctx)
+		if err == nil {
+			return &user, l.db.LinkIdentity(ctx, &model.Identity{
+				UserID:   user.ID,
+				Provider: ldapProvider,
+				Subject:  entry.DN,
+			})
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+	}
+
+	if !l.cfg.AutoProvision {
+		return nil, ErrInvalidCredentials
+	}
+	// the username must fit the rules of the register form and not be taken
+	if n := len(entry.Username); n < 3 || n > 30 {
+		return nil, ErrInvalidCredentials
+	}
+	if taken, err := l.db.NewSelect().Model((*model.User)(nil)).Where("username = ?", entry.Username).Exists(ctx); err != nil {
+		return nil, err
+	} else if taken {
+		return nil, ErrInvalidCredentials
+	}
+
+	user := model.User{ID: uuid.New(), Username: entry.Username, Role: model.RoleUser}
+	if err := l.db.ProvisionUser(ctx, &user, &model.Identity{Provider: ldapProvider, Subject: entry.DN}); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// syncRole gives the user the role of their groups. The last admin keeps
+// their role, so that the instance is not left without one.
+func (l *LDAP) syncRole(ctx context.Context, user *model.User, entry *ldap.Entry) error {
+	if len(l.cfg.AdminGroups) == 0 {
+		return nil
+	}
+
+	role := model.RoleUser
+	if entry.MemberOf(l.cfg.AdminGroups) {
+		role = model.RoleAdmin
+	}
+	if user.Role == role {
+		return nil
+	}
+
+	if err := l.db.SetUserRole(ctx, user.ID, role); errors.Is(err, database.ErrLastAdmin) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	user.Role = role
+	return nil
+}
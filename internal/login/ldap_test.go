@@ -0,0 +1,154 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+	"xiazki/internal/services/ldap/ldaptest"
+)
+
+const (
+	peopleDN  = "ou=people,dc=example,dc=org"
+	readersDN = "cn=readers,ou=groups,dc=example,dc=org"
+	adminsDN  = "cn=admins,ou=groups,dc=example,dc=org"
+)
+
+func person(uid string, groups ...string) ldaptest.Entry {
+	return ldaptest.Entry{
+		DN:         "uid=" + uid + "," + peopleDN,
+		Password:   uid + "-password",
+		Attributes: map[string][]string{"uid": {uid}, "memberOf": groups},
+	}
+}
+
+// newTestLDAP returns the directory backend on a fresh database, the
+// configuration can be changed until the first login.
+func newTestLDAP(t *testing.T, entries ...ldaptest.Entry) (*LDAP, *database.DB, *ldaptest.Server) {
+	t.Helper()
+
+	server := ldaptest.NewServer(t, entries...)
+	cfg := config.Default().Auth.LDAP
+	cfg.URL = server.URL
+	cfg.BaseDN = peopleDN
+	db := dbtest.New(t)
+	l := NewLDAP(db, cfg, 5*time.Second)
+	return l, db, server
+}
+
+// login signs in with the password of the entry and fails the test unless
+// the user is returned.
+func login(t *testing.T, l *LDAP, uid string) *model.User {
+	t.Helper()
+	user, err := l.Authenticate(context.Background(), uid, uid+"-password")
+	if err != nil {
+		t.Fatalf("login as %s: %v", uid, err)
+	}
+	return user
+}
+
+func TestLDAPUserGroups(t *testing.T) {
+	l, _, _ := newTestLDAP(t,
+		person("alice", readersDN),
+		person("bob"),
+		person("carol", adminsDN),
+	)
+	l.cfg.UserGroups = []string{readersDN}
+	l.cfg.AdminGroups = []string{adminsDN}
+	// keep the first login from becoming the admin by provisioning
+	dbtest.User(t, l.db, "root", model.RoleAdmin)
+
+	if user := login(t, l, "alice"); user.Role != model.RoleUser {
+		t.Errorf("alice: role = %s, want user", user.Role)
+	}
+	if _, err := l.Authenticate(context.Background(), "bob", "bob-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("bob outside the user groups: err = %v, want ErrInvalidCredentials", err)
+	}
+	// admins need not be in the user groups as well
+	if user := login(t, l, "carol"); user.Role != model.RoleAdmin {
+		t.Errorf("carol: role = %s, want admin", user.Role)
+	}
+}
+
+func TestLDAPProvisionUser(t *testing.T) {
+	l, db, _ := newTestLDAP(t, person("alice"), person("bob"), person("carol"))
+	ctx := context.Background()
+
+	// the first user of an instance becomes its admin
+	alice := login(t, l, "alice")
+	if alice.Username != "alice" || alice.Role != model.RoleAdmin {
+		t.Errorf("alice = %+v, want the admin", alice)
+	}
+	if again := login(t, l, "alice"); again.ID != alice.ID {
+		t.Errorf("second login returned user %v, want %v", again.ID, alice.ID)
+	}
+	if linked, err := db.IdentityUser(ctx, ldapProvider, "uid=alice,"+peopleDN); err != nil || linked.ID != alice.ID {
+		t.Errorf("identity linked to %v (%v), want alice", linked, err)
+	}
+
+	// a xiazki user of the same name is only linked if configured
+	bob := dbtest.User(t, db, "bob", model.RoleUser)
+	if _, err := l.Authenticate(ctx, "bob", "bob-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("bob taken: err = %v, want ErrInvalidCredentials", err)
+	}
+	l.cfg.LinkByUsername = true
+	if user := login(t, l, "bob"); user.ID != bob.ID {
+		t.Errorf("bob linked to %v, want %v", user.ID, bob.ID)
+	}
+
+	l.cfg.AutoProvision = false
+	if _, err := l.Authenticate(ctx, "carol", "carol-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("carol without provisioning: err = %v, want ErrInvalidCredentials", err)
+	}
+	if exists, err := db.NewSelect().Model((*model.User)(nil)).Where("username = ?", "carol").Exists(ctx); err != nil {
+		t.Fatal(err)
+	} else if exists {
+		t.Error("carol provisioned although it is turned off")
+	}
+}
+
+func TestLDAPSyncRole(t *testing.T) {
+	l, db, server := newTestLDAP(t, person("alice", adminsDN), person("bob", adminsDN))
+	l.cfg.AdminGroups = []string{adminsDN}
+	ctx := context.Background()
+
+	role := func(user *model.User) model.Role {
+		t.Helper()
+		var u model.User
+		if err := db.NewSelect().Model(&u).Column("role").Where("id = ?", user.ID).Scan(ctx); err != nil {
+			t.Fatal(err)
+		}
+		return u.Role
+	}
+
+	alice := login(t, l, "alice")
+	if alice.Role != model.RoleAdmin {
+		t.Fatalf("alice: role = %s, want admin", alice.Role)
+	}
+
+	// the last admin keeps the role when leaving the admin group
+	server.Put(person("alice"))
+	if user := login(t, l, "alice"); user.Role != model.RoleAdmin || role(alice) != model.RoleAdmin {
+		t.Errorf("last admin demoted to %s", role(alice))
+	}
+
+	bob := login(t, l, "bob")
+	if bob.Role != model.RoleAdmin {
+		t.Errorf("bob: role = %s, want admin", bob.Role)
+	}
+	if user := login(t, l, "alice"); user.Role != model.RoleUser || role(alice) != model.RoleUser {
+		t.Errorf("alice: role = %s, want user once bob is admin", role(alice))
+	}
+
+	// roles are left alone without admin groups
+	l.cfg.AdminGroups = nil
+	server.Put(person("bob"))
+	if login(t, l, "bob"); role(bob) != model.RoleAdmin {
+		t.Errorf("bob: role = %s without admin groups, want admin", role(bob))
+	}
+}
@@ -0,0 +1,84 @@
+// Package login checks the username and password of the login form against
+// the passwords stored by xiazki and an LDAP directory.
+package login
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Authenticator returns the user a username and password belong to, or
+// ErrInvalidCredentials. Disabled users are returned as well, it is up to
+// the caller to refuse them.
+type Authenticator interface {
+	Authenticate(ctx context.Context, username, password string) (*model.User, error)
+}
+
+// Chain tries its authenticators in order and returns the user of the first
+// one which accepts the credentials. If none does, the first error other than
+// ErrInvalidCredentials is returned, e.g. because the directory was down.
+type Chain []Authenticator
+
+func (c Chain) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	var failed error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			return user, nil
+		}
+		if failed == nil && !errors.Is(err, ErrInvalidCredentials) {
+			failed = err
+		}
+	}
+	if failed != nil {
+		return nil, failed
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// dummyHash is compared with the passwords of users who have none.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, err := bcrypt.GenerateFromPassword([]byte("xiazki"), bcrypt.DefaultCost)
+	if err != nil {
+		panic(err)
+	}
+	return hash
+})
+
+// Password checks the bcrypt hashes of passwords stored in the database.
+type Password struct {
+	db *database.DB
+}
+
+func NewPassword(db *database.DB) *Password {
+	return &Password{db: db}
+}
+
+func (p *Password) Authenticate(ctx context.Context, username, password string) (*model.User, error) {
+	var user model.User
+	err := p.db.NewSelect().Model(&user).Where("username = ?", username).Scan(ctx)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	if err != nil || user.Password == "" {
+		// take as long as for a wrong password, so that the time it takes
+		// does not reveal which users exist
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if !user.CheckPassword(password) {
+		return nil, ErrInvalidCredentials
+	}
+	return &user, nil
+}
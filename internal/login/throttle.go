@@ -0,0 +1,122 @@
+package login
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+)
+
+const (
+	// freeFailures is how many failures in a row are not slowed down.
+	freeFailures = 3
+	// ipFailuresFactor scales the limits of a username to those of an IP
+	// address, which many users may share.
+	ipFailuresFactor = 5
+	// attemptRetention is how long login attempts are kept.
+	attemptRetention = 90 * 24 * time.Hour
+	// maxUsernameLength is how much of a username is recorded.
+	maxUsernameLength = 100
+)
+
+// Throttle slows down guessing passwords. After a few failed logins with a
+// username or from an IP address, the next attempt has to wait for a backoff
+// doubling with every failure. After maxFailures failures the username or
+// address is locked out for lockout. IP addresses get more failures before
+// both.
+// Failures older than lockout are not counted, neither are failures with a
+// username before its last successful login.
+type Throttle struct {
+	db          *database.DB
+	maxFailures int
+	lockout     time.Duration
+}
+
+func NewThrottle(db *database.DB, maxFailures int, lockout time.Duration) *Throttle {
+	return &Throttle{db: db, maxFailures: maxFailures, lockout: lockout}
+}
+
+// Attempt is an attempt to sign in reserved by Throttle.Begin. It counts as
+// a failure until it succeeds or is cancelled.
+type Attempt struct {
+	db *database.DB
+	id int64
+}
+
+// Begin reserves an attempt to sign in as username from ip. If it is too
+// early for another attempt, nothing is reserved and how long to wait is
+// returned instead. The attempt is recorded before the failures are counted,
+// and attempts in progress count as failures, so that concurrent attempts
+// see each other and cannot all pass the check.
+func (t *Throttle) Begin(ctx context.Context, username, ip string) (*Attempt, time.Duration, error) {
+	attempt := &model.LoginAttempt{Username: truncate(username), IP: ip, CreatedAt: time.Now()}
+	if err := t.db.InsertLoginAttempt(ctx, attempt, attemptRetention); err != nil {
+		return nil, 0, err
+	}
+
+	wait, err := t.wait(ctx, attempt)
+	if err != nil || wait > 0 {
+		// attempts which were refused are not failures
+		if err := t.db.DeleteLoginAttempt(context.WithoutCancel(ctx), attempt.ID); err != nil {
+			return nil, 0, err
+		}
+		return nil, wait, err
+	}
+	return &Attempt{db: t.db, id: attempt.ID}, 0, nil
+}
+
+// wait returns how long to wait before attempt, counting the failures other
+// than attempt.
+func (t *Throttle) wait(ctx context.Context, attempt *model.LoginAttempt) (time.Duration, error) {
+	since := attempt.CreatedAt.Add(-t.lockout)
+
+	n, last, err := t.db.UsernameFailures(ctx, attempt.Username, since, attempt.ID)
+	if err != nil {
+		return 0, err
+	}
+	wait := t.backoff(n, freeFailures, t.maxFailures, last)
+
+	n, last, err = t.db.IPFailures(ctx, attempt.IP, since, attempt.ID)
+	if err != nil {
+		return 0, err
+	}
+	return max(wait, t.backoff(n, freeFailures*ipFailuresFactor, t.maxFailures*ipFailuresFactor, last)), nil
+}
+
+// backoff returns how long to wait after failures, the last at last, of which
+// free were not slowed down.
+func (t *Throttle) backoff(failures, free, maxFailures int, last time.Time) time.Duration {
+	if failures < free {
+		return 0
+	}
+
+	d := t.lockout
+	if failures < maxFailures && failures-free < 30 {
+		d = min(time.Second<<(failures-free), t.lockout)
+	}
+	return max(time.Until(last.Add(d)), 0)
+}
+
+// Succeed records that the attempt succeeded, which resets the failures of
+// the username.
+func (a *Attempt) Succeed(ctx context.Context) error {
+	// the outcome is recorded even if the client went away meanwhile
+	return a.db.SucceedLoginAttempt(context.WithoutCancel(ctx), a.id)
+}
+
+// Cancel forgets an attempt which neither failed nor succeeded, e.g. because
+// the directory was down.
+func (a *Attempt) Cancel(ctx context.Context) error {
+	return a.db.DeleteLoginAttempt(context.WithoutCancel(ctx), a.id)
+}
+
+// truncate shortens username to what is recorded, the database only takes
+// valid UTF-8.
+func truncate(username string) string {
+	if len(username) > maxUsernameLength {
+		username = username[:maxUsernameLength]
+	}
+	return strings.ToValidUTF8(username, "")
+}
@@ -0,0 +1,106 @@
+package login
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"xiazki/internal/database/dbtest"
+)
+
+// fail begins an attempt which fails and returns how long it had to wait
+// instead.
+func fail(t *testing.T, throttle *Throttle, username, ip string) time.Duration {
+	t.Helper()
+	_, wait, err := throttle.Begin(context.Background(), username, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return wait
+}
+
+func TestThrottle(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewThrottle(dbtest.New(t), 10, time.Hour)
+
+	for i := range freeFailures {
+		if wait := fail(t, throttle, "alice", "192.0.2.1"); wait != 0 {
+			t.Fatalf("failure %d: wait = %s, want none", i+1, wait)
+		}
+	}
+	wait := fail(t, throttle, "alice", "192.0.2.2")
+	if wait <= 0 || wait > time.Second {
+		t.Errorf("after %d failures: wait = %s, want up to a second", freeFailures, wait)
+	}
+
+	// a refused attempt is no failure, so the backoff does not grow
+	if wait := fail(t, throttle, "alice", "192.0.2.3"); wait > time.Second {
+		t.Errorf("after a refused attempt: wait = %s, want up to a second", wait)
+	}
+
+	if wait := fail(t, throttle, "bob", "192.0.2.1"); wait != 0 {
+		t.Errorf("other username: wait = %s, want none", wait)
+	}
+
+	// cancelled attempts neither fail nor succeed
+	for range freeFailures + 1 {
+		attempt, wait, err := throttle.Begin(ctx, "carol", "192.0.2.4")
+		if err != nil || wait != 0 {
+			t.Fatalf("Begin = %s, %v, want an attempt", wait, err)
+		}
+		if err := attempt.Cancel(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for range freeFailures - 1 {
+		fail(t, throttle, "carol", "192.0.2.4")
+	}
+	attempt, wait, err := throttle.Begin(ctx, "carol", "192.0.2.4")
+	if err != nil || wait != 0 {
+		t.Fatalf("Begin = %s, %v, want an attempt", wait, err)
+	}
+	if err := attempt.Succeed(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// the success reset the failures of the username
+	for i := range freeFailures {
+		if wait := fail(t, throttle, "carol", "192.0.2.5"); wait != 0 {
+			t.Fatalf("failure %d after the success: wait = %s, want none", i+1, wait)
+		}
+	}
+}
+
+func TestThrottleConcurrent(t *testing.T) {
+	throttle := NewThrottle(dbtest.New(t), 10, time.Hour)
+
+	const attempts = 20
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		passed int
+	)
+	for i := range attempts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			attempt, _, err := throttle.Begin(context.Background(), "alice", "192.0.2.1")
+			if err != nil {
+				t.Errorf("attempt %d: %v", i, err)
+				return
+			}
+			if attempt != nil {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// attempts in progress count as failures, so at most the free ones pass
+	if passed > freeFailures {
+		t.Errorf("%d of %d concurrent attempts passed, want at most %d", passed, attempts, freeFailures)
+	}
+}
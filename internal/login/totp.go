@@ -0,0 +1,105 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/base64"
+	"image/png"
+	"strings"
+	"time"
+
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpIssuer = "xiazki"
+	totpPeriod = 30
+	// totpSkew is how many steps codes may be off either way, for the clocks
+	// of phones which are not quite right.
+	totpSkew = 1
+	qrSize   = 200
+)
+
+// TOTPKey is a new secret for an authenticator app.
+type TOTPKey struct {
+	Secret string
+	// QRCode is a PNG data URL of the QR code of the key's otpauth:// URL.
+	QRCode string
+}
+
+func NewTOTPKey(username string) (*TOTPKey, error) {
+	return totpKey(totp.GenerateOpts{Issuer: totpIssuer, AccountName: username, Period: totpPeriod})
+}
+
+// TOTPKeyOf returns the key of a secret returned by NewTOTPKey, to show it
+// again.
+func TOTPKeyOf(username, secret string) (*TOTPKey, error) {
+	b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		return nil, err
+	}
+	return totpKey(totp.GenerateOpts{Issuer: totpIssuer, AccountName: username, Period: totpPeriod, Secret: b})
+}
+
+func totpKey(opts totp.GenerateOpts) (*TOTPKey, error) {
+	key, err := totp.Generate(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := key.Image(qrSize, qrSize)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return &TOTPKey{
+		Secret: key.Secret(),
+		QRCode: "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
+}
+
+// CheckTOTP reports whether code is a code of secret at now and returns the
+// time step it belongs to, so that it can be refused when it is used again.
+func CheckTOTP(secret, code string, now time.Time) (int64, bool) {
+	opts := totp.ValidateOpts{Period: totpPeriod, Digits: otp.DigitsSix, Algorithm: otp.AlgorithmSHA1}
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		t := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, t, opts)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return t.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// CheckSecondFactor reports whether code is an authentication code of user
+// which has not been used yet, or one of their recovery codes, which is used
+// up then.
+func CheckSecondFactor(ctx context.Context, db *database.DB, user *model.User, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if code == "" || !user.HasTOTP() {
+		return false, nil
+	}
+
+	if len(code) == int(otp.DigitsSix) {
+		step, ok := CheckTOTP(user.TOTPSecret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return db.UseTOTPStep(ctx, user.ID, step)
+	}
+	return db.UseRecoveryCode(ctx, user.ID, code)
+}
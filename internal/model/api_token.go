@@ -0,0 +1,78 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// APITokenPrefix starts every API token, so that leaked tokens are easy to
+// recognize.
+const APITokenPrefix = "xzk_"
+
+type APIScope string
+
+const (
+	// APIScopeRead only allows reading.
+	APIScopeRead APIScope = "read"
+	// APIScopeWrite allows reading and changing.
+	APIScopeWrite APIScope = "write"
+)
+
+var APIScopes = []APIScope{APIScopeRead, APIScopeWrite}
+
+// APIToken is a personal token for the API, sent in the Authorization
+// header. Only the hash of the token is stored.
+type APIToken struct {
+	bun.BaseModel `bun:"table:api_tokens"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name,notnull"`
+	TokenHash string    `bun:"token_hash,notnull,unique"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	Scope     APIScope  `bun:"scope,notnull"`
+	// ExpiresAt is zero for tokens which do not expire.
+	ExpiresAt  time.Time `bun:"expires_at,nullzero"`
+	LastUsedAt time.Time `bun:"last_used_at,nullzero"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// NewAPIToken returns a token expiring after ttl, or never if ttl is zero,
+// together with the token itself.
+func NewAPIToken(userID uuid.UUID, name string, scope APIScope, ttl time.Duration) (*APIToken, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := APITokenPrefix + hex.EncodeToString(b)
+
+	t := &APIToken{
+		Name:      name,
+		TokenHash: HashAPIToken(token),
+		UserID:    userID,
+		Scope:     scope,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		t.ExpiresAt = t.CreatedAt.Add(ttl)
+	}
+	return t, token, nil
+}
+
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Allows tells whether the token may be used for a request with method.
+func (t *APIToken) Allows(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+	return t.Scope == APIScopeWrite
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/uptrace/bun"
 )
 
@@ -21,9 +22,12 @@ type Book struct {
 	SeriesName   string    `bun:"series_name,nullzero"`
 	SeriesNumber int64     `bun:"series_number,nullzero"`
 	CoverURL     string    `bun:"cover_url,nullzero"`
+	AddedByID    uuid.UUID `bun:"added_by_id,type:uuid,nullzero"`
 	CreatedAt    time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
 	UpdatedAt    time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
 
+	AddedBy *User `bun:"rel:belongs-to,join:added_by_id=id"`
+
 	Authors     []*Author     `bun:"m2m:book_authors,join:Book=Author"`
 	Tags        []*Tag        `bun:"m2m:book_tags,join:Book=Tag"`
 	Translators []*Translator `bun:"m2m:book_translators,join:Book=Translator"`
@@ -31,3 +35,10 @@ type Book struct {
 
 	Events []*Event `bun:"rel:has-many,join:id=book_id"`
 }
+
+// EditableBy reports whether user may edit or delete the book. Books are
+// shared, so only the user who added a book and admins may change it. Books
+// added before this was recorded can only be changed by admins.
+func (b *Book) EditableBy(user *User) bool {
+	return user.IsAdmin() || b.AddedByID != uuid.Nil && b.AddedByID == user.ID
+}
@@ -13,8 +13,13 @@ const (
 	EventFinished EventType = "finished"
 	EventReading  EventType = "reading"
 	EventDropped  EventType = "dropped"
+	EventToRead   EventType = "to-read"
 )
 
+// EventTypes lists the event types from the least to the most conclusive one.
+// The last event of a book in this order is its current state.
+var EventTypes = []EventType{EventToRead, EventReading, EventDropped, EventFinished}
+
 type Event struct {
 	bun.BaseModel `bun:"table:events"`
 
@@ -24,7 +29,7 @@ type Event struct {
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
 	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
 
-	UserID uuid.UUID `bun:"user_id,notnull"`
+	UserID uuid.UUID `bun:"user_id,type:uuid,notnull"`
 	BookID int64     `bun:"book_id,notnull"`
 	User   *User     `bun:"rel:belongs-to,join:user_id=id"`
 	Book   *Book     `bun:"rel:belongs-to,join:book_id=id"`
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+)
+
+// BookFilter narrows down a query over books. Zero values are ignored, so the
+// zero BookFilter matches every book.
+type BookFilter struct {
+	Title      string
+	Author     string
+	Tag        string
+	Translator string
+	Narrator   string
+	Publisher  string
+	Language   string
+	Series     string
+	ISBN       string
+
+	PagesMin      int64
+	PagesMax      int64
+	PublishedFrom time.Time
+	PublishedTo   time.Time
+
+	// Status and the rating range are evaluated for the user the filter
+	// is applied for.
+	Status    EventType
+	RatingMin int64
+	RatingMax int64
+}
+
+// BookSort is the order of a books list.
+type BookSort string
+
+const (
+	SortAdded     BookSort = "added"
+	SortTitle     BookSort = "title"
+	SortAuthor    BookSort = "author"
+	SortPublished BookSort = "published"
+	SortRating    BookSort = "rating"
+	SortLastRead  BookSort = "last_read"
+)
+
+var BookSorts = []BookSort{SortAdded, SortTitle, SortAuthor, SortPublished, SortRating, SortLastRead}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Identity links a user to their account at an OpenID Connect provider,
+// identified by the subject the provider issued for it.
+type Identity struct {
+	bun.BaseModel `bun:"table:user_identities"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	Provider  string    `bun:"provider,notnull,unique:provider_subject"`
+	Subject   string    `bun:"subject,notnull,unique:provider_subject"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+
+	User *User `bun:"rel:belongs-to,join:user_id=id"`
+}
@@ -0,0 +1,57 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// Invite lets one person register while registration is invite-only. Only
+// the hash of the token is stored, the token itself is shown once.
+type Invite struct {
+	bun.BaseModel `bun:"table:invites"`
+
+	ID          int64     `bun:"id,pk,autoincrement"`
+	TokenHash   string    `bun:"token_hash,notnull,unique"`
+	CreatedByID uuid.UUID `bun:"created_by_id,type:uuid,notnull"`
+	ExpiresAt   time.Time `bun:"expires_at,notnull"`
+	UsedAt      time.Time `bun:"used_at,nullzero"`
+	UsedByID    uuid.UUID `bun:"used_by_id,type:uuid,nullzero"`
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+
+	CreatedBy *User `bun:"rel:belongs-to,join:created_by_id=id"`
+	UsedBy    *User `bun:"rel:belongs-to,join:used_by_id=id"`
+}
+
+// NewInvite returns an invite expiring after ttl together with its token.
+func NewInvite(createdByID uuid.UUID, ttl time.Duration) (*Invite, string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	invite := &Invite{
+		TokenHash:   HashInviteToken(token),
+		CreatedByID: createdByID,
+		ExpiresAt:   time.Now().Add(ttl),
+	}
+	return invite, token, nil
+}
+
+func HashInviteToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (i *Invite) Used() bool {
+	return !i.UsedAt.IsZero()
+}
+
+func (i *Invite) Expired() bool {
+	return time.Now().After(i.ExpiresAt)
+}
@@ -0,0 +1,35 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type BookFormat string
+
+const (
+	FormatPaperback BookFormat = "paperback"
+	FormatHardcover BookFormat = "hardcover"
+	FormatEbook     BookFormat = "ebook"
+	FormatAudiobook BookFormat = "audiobook"
+)
+
+var BookFormats = []BookFormat{FormatPaperback, FormatHardcover, FormatEbook, FormatAudiobook}
+
+// UserBook puts a book of the shared catalog into the library of a user.
+type UserBook struct {
+	bun.BaseModel `bun:"table:user_books"`
+
+	UserID    uuid.UUID  `bun:"user_id,pk,type:uuid"`
+	BookID    int64      `bun:"book_id,pk"`
+	Owned     bool       `bun:"owned,notnull,default:false"`
+	Format    BookFormat `bun:"format,nullzero"`
+	Location  string     `bun:"location,nullzero"`
+	CreatedAt time.Time  `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time  `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+
+	User *User `bun:"rel:belongs-to,join:user_id=id"`
+	Book *Book `bun:"rel:belongs-to,join:book_id=id"`
+}
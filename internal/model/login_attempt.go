@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// LoginAttempt records a use of the login form, failed attempts are shown to
+// admins and slow down further attempts.
+type LoginAttempt struct {
+	bun.BaseModel `bun:"table:login_attempts"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Username  string    `bun:"username,notnull"`
+	IP        string    `bun:"ip,notnull"`
+	Success   bool      `bun:"success,notnull,default:false"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
@@ -12,11 +12,13 @@ type Quote struct {
 
 	ID        int64     `bun:"id,pk,autoincrement"`
 	Quote     string    `bun:"quote,notnull"`
+	Page      int64     `bun:"page,nullzero"`
+	Note      string    `bun:"note,nullzero"`
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
 	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
 
-	UserID uuid.UUID `bun:"user_id,notnull,unique:user_book"`
-	BookID int64     `bun:"book_id,notnull,unique:user_book"`
+	UserID uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	BookID int64     `bun:"book_id,notnull"`
 	User   *User     `bun:"rel:belongs-to,join:user_id=id"`
 	Book   *Book     `bun:"rel:belongs-to,join:book_id=id"`
 }
@@ -0,0 +1,53 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets.
+const RecoveryCodeCount = 10
+
+// RecoveryCode replaces the authentication code of a user who lost their
+// authenticator, once. Only the hash of the code is stored, the code itself
+// is shown once.
+type RecoveryCode struct {
+	bun.BaseModel `bun:"table:recovery_codes"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	CodeHash  string    `bun:"code_hash,notnull,unique"`
+	UsedAt    time.Time `bun:"used_at,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// NewRecoveryCodes returns the recovery codes of a user together with the
+// codes themselves, formatted like "1a2b3-c4d5e".
+func NewRecoveryCodes(userID uuid.UUID) ([]*RecoveryCode, []string, error) {
+	recoveryCodes := make([]*RecoveryCode, RecoveryCodeCount)
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := hex.EncodeToString(b)
+		codes[i] = code[:5] + "-" + code[5:]
+		recoveryCodes[i] = &RecoveryCode{UserID: userID, CodeHash: HashRecoveryCode(codes[i])}
+	}
+	return recoveryCodes, codes, nil
+}
+
+// HashRecoveryCode hashes a code as typed by the user, who may leave out the
+// dash or use upper case.
+func HashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -16,7 +16,7 @@ type Review struct {
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
 	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
 
-	UserID uuid.UUID `bun:"user_id,notnull,unique:user_book"`
+	UserID uuid.UUID `bun:"user_id,type:uuid,notnull,unique:user_book"`
 	BookID int64     `bun:"book_id,notnull,unique:user_book"`
 	User   *User     `bun:"rel:belongs-to,join:user_id=id"`
 	Book   *Book     `bun:"rel:belongs-to,join:book_id=id"`
@@ -27,5 +27,5 @@ type ReviewStats struct {
 	AverageRating float64          `bun:"average_rating"`
 	RatingsCount  int64            `bun:"ratings_count"`
 	OpinionsCount int64            `bun:"opinions_count"`
-	RatingsSpread map[string]int64 `bun:"ratings_spread,type:jsonb,json_use_number"`
+	RatingsSpread map[string]int64 `bun:"-"`
 }
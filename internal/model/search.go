@@ -0,0 +1,35 @@
+package model
+
+// Snippets returned by the full-text search mark the matched terms with these
+// control characters, so that the text can be escaped before highlighting.
+const (
+	SnippetStart = "\x02"
+	SnippetEnd   = "\x03"
+)
+
+type SearchResults struct {
+	Books   []BookMatch
+	Reviews []ReviewMatch
+	Quotes  []QuoteMatch
+}
+
+type BookMatch struct {
+	BookID  int64  `bun:"book_id"`
+	Title   string `bun:"title"`
+	Snippet string `bun:"snippet"`
+}
+
+type ReviewMatch struct {
+	ReviewID  int64  `bun:"review_id"`
+	BookID    int64  `bun:"book_id"`
+	BookTitle string `bun:"book_title"`
+	Username  string `bun:"username"`
+	Snippet   string `bun:"snippet"`
+}
+
+type QuoteMatch struct {
+	QuoteID   int64  `bun:"quote_id"`
+	BookID    int64  `bun:"book_id"`
+	BookTitle string `bun:"book_title"`
+	Snippet   string `bun:"snippet"`
+}
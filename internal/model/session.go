@@ -0,0 +1,63 @@
+package model
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+// maxUserAgent is how much of the user agent of a session is kept.
+const maxUserAgent = 255
+
+// Session is a device a user is signed in on. The session cookie only holds
+// the token, of which the hash is stored, so sessions can be revoked.
+type Session struct {
+	bun.BaseModel `bun:"table:sessions"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	TokenHash string    `bun:"token_hash,notnull,unique"`
+	UserID    uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	// SecondFactor records whether the user has passed the second factor,
+	// users with one set up are only signed in halfway without it.
+	SecondFactor bool      `bun:"second_factor,notnull,default:false"`
+	UserAgent    string    `bun:"user_agent,nullzero"`
+	IP           string    `bun:"ip,nullzero"`
+	ExpiresAt    time.Time `bun:"expires_at,notnull"`
+	LastSeenAt   time.Time `bun:"last_seen_at,nullzero,notnull,default:current_timestamp"`
+	CreatedAt    time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// NewSession returns a session expiring after ttl together with its token.
+func NewSession(userID uuid.UUID, secondFactor bool, userAgent, ip string, ttl time.Duration) (*Session, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := hex.EncodeToString(b)
+
+	if len(userAgent) > maxUserAgent {
+		userAgent = userAgent[:maxUserAgent]
+	}
+	now := time.Now()
+	session := &Session{
+		TokenHash:    HashSessionToken(token),
+		UserID:       userID,
+		SecondFactor: secondFactor,
+		UserAgent:    strings.ToValidUTF8(userAgent, ""),
+		IP:           ip,
+		ExpiresAt:    now.Add(ttl),
+		LastSeenAt:   now,
+		CreatedAt:    now,
+	}
+	return session, token, nil
+}
+
+func HashSessionToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
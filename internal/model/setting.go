@@ -0,0 +1,45 @@
+package model
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Setting is an instance wide setting changed from the admin panel.
+type Setting struct {
+	bun.BaseModel `bun:"table:settings"`
+
+	Key       string    `bun:"key,pk"`
+	Value     string    `bun:"value,notnull"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+}
+
+const (
+	// SettingRegistration holds the RegistrationMode.
+	SettingRegistration = "registration"
+)
+
+type RegistrationMode string
+
+const (
+	// RegistrationOpen lets anyone register.
+	RegistrationOpen RegistrationMode = "open"
+	// RegistrationInvite requires an invite from an admin.
+	RegistrationInvite RegistrationMode = "invite"
+	// RegistrationClosed does not allow registering, not even with an invite.
+	RegistrationClosed RegistrationMode = "closed"
+)
+
+var RegistrationModes = []RegistrationMode{RegistrationOpen, RegistrationInvite, RegistrationClosed}
+
+// InstanceStats is an overview of the instance for admins.
+type InstanceStats struct {
+	Users   int
+	Books   int
+	Events  int
+	Reviews int
+	Quotes  int
+	// DBSize is the size of the database in bytes.
+	DBSize int64
+}
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type Shelf struct {
+	bun.BaseModel `bun:"table:shelves"`
+
+	ID        int64     `bun:"id,pk,autoincrement"`
+	Name      string    `bun:"name,notnull,unique:user_name"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+
+	UserID uuid.UUID `bun:"user_id,type:uuid,notnull,unique:user_name"`
+	User   *User     `bun:"rel:belongs-to,join:user_id=id"`
+
+	Books []*Book `bun:"m2m:shelf_books,join:Shelf=Book"`
+}
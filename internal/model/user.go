@@ -22,8 +22,25 @@ type User struct {
 	Username  string    `bun:"username,notnull,unique"`
 	Password  string    `bun:"password,notnull"`
 	Role      Role      `bun:"role,notnull,default:'user'"`
+	Disabled  bool      `bun:"disabled,notnull,default:false"`
 	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
 	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp"`
+
+	// TOTPSecret is the base32 secret of the authenticator app of the user,
+	// TOTPStep the time step of the last code used, which cannot be used
+	// again.
+	TOTPSecret string `bun:"totp_secret,nullzero"`
+	TOTPStep   int64  `bun:"totp_step,notnull,default:0"`
+}
+
+func (u *User) IsAdmin() bool {
+	return u.Role == RoleAdmin
+}
+
+// HasTOTP reports whether the user signs in with an authentication code after
+// their password.
+func (u *User) HasTOTP() bool {
+	return u.TOTPSecret != ""
 }
 
 func (u *User) SetPassword(password string) error {
@@ -1,6 +1,8 @@
 package model
 
 import (
+	"time"
+
 	"github.com/uptrace/bun"
 )
 
@@ -39,3 +41,14 @@ type BookNarrator struct {
 	Book       *Book     `bun:"rel:belongs-to,join:book_id=id"`
 	Narrator   *Narrator `bun:"rel:belongs-to,join:narrator_id=id"`
 }
+
+type ShelfBook struct {
+	bun.BaseModel `bun:"table:shelf_books"`
+
+	ShelfID   int64     `bun:"shelf_id,pk"`
+	BookID    int64     `bun:"book_id,pk"`
+	Position  int64     `bun:"position,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+	Shelf     *Shelf    `bun:"rel:belongs-to,join:shelf_id=id"`
+	Book      *Book     `bun:"rel:belongs-to,join:book_id=id"`
+}
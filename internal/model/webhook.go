@@ -0,0 +1,129 @@
+package model
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/uptrace/bun"
+)
+
+type WebhookEvent string
+
+const (
+	WebhookBookCreated  WebhookEvent = "book.created"
+	WebhookEventCreated WebhookEvent = "event.created"
+	WebhookReviewSaved  WebhookEvent = "review.saved"
+	// WebhookPing is only sent when the user asks for it, to try a webhook.
+	WebhookPing WebhookEvent = "ping"
+)
+
+// WebhookEvents are the events webhooks can subscribe to.
+var WebhookEvents = []WebhookEvent{WebhookBookCreated, WebhookEventCreated, WebhookReviewSaved}
+
+// Webhook is a URL the activity of a user is posted to.
+type Webhook struct {
+	bun.BaseModel `bun:"table:webhooks"`
+
+	ID     int64     `bun:"id,pk,autoincrement"`
+	UserID uuid.UUID `bun:"user_id,type:uuid,notnull"`
+	URL    string    `bun:"url,notnull"`
+	// Secret signs the deliveries. It is stored as is, as it is needed to
+	// sign them.
+	Secret string `bun:"secret,notnull"`
+	// Events are the subscribed events separated by commas.
+	Events    string    `bun:"events,notnull"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+}
+
+// NewWebhook returns a webhook with a new secret.
+func NewWebhook(userID uuid.UUID, url string, events []WebhookEvent) (*Webhook, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	list := make([]string, 0, len(events))
+	for _, e := range events {
+		list = append(list, string(e))
+	}
+	return &Webhook{
+		UserID: userID,
+		URL:    url,
+		Secret: hex.EncodeToString(b),
+		Events: strings.Join(list, ","),
+	}, nil
+}
+
+func (w *Webhook) EventList() []WebhookEvent {
+	var list []WebhookEvent
+	for e := range strings.SplitSeq(w.Events, ",") {
+		if e != "" {
+			list = append(list, WebhookEvent(e))
+		}
+	}
+	return list
+}
+
+func (w *Webhook) Subscribes(event WebhookEvent) bool {
+	return slices.Contains(w.EventList(), event)
+}
+
+// WebhookOutbox is a change queued for the webhooks of its user by the
+// transaction making it. It only holds what the change is about, the payload
+// is built from the data when the change is turned into deliveries.
+type WebhookOutbox struct {
+	bun.BaseModel `bun:"table:webhook_outbox"`
+
+	ID     int64        `bun:"id,pk,autoincrement"`
+	Type   WebhookEvent `bun:"type,notnull"`
+	UserID uuid.UUID    `bun:"user_id,type:uuid,notnull"`
+	BookID int64        `bun:"book_id,notnull"`
+	// EventID is set for event.created, ReviewID for review.saved.
+	EventID   int64     `bun:"event_id,nullzero"`
+	ReviewID  int64     `bun:"review_id,nullzero"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+
+	User   *User   `bun:"rel:belongs-to,join:user_id=id"`
+	Book   *Book   `bun:"rel:belongs-to,join:book_id=id"`
+	Event  *Event  `bun:"rel:belongs-to,join:event_id=id"`
+	Review *Review `bun:"rel:belongs-to,join:review_id=id"`
+}
+
+// MaxDeliveryAttempts is how often a delivery is tried before it fails.
+const MaxDeliveryAttempts = 8
+
+type DeliveryStatus string
+
+const (
+	DeliveryPending   DeliveryStatus = "pending"
+	DeliveryDelivered DeliveryStatus = "delivered"
+	// DeliveryFailed deliveries ran out of attempts.
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// WebhookDelivery is a payload queued for a webhook, which is kept after it
+// was delivered or given up on as the delivery log.
+type WebhookDelivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries"`
+
+	ID        int64          `bun:"id,pk,autoincrement"`
+	WebhookID int64          `bun:"webhook_id,notnull"`
+	Event     WebhookEvent   `bun:"event,notnull"`
+	Payload   string         `bun:"payload,notnull"`
+	Status    DeliveryStatus `bun:"status,notnull"`
+	Attempts  int            `bun:"attempts,notnull,default:0"`
+	// NextAttemptAt is when a pending delivery is due.
+	NextAttemptAt time.Time `bun:"next_attempt_at,notnull"`
+	LastAttemptAt time.Time `bun:"last_attempt_at,nullzero"`
+	// ResponseStatus is the HTTP status of the last attempt, Error what went
+	// wrong with it.
+	ResponseStatus int       `bun:"response_status,nullzero"`
+	Error          string    `bun:"error,nullzero"`
+	CreatedAt      time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp"`
+
+	Webhook *Webhook `bun:"rel:belongs-to,join:webhook_id=id"`
+}
@@ -94,9 +94,9 @@ type Fetcher struct {
 	apiKey  string
 }
 
-func NewFetcher(apiKey string) *Fetcher {
+func NewFetcher(apiKey string, timeout time.Duration) *Fetcher {
 	return &Fetcher{
-		client:  &http.Client{Timeout: 10 * time.Second},
+		client:  &http.Client{Timeout: timeout},
 		baseURL: "https://www.googleapis.com/books/v1/volumes",
 		apiKey:  apiKey,
 	}
@@ -0,0 +1,130 @@
+// Package ldap checks passwords by binding to an LDAP directory as the user.
+package ldap
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// ErrInvalidCredentials is returned for unknown users and wrong passwords.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+type Config struct {
+	URL          string
+	StartTLS     bool
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter finds the entry of a user, {username} is replaced with the
+	// escaped username.
+	UserFilter        string
+	UsernameAttribute string
+	GroupAttribute    string
+}
+
+// Entry is the directory entry of an authenticated user.
+type Entry struct {
+	DN       string
+	Username string
+	// Groups are the DNs of the groups the user is a member of.
+	Groups []string
+}
+
+// MemberOf reports whether the user is a member of one of groups.
+func (e *Entry) MemberOf(groups []string) bool {
+	for _, group := range groups {
+		for _, g := range e.Groups {
+			if strings.EqualFold(g, group) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type Directory struct {
+	cfg     Config
+	timeout time.Duration
+}
+
+func NewDirectory(cfg Config, timeout time.Duration) *Directory {
+	return &Directory{cfg: cfg, timeout: timeout}
+}
+
+func (d *Directory) dial() (*ldap.Conn, error) {
+	conn, err := ldap.DialURL(d.cfg.URL, ldap.DialWithDialer(&net.Dialer{Timeout: d.timeout}))
+	if err != nil {
+		return nil, fmt.Errorf("dial %s: %w", d.cfg.URL, err)
+	}
+	conn.SetTimeout(d.timeout)
+
+	if d.cfg.StartTLS {
+		u, err := url.Parse(d.cfg.URL)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if err := conn.StartTLS(&tls.Config{ServerName: u.Hostname()}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("start TLS: %w", err)
+		}
+	}
+	return conn, nil
+}
+
+// Authenticate finds the entry of username and binds as it with password.
+func (d *Directory) Authenticate(username, password string) (*Entry, error) {
+	// an empty password would be an unauthenticated bind, which succeeds
+	if username == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	conn, err := d.dial()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if d.cfg.BindDN != "" {
+		if err := conn.Bind(d.cfg.BindDN, d.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("bind as %s: %w", d.cfg.BindDN, err)
+		}
+	}
+
+	res, err := conn.Search(ldap.NewSearchRequest(
+		d.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(d.timeout.Seconds()), false,
+		strings.ReplaceAll(d.cfg.UserFilter, "{username}", ldap.EscapeFilter(username)),
+		[]string{d.cfg.UsernameAttribute, d.cfg.GroupAttribute},
+		nil,
+	))
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("search %s: %w", username, err)
+	}
+	// an ambiguous filter must not let one user sign in as another
+	if len(res.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := res.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+		return nil, ErrInvalidCredentials
+	} else if err != nil {
+		return nil, fmt.Errorf("bind as %s: %w", entry.DN, err)
+	}
+
+	return &Entry{
+		DN:       entry.DN,
+		Username: entry.GetAttributeValue(d.cfg.UsernameAttribute),
+		Groups:   entry.GetAttributeValues(d.cfg.GroupAttribute),
+	}, nil
+}
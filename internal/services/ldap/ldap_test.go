@@ -0,0 +1,122 @@
+package ldap
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+
+	"xiazki/internal/services/ldap/ldaptest"
+)
+
+const (
+	baseDN   = "ou=people,dc=example,dc=org"
+	readerDN = "cn=reader,dc=example,dc=org"
+)
+
+func person(uid, password string, groups ...string) ldaptest.Entry {
+	return ldaptest.Entry{
+		DN:       "uid=" + uid + "," + baseDN,
+		Password: password,
+		Attributes: map[string][]string{
+			"objectClass": {"person"},
+			"uid":         {uid},
+			"memberOf":    groups,
+		},
+	}
+}
+
+func newTestDirectory(t *testing.T, entries ...ldaptest.Entry) (*Directory, *ldaptest.Server) {
+	t.Helper()
+	reader := ldaptest.Entry{DN: readerDN, Password: "reader-password"}
+	server := ldaptest.NewServer(t, append(entries, reader)...)
+	return NewDirectory(Config{
+		URL:               server.URL,
+		BindDN:            readerDN,
+		BindPassword:      "reader-password",
+		BaseDN:            baseDN,
+		UserFilter:        "(&(objectClass=*)(uid={username}))",
+		UsernameAttribute: "uid",
+		GroupAttribute:    "memberOf",
+	}, 5*time.Second), server
+}
+
+func TestAuthenticate(t *testing.T) {
+	d, _ := newTestDirectory(t, person("alice", "alice-password", "cn=readers,dc=example,dc=org"))
+
+	entry, err := d.Authenticate("alice", "alice-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.DN != "uid=alice,"+baseDN || entry.Username != "alice" {
+		t.Errorf("entry = %+v", entry)
+	}
+	if !entry.MemberOf([]string{"CN=Readers,DC=example,DC=org"}) || entry.MemberOf([]string{"cn=admins,dc=example,dc=org"}) {
+		t.Errorf("groups = %q", entry.Groups)
+	}
+
+	for _, tt := range []struct{ username, password string }{
+		{"alice", "wrong"},
+		{"nobody", "alice-password"},
+		// an empty password would be an anonymous bind, which succeeds
+		{"alice", ""},
+		{"", ""},
+	} {
+		if _, err := d.Authenticate(tt.username, tt.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%q/%q: err = %v, want ErrInvalidCredentials", tt.username, tt.password, err)
+		}
+	}
+}
+
+func TestAuthenticateEscapesUsername(t *testing.T) {
+	d, server := newTestDirectory(t,
+		person("alice", "shared-password"),
+		person("bob", "shared-password"),
+	)
+
+	for _, username := range []string{"*", "alice)(uid=*", `a\2a`} {
+		if _, err := d.Authenticate(username, "shared-password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%q: err = %v, want ErrInvalidCredentials", username, err)
+		}
+	}
+
+	want := []string{
+		`(&(objectClass=*)(uid=\2a))`,
+		`(&(objectClass=*)(uid=alice\29\28uid=\2a))`,
+		`(&(objectClass=*)(uid=a\5c2a))`,
+	}
+	if got := server.Filters(); !slices.Equal(got, want) {
+		t.Errorf("filters = %q, want %q", got, want)
+	}
+}
+
+func TestAuthenticateAmbiguous(t *testing.T) {
+	twin := func(ou string) ldaptest.Entry {
+		e := person("twin", "twin-password")
+		e.DN = "uid=twin,ou=" + ou + "," + baseDN
+		return e
+	}
+
+	// one user must not be able to sign in as another whose entry matches
+	// the filter as well
+	d, _ := newTestDirectory(t, twin("a"), twin("b"))
+	if _, err := d.Authenticate("twin", "twin-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("two matches: err = %v, want ErrInvalidCredentials", err)
+	}
+
+	// three matches exceed the size limit of the search
+	d, _ = newTestDirectory(t, twin("a"), twin("b"), twin("c"))
+	if _, err := d.Authenticate("twin", "twin-password"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("three matches: err = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestAuthenticateBindDN(t *testing.T) {
+	d, _ := newTestDirectory(t, person("alice", "alice-password"))
+	d.cfg.BindPassword = "wrong"
+
+	// a broken search account is an error of the directory, not of the user
+	if _, err := d.Authenticate("alice", "alice-password"); err == nil || errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("err = %v, want a bind error", err)
+	}
+}
@@ -0,0 +1,266 @@
+// Package ldaptest runs an LDAP directory for tests. It supports simple binds
+// and subtree searches with and, or, not, equality and presence filters,
+// which is all the ldap package uses. Attribute names and values are compared
+// ignoring case.
+package ldaptest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Entry is an entry of the directory, which can be bound to with Password
+// if that is not empty.
+type Entry struct {
+	DN         string
+	Password   string
+	Attributes map[string][]string
+}
+
+func (e *Entry) values(attr string) []string {
+	for name, values := range e.Attributes {
+		if strings.EqualFold(name, attr) {
+			return values
+		}
+	}
+	return nil
+}
+
+type Server struct {
+	// URL is the ldap:// URL of the server.
+	URL string
+
+	ln      net.Listener
+	mu      sync.Mutex
+	entries []*Entry
+	filters []string
+	wg      sync.WaitGroup
+}
+
+// NewServer starts a directory with the entries, which is stopped when the
+// test ends.
+func NewServer(t testing.TB, entries ...Entry) *Server {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{URL: "ldap://" + ln.Addr().String(), ln: ln}
+	for _, e := range entries {
+		s.Put(e)
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		_ = ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+// Put adds an entry or replaces the entry with the same DN.
+func (s *Server) Put(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, e := range s.entries {
+		if strings.EqualFold(e.DN, entry.DN) {
+			s.entries[i] = &entry
+			return
+		}
+	}
+	s.entries = append(s.entries, &entry)
+}
+
+// Filters returns the filters of the searches received so far.
+func (s *Server) Filters() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.filters...)
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		var responses []*ber.Packet
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			responses = []*ber.Packet{s.bind(op)}
+		case ldap.ApplicationSearchRequest:
+			responses = s.search(op)
+		case ldap.ApplicationUnbindRequest:
+			return
+		default:
+			responses = []*ber.Packet{result(ldap.ApplicationExtendedResponse, ldap.LDAPResultUnwillingToPerform, "unsupported operation")}
+		}
+
+		for _, r := range responses {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, "Message ID"))
+			envelope.AppendChild(r)
+			if _, err := conn.Write(envelope.Bytes()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func result(tag ber.Tag, code uint16, msg string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, msg, "Diagnostic Message"))
+	return p
+}
+
+func (s *Server) bind(op *ber.Packet) *ber.Packet {
+	if len(op.Children) < 3 {
+		return result(ldap.ApplicationBindResponse, ldap.LDAPResultProtocolError, "malformed bind")
+	}
+	dn := op.Children[1].Data.String()
+	password := op.Children[2].Data.String()
+	if dn == "" && password == "" {
+		return result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if strings.EqualFold(e.DN, dn) && e.Password != "" && e.Password == password {
+			return result(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")
+		}
+	}
+	return result(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials, "invalid credentials")
+}
+
+func (s *Server) search(op *ber.Packet) []*ber.Packet {
+	if len(op.Children) < 8 {
+		return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, "malformed search")}
+	}
+	base := strings.ToLower(op.Children[0].Data.String())
+	sizeLimit, _ := op.Children[3].Value.(int64)
+	filter := op.Children[6]
+	var attrs []string
+	for _, a := range op.Children[7].Children {
+		attrs = append(attrs, a.Data.String())
+	}
+
+	compiled, err := ldap.DecompileFilter(filter)
+	if err != nil {
+		return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError, err.Error())}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.filters = append(s.filters, compiled)
+
+	var responses []*ber.Packet
+	for _, e := range s.entries {
+		if !strings.HasSuffix(strings.ToLower(e.DN), base) {
+			continue
+		}
+		ok, err := matches(e, filter)
+		if err != nil {
+			return []*ber.Packet{result(ldap.ApplicationSearchResultDone, ldap.LDAPResultUnwillingToPerform, err.Error())}
+		} else if !ok {
+			continue
+		}
+		if sizeLimit > 0 && int64(len(responses)) == sizeLimit {
+			return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSizeLimitExceeded, ""))
+		}
+		responses = append(responses, searchEntry(e, attrs))
+	}
+	return append(responses, result(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, ""))
+}
+
+func searchEntry(e *Entry, attrs []string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Search Result Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, e.DN, "Object Name"))
+	list := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for _, attr := range attrs {
+		values := e.values(attr)
+		if len(values) == 0 {
+			continue
+		}
+		a := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		a.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, attr, "Type"))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		a.AppendChild(set)
+		list.AppendChild(a)
+	}
+	p.AppendChild(list)
+	return p
+}
+
+var errUnsupportedFilter = errors.New("unsupported filter")
+
+func matches(e *Entry, filter *ber.Packet) (bool, error) {
+	switch filter.Tag {
+	case ldap.FilterAnd, ldap.FilterOr:
+		for _, child := range filter.Children {
+			ok, err := matches(e, child)
+			if err != nil {
+				return false, err
+			} else if ok == (filter.Tag == ldap.FilterOr) {
+				return ok, nil
+			}
+		}
+		return filter.Tag == ldap.FilterAnd, nil
+	case ldap.FilterNot:
+		if len(filter.Children) != 1 {
+			return false, errUnsupportedFilter
+		}
+		ok, err := matches(e, filter.Children[0])
+		return !ok, err
+	case ldap.FilterEqualityMatch:
+		if len(filter.Children) != 2 {
+			return false, errUnsupportedFilter
+		}
+		want := filter.Children[1].Data.String()
+		for _, v := range e.values(filter.Children[0].Data.String()) {
+			if strings.EqualFold(v, want) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case ldap.FilterPresent:
+		return len(e.values(filter.Data.String())) > 0, nil
+	default:
+		return false, errUnsupportedFilter
+	}
+}
@@ -0,0 +1,138 @@
+// Package oidc signs users in with OpenID Connect providers using the
+// authorization code flow with PKCE.
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	Scopes       []string
+	// UsernameClaim is the ID token claim returned as Identity.Username.
+	UsernameClaim string
+}
+
+// Flow is the state of one login, kept by the caller between AuthCodeURL
+// and Exchange.
+type Flow struct {
+	State    string
+	Nonce    string
+	Verifier string
+}
+
+func NewFlow() Flow {
+	return Flow{
+		State:    oauth2.GenerateVerifier(),
+		Nonce:    oauth2.GenerateVerifier(),
+		Verifier: oauth2.GenerateVerifier(),
+	}
+}
+
+// Identity is the verified identity of a user at a provider.
+type Identity struct {
+	Subject  string
+	Username string
+}
+
+// Provider is discovered on first use, so that xiazki starts while the
+// provider is unreachable.
+type Provider struct {
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewProvider(cfg Config, timeout time.Duration) *Provider {
+	return &Provider{
+		cfg:    cfg,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (p *Provider) discover(ctx context.Context) (*oidc.Provider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider == nil {
+		provider, err := oidc.NewProvider(p.context(ctx), p.cfg.Issuer)
+		if err != nil {
+			return nil, fmt.Errorf("discover %s: %w", p.cfg.Issuer, err)
+		}
+		p.provider = provider
+	}
+	return p.provider, nil
+}
+
+// context makes the oidc and oauth2 packages use the client of p.
+func (p *Provider) context(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, p.client)
+}
+
+func (p *Provider) oauth2Config(provider *oidc.Provider, redirectURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  redirectURL,
+		Scopes:       p.cfg.Scopes,
+	}
+}
+
+// AuthCodeURL returns the URL of the provider's login page, which redirects
+// back to redirectURL.
+func (p *Provider) AuthCodeURL(ctx context.Context, redirectURL string, flow Flow) (string, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return p.oauth2Config(provider, redirectURL).AuthCodeURL(flow.State,
+		oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier)), nil
+}
+
+// Exchange redeems the code the provider redirected back with and verifies
+// the ID token it returns.
+func (p *Provider) Exchange(ctx context.Context, redirectURL, code string, flow Flow) (*Identity, error) {
+	provider, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx = p.context(ctx)
+
+	token, err := p.oauth2Config(provider, redirectURL).Exchange(ctx, code, oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		return nil, fmt.Errorf("exchange code: %w", err)
+	}
+	raw, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no ID token")
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("verify ID token: %w", err)
+	}
+	if idToken.Nonce != flow.Nonce {
+		return nil, errors.New("ID token nonce does not match")
+	}
+
+	var claims map[string]any
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("decode ID token claims: %w", err)
+	}
+	username, _ := claims[p.cfg.UsernameClaim].(string)
+
+	return &Identity{Subject: idToken.Subject, Username: username}, nil
+}
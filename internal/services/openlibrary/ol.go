@@ -138,9 +138,9 @@ type Fetcher struct {
 	baseURL string
 }
 
-func NewFetcher() *Fetcher {
+func NewFetcher(timeout time.Duration) *Fetcher {
 	return &Fetcher{
-		client:  &http.Client{Timeout: 10 * time.Second},
+		client:  &http.Client{Timeout: timeout},
 		baseURL: "https://openlibrary.org",
 	}
 }
@@ -0,0 +1,59 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"xiazki/internal/api"
+	"xiazki/internal/model"
+)
+
+// PingPayload returns the body of a ping sent by a user.
+func PingPayload(username string) (string, error) {
+	return marshal(api.WebhookPayload{
+		Type:      model.WebhookPing,
+		CreatedAt: time.Now(),
+		Username:  username,
+	})
+}
+
+// payload returns the body of the deliveries of a change, false is returned
+// if what the change is about was deleted since.
+func payload(change *model.WebhookOutbox) (string, bool, error) {
+	if change.User == nil || change.Book == nil {
+		return "", false, nil
+	}
+
+	p := api.WebhookPayload{
+		Type:      change.Type,
+		CreatedAt: change.CreatedAt,
+		Username:  change.User.Username,
+		Book:      &api.BookSummary{ID: change.Book.ID, Title: change.Book.Title},
+	}
+	switch change.Type {
+	case model.WebhookEventCreated:
+		if change.Event == nil {
+			return "", false, nil
+		}
+		e := api.NewEvent(change.Event)
+		p.Event = &e
+	case model.WebhookReviewSaved:
+		if change.Review == nil {
+			return "", false, nil
+		}
+		r := api.NewReview(change.Review)
+		r.Username = change.User.Username
+		p.Review = &r
+	}
+
+	body, err := marshal(p)
+	return body, err == nil, err
+}
+
+func marshal(p api.WebhookPayload) (string, error) {
+	body, err := json.Marshal(p)
+	if err != nil {
+		return "", err
+	}
+	return string(body), nil
+}
@@ -0,0 +1,247 @@
+// Package webhook delivers the changes queued for webhooks by the database.
+// The changes in the outbox are turned into one delivery per subscribed
+// webhook, with the payload built from the data at that time. Deliveries are
+// signed with the secret of their webhook and retried with
+// growing delays until they succeed or run out of attempts. Finished
+// deliveries are kept as the log of their webhook for 30 days.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/model"
+)
+
+const (
+	// PollInterval is how often Run looks for due deliveries.
+	PollInterval = 5 * time.Second
+
+	firstRetry = 30 * time.Second
+	maxRetry   = 6 * time.Hour
+	batchSize  = 20
+	// retention is how long finished deliveries are kept, pruneInterval how
+	// often they are pruned.
+	retention     = 30 * 24 * time.Hour
+	pruneInterval = time.Hour
+	// maxError is the length error messages are cut to in the delivery log.
+	maxError = 500
+)
+
+// Headers of deliveries, the signature is "sha256=" followed by the hex
+// encoded HMAC of the timestamp, a dot and the body.
+const (
+	HeaderEvent     = "X-Xiazki-Event"
+	HeaderDelivery  = "X-Xiazki-Delivery"
+	HeaderTimestamp = "X-Xiazki-Timestamp"
+	HeaderSignature = "X-Xiazki-Signature"
+)
+
+// Sign returns the signature of a body sent at timestamp, in Unix seconds.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Backoff is the delay after the given number of failed attempts, doubling
+// from 30 seconds up to 6 hours.
+func Backoff(attempts int) time.Duration {
+	d := firstRetry
+	for i := 1; i < attempts && d < maxRetry; i++ {
+		d *= 2
+	}
+	return min(d, maxRetry)
+}
+
+type Dispatcher struct {
+	db     *database.DB
+	client *http.Client
+	// pruned is when the finished deliveries were last pruned.
+	pruned time.Time
+}
+
+func NewDispatcher(db *database.DB, cfg config.Webhooks) *Dispatcher {
+	dialer := &net.Dialer{Timeout: cfg.Timeout}
+	if !cfg.AllowPrivate {
+		dialer.Control = refusePrivate
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &Dispatcher{
+		db: db,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: transport,
+			// a redirect would be followed to any address, so it is not
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+// refusePrivate refuses connections to addresses which are not public. It
+// checks the resolved address, so host names pointing inside are refused too.
+func refusePrivate(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return fmt.Errorf("address %s is not public", host)
+	}
+	return nil
+}
+
+// Run delivers due deliveries every PollInterval until ctx is done. Only one
+// dispatcher may run against a database.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := d.DeliverDue(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to deliver webhooks: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliverDue queues the deliveries of the changes in the outbox, attempts the
+// due deliveries and returns how many were attempted. It forgets old finished
+// deliveries every pruneInterval.
+func (d *Dispatcher) DeliverDue(ctx context.Context) (int, error) {
+	if err := d.queue(ctx); err != nil {
+		return 0, err
+	}
+	if now := time.Now(); now.Sub(d.pruned) >= pruneInterval {
+		if err := d.db.PruneWebhookDeliveries(ctx, now.Add(-retention)); err != nil {
+			return 0, err
+		}
+		d.pruned = now
+	}
+
+	count := 0
+	for {
+		deliveries, err := d.db.DueWebhookDeliveries(ctx, batchSize)
+		if err != nil {
+			return count, err
+		}
+		for _, delivery := range deliveries {
+			d.attempt(ctx, delivery)
+			if err := d.db.UpdateWebhookDelivery(ctx, delivery); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(deliveries) < batchSize {
+			return count, nil
+		}
+	}
+}
+
+// queue turns the changes in the outbox into deliveries.
+func (d *Dispatcher) queue(ctx context.Context) error {
+	for {
+		changes, err := d.db.WebhookOutbox(ctx, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, change := range changes {
+			body, ok, err := payload(change)
+			if err != nil {
+				return err
+			} else if !ok {
+				err = d.db.DeleteWebhookOutbox(ctx, change.ID)
+			} else {
+				err = d.db.QueueWebhookDeliveries(ctx, change, body)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if len(changes) < batchSize {
+			return nil
+		}
+	}
+}
+
+// attempt posts a delivery and records the outcome on it.
+func (d *Dispatcher) attempt(ctx context.Context, delivery *model.WebhookDelivery) {
+	now := time.Now()
+	delivery.Attempts++
+	delivery.LastAttemptAt = now
+	delivery.ResponseStatus = 0
+	delivery.Error = ""
+
+	status, err := d.post(ctx, delivery)
+	delivery.ResponseStatus = status
+	if err == nil {
+		delivery.Status = model.DeliveryDelivered
+		return
+	}
+
+	delivery.Error = err.Error()
+	if len(delivery.Error) > maxError {
+		delivery.Error = delivery.Error[:maxError]
+	}
+	if delivery.Attempts >= model.MaxDeliveryAttempts {
+		delivery.Status = model.DeliveryFailed
+	} else {
+		delivery.NextAttemptAt = now.Add(Backoff(delivery.Attempts))
+	}
+}
+
+// post sends a delivery and returns the response status, any status but 2xx
+// is an error.
+func (d *Dispatcher) post(ctx context.Context, delivery *model.WebhookDelivery) (int, error) {
+	if delivery.Webhook == nil {
+		return 0, fmt.Errorf("webhook %d not found", delivery.WebhookID)
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "xiazki-webhook")
+	req.Header.Set(HeaderEvent, string(delivery.Event))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderSignature, Sign(delivery.Webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
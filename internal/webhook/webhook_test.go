@@ -0,0 +1,342 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"xiazki/internal/api"
+	"xiazki/internal/config"
+	"xiazki/internal/database"
+	"xiazki/internal/database/dbtest"
+	"xiazki/internal/model"
+)
+
+func TestSign(t *testing.T) {
+	// the signature the README tells receivers to compute
+	want := "sha256=5a2a8f7d964e86f8fb6f3a65e439891e4154c53e76bca44002f5200ba270fdf6"
+	if got := Sign("secret", 1700000000, []byte(`{"type":"ping"}`)); got != want {
+		t.Errorf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{10, 4*time.Hour + 16*time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := Backoff(tt.attempts); got != tt.want {
+			t.Errorf("Backoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+// receiver is a webhook endpoint answering with status.
+type receiver struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newReceiver(t *testing.T, status int) *receiver {
+	t.Helper()
+	r := &receiver{status: status}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		w.WriteHeader(r.status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// newTestDispatcher returns a dispatcher which may post to the test servers
+// on loopback.
+func newTestDispatcher(t *testing.T) (*Dispatcher, *database.DB) {
+	t.Helper()
+	db := dbtest.New(t)
+	cfg := config.Default().Webhooks
+	cfg.AllowPrivate = true
+	return NewDispatcher(db, cfg), db
+}
+
+// addWebhook adds a webhook to url for a new user.
+func addWebhook(t *testing.T, db *database.DB, url string) *model.Webhook {
+	t.Helper()
+	user := dbtest.User(t, db, "alice", model.RoleUser)
+	hook, err := model.NewWebhook(user.ID, url, model.WebhookEvents)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.InsertWebhook(context.Background(), hook); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// ping adds a webhook to url and queues a ping for it.
+func ping(t *testing.T, db *database.DB, url string) *model.Webhook {
+	t.Helper()
+	hook := addWebhook(t, db, url)
+	payload, err := PingPayload("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.PingWebhook(context.Background(), hook, payload); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+// delivery returns the only delivery of hook.
+func delivery(t *testing.T, db *database.DB, hook *model.Webhook) *model.WebhookDelivery {
+	t.Helper()
+	deliveries, err := db.WebhookDeliveries(context.Background(), hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 {
+		t.Fatalf("%d deliveries, want 1", len(deliveries))
+	}
+	return deliveries[0]
+}
+
+// deliverDue runs DeliverDue and fails the test unless it attempted want
+// deliveries.
+func deliverDue(t *testing.T, d *Dispatcher, want int) {
+	t.Helper()
+	n, err := d.DeliverDue(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("DeliverDue attempted %d deliveries, want %d", n, want)
+	}
+}
+
+func TestDeliverDue(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	d, db := newTestDispatcher(t)
+	hook := ping(t, db, r.URL)
+
+	deliverDue(t, d, 1)
+	if got := delivery(t, db, hook); got.Status != model.DeliveryDelivered || got.Attempts != 1 || got.ResponseStatus != http.StatusNoContent {
+		t.Errorf("delivery = %+v, want delivered at the first attempt", got)
+	}
+	deliverDue(t, d, 0)
+
+	if r.count() != 1 {
+		t.Fatalf("received %d requests, want 1", r.count())
+	}
+	req, body := r.requests[0], r.bodies[0]
+	if got := req.Header.Get(HeaderEvent); got != string(model.WebhookPing) {
+		t.Errorf("%s = %q, want %q", HeaderEvent, got, model.WebhookPing)
+	}
+	timestamp, err := strconv.ParseInt(req.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		t.Fatalf("%s: %v", HeaderTimestamp, err)
+	}
+	if got, want := req.Header.Get(HeaderSignature), Sign(hook.Secret, timestamp, body); got != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, got, want)
+	}
+}
+
+func TestDeliverDueGivesUp(t *testing.T) {
+	r := newReceiver(t, http.StatusInternalServerError)
+	d, db := newTestDispatcher(t)
+	hook := ping(t, db, r.URL)
+	ctx := context.Background()
+
+	for attempt := 1; attempt <= model.MaxDeliveryAttempts; attempt++ {
+		deliverDue(t, d, 1)
+		got := delivery(t, db, hook)
+		if got.Attempts != attempt || got.ResponseStatus != http.StatusInternalServerError || got.Error == "" {
+			t.Fatalf("attempt %d: delivery = %+v", attempt, got)
+		}
+		if attempt == model.MaxDeliveryAttempts {
+			if got.Status != model.DeliveryFailed {
+				t.Fatalf("status after the last attempt = %s, want %s", got.Status, model.DeliveryFailed)
+			}
+			break
+		}
+
+		if got.Status != model.DeliveryPending {
+			t.Fatalf("attempt %d: status = %s, want %s", attempt, got.Status, model.DeliveryPending)
+		}
+		if wait := got.NextAttemptAt.Sub(got.LastAttemptAt); wait.Round(time.Second) != Backoff(attempt) {
+			t.Errorf("attempt %d: next attempt after %s, want %s", attempt, wait, Backoff(attempt))
+		}
+		// not yet due
+		deliverDue(t, d, 0)
+
+		_, err := db.NewUpdate().
+			Model((*model.WebhookDelivery)(nil)).
+			Set("next_attempt_at = ?", time.Now()).
+			Where("id = ?", got.ID).
+			Exec(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deliverDue(t, d, 0)
+	if r.count() != model.MaxDeliveryAttempts {
+		t.Errorf("received %d requests, want %d", r.count(), model.MaxDeliveryAttempts)
+	}
+}
+
+func TestDeliverDueRefusesRedirect(t *testing.T) {
+	target := newReceiver(t, http.StatusNoContent)
+	redirect := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	t.Cleanup(redirect.Close)
+	d, db := newTestDispatcher(t)
+	hook := ping(t, db, redirect.URL)
+
+	deliverDue(t, d, 1)
+	if got := delivery(t, db, hook); got.Status != model.DeliveryPending || got.ResponseStatus != http.StatusTemporaryRedirect {
+		t.Errorf("delivery = %+v, want a failed attempt with the redirect", got)
+	}
+	if target.count() != 0 {
+		t.Error("the redirect was followed")
+	}
+}
+
+func TestDeliverDueRefusesPrivate(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	db := dbtest.New(t)
+	d := NewDispatcher(db, config.Default().Webhooks)
+	hook := ping(t, db, r.URL)
+
+	deliverDue(t, d, 1)
+	if got := delivery(t, db, hook); got.Status != model.DeliveryPending || got.Error == "" {
+		t.Errorf("delivery = %+v, want a failed attempt", got)
+	}
+	if r.count() != 0 {
+		t.Error("posted to a loopback address")
+	}
+}
+
+func TestDeliverDuePrunes(t *testing.T) {
+	d, db := newTestDispatcher(t)
+	hook := addWebhook(t, db, "http://example.invalid")
+	ctx := context.Background()
+
+	now := time.Now()
+	old := now.Add(-retention - time.Hour)
+	deliveries := []*model.WebhookDelivery{
+		{Status: model.DeliveryDelivered, LastAttemptAt: old, NextAttemptAt: old},
+		{Status: model.DeliveryFailed, LastAttemptAt: old, NextAttemptAt: old},
+		{Status: model.DeliveryDelivered, LastAttemptAt: now, NextAttemptAt: now},
+		// pending deliveries are kept however old their last attempt is
+		{Status: model.DeliveryPending, LastAttemptAt: old, NextAttemptAt: now.Add(time.Hour)},
+	}
+	for _, delivery := range deliveries {
+		delivery.WebhookID = hook.ID
+		delivery.Event = model.WebhookPing
+		delivery.Payload = "{}"
+	}
+	if _, err := db.NewInsert().Model(&deliveries).Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverDue(t, d, 0)
+	kept, err := db.WebhookDeliveries(ctx, hook.ID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kept) != 2 || kept[0].ID != deliveries[3].ID || kept[1].ID != deliveries[2].ID {
+		t.Errorf("kept %d deliveries, want the recent and the pending one", len(kept))
+	}
+}
+
+func TestDeliverDueBuildsPayloads(t *testing.T) {
+	r := newReceiver(t, http.StatusNoContent)
+	d, db := newTestDispatcher(t)
+	hook := addWebhook(t, db, r.URL)
+	user := &model.User{ID: hook.UserID}
+	ctx := context.Background()
+
+	book := &model.Book{Title: "Dune", AddedByID: user.ID}
+	if err := db.InsertBook(ctx, book); err != nil {
+		t.Fatal(err)
+	}
+	event := &model.Event{Type: model.EventReading, Date: time.Now()}
+	if err := db.InsertEvent(ctx, book, user, event); err != nil {
+		t.Fatal(err)
+	}
+	review := &model.Review{UserID: user.ID, BookID: book.ID, Rating: 8}
+	if err := db.InsertOrUpdateReview(ctx, review, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	// an event deleted before it was delivered is dropped
+	deleted := &model.Event{Type: model.EventFinished, Date: time.Now()}
+	if err := db.InsertEvent(ctx, book, user, deleted); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.NewDelete().Model(deleted).WherePK().Exec(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// and imports queue nothing
+	imported := &model.Book{Title: "Emma", AddedByID: user.ID}
+	if err := db.InsertBook(database.WithoutWebhooks(ctx), imported); err != nil {
+		t.Fatal(err)
+	}
+
+	deliverDue(t, d, 3)
+	deliverDue(t, d, 0)
+	var payloads []api.WebhookPayload
+	for _, body := range r.bodies {
+		var p api.WebhookPayload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Fatal(err)
+		}
+		payloads = append(payloads, p)
+	}
+	if len(payloads) != 3 {
+		t.Fatalf("received %d payloads, want 3", len(payloads))
+	}
+	for i, want := range []model.WebhookEvent{model.WebhookBookCreated, model.WebhookEventCreated, model.WebhookReviewSaved} {
+		if p := payloads[i]; p.Type != want || p.Username != "alice" || p.Book == nil || p.Book.ID != book.ID || p.Book.Title != "Dune" {
+			t.Errorf("payload %d = %+v, want %s of Dune by alice", i, p, want)
+		}
+	}
+	if e := payloads[1].Event; e == nil || e.ID != event.ID || e.Type != model.EventReading {
+		t.Errorf("event = %+v, want the reading event", e)
+	}
+	if r := payloads[2].Review; r == nil || r.ID != review.ID || r.Rating != 8 || r.Username != "alice" {
+		t.Errorf("review = %+v, want the review by alice", r)
+	}
+
+	if n, err := db.NewSelect().Model((*model.WebhookOutbox)(nil)).Count(ctx); err != nil || n != 0 {
+		t.Errorf("outbox holds %d changes, %v, want none", n, err)
+	}
+}
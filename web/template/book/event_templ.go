@@ -9,11 +9,13 @@ import "github.com/a-h/templ"
 import templruntime "github.com/a-h/templ/runtime"
 
 import (
+	"slices"
 	"strconv"
 	"time"
 
 	"xiazki/internal/model"
 	"xiazki/web/template/components"
+	"xiazki/web/template/layout"
 )
 
 type EventFormValues struct {
@@ -21,9 +23,15 @@ type EventFormValues struct {
 	Date string `json:"date,omitempty" form:"date"`
 }
 
-func (e EventFormValues) Validate() error {
-	// TODO: implement validation logic
-	return nil
+func (e EventFormValues) Validate() map[string]string {
+	errors := make(map[string]string)
+	if !slices.Contains(model.EventTypes, model.EventType(e.Type)) {
+		errors["type"] = "Invalid event type"
+	}
+	if _, err := time.Parse("2006-01-02", e.Date); err != nil {
+		errors["date"] = "Invalid date"
+	}
+	return errors
 }
 
 func (e EventFormValues) ToEvent() *model.Event {
@@ -71,9 +79,9 @@ func AddEventModal(bookID int64) templ.Component {
 				return templ_7745c5c3_Err
 			}
 			var templ_7745c5c3_Var3 string
-			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs("/book/" + strconv.FormatInt(bookID, 10) + "/add_event")
+			templ_7745c5c3_Var3, templ_7745c5c3_Err = templ.JoinStringErrs(layout.Path(ctx, "/book/"+strconv.FormatInt(bookID, 10)+"/add_event"))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 33, Col: 69}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 41, Col: 87}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var3))
 			if templ_7745c5c3_Err != nil {
@@ -86,7 +94,7 @@ func AddEventModal(bookID int64) templ.Component {
 			var templ_7745c5c3_Var4 string
 			templ_7745c5c3_Var4, templ_7745c5c3_Err = templ.JoinStringErrs(string(model.EventFinished))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 38, Col: 115}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 46, Col: 115}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var4))
 			if templ_7745c5c3_Err != nil {
@@ -99,7 +107,7 @@ func AddEventModal(bookID int64) templ.Component {
 			var templ_7745c5c3_Var5 string
 			templ_7745c5c3_Var5, templ_7745c5c3_Err = templ.JoinStringErrs(string(model.EventReading))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 40, Col: 112}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 48, Col: 112}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var5))
 			if templ_7745c5c3_Err != nil {
@@ -112,13 +120,26 @@ func AddEventModal(bookID int64) templ.Component {
 			var templ_7745c5c3_Var6 string
 			templ_7745c5c3_Var6, templ_7745c5c3_Err = templ.JoinStringErrs(string(model.EventDropped))
 			if templ_7745c5c3_Err != nil {
-				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 42, Col: 112}
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 50, Col: 112}
 			}
 			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var6))
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "\"> <label for=\"dropped\" class=\"peer-checked/dropped:border-red-light peer-checked/dropped:bg-red peer-checked/dropped:text-background border-gray cursor-pointer rounded-md border px-4 py-2 text-sm\">Dropped</label><div class=\"mt-4 hidden text-sm font-medium peer-checked/finished:block\">Date you finished reading this book:</div><div class=\"mt-4 hidden text-sm font-medium peer-checked/reading:block\">Date you started reading this book:</div><div class=\"mt-4 hidden text-sm font-medium peer-checked/dropped:block\">Date you dropped this book:</div>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 5, "\"> <label for=\"dropped\" class=\"peer-checked/dropped:border-red-light peer-checked/dropped:bg-red peer-checked/dropped:text-background border-gray mr-2 cursor-pointer rounded-md border px-4 py-2 text-sm\">Dropped</label> <input id=\"to-read\" class=\"peer/toread hidden\" type=\"radio\" name=\"type\" value=\"")
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			var templ_7745c5c3_Var7 string
+			templ_7745c5c3_Var7, templ_7745c5c3_Err = templ.JoinStringErrs(string(model.EventToRead))
+			if templ_7745c5c3_Err != nil {
+				return templ.Error{Err: templ_7745c5c3_Err, FileName: `web/template/book/event.templ`, Line: 52, Col: 110}
+			}
+			_, templ_7745c5c3_Err = templ_7745c5c3_Buffer.WriteString(templ.EscapeString(templ_7745c5c3_Var7))
+			if templ_7745c5c3_Err != nil {
+				return templ_7745c5c3_Err
+			}
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "\"> <label for=\"to-read\" class=\"peer-checked/toread:border-gray-light peer-checked/toread:bg-gray peer-checked/toread:text-background border-gray cursor-pointer rounded-md border px-4 py-2 text-sm\">To Read</label><div class=\"mt-4 hidden text-sm font-medium peer-checked/finished:block\">Date you finished reading this book:</div><div class=\"mt-4 hidden text-sm font-medium peer-checked/reading:block\">Date you started reading this book:</div><div class=\"mt-4 hidden text-sm font-medium peer-checked/dropped:block\">Date you dropped this book:</div><div class=\"mt-4 hidden text-sm font-medium peer-checked/toread:block\">Date you decided to read this book:</div>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
@@ -126,7 +147,7 @@ func AddEventModal(bookID int64) templ.Component {
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
-			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 6, "</fieldset><div class=\"flex justify-end\"><button type=\"button\" class=\"bg-gray text-background hover:bg-gray-light focus:ring-gray-light mr-2 rounded-md px-4 py-2 text-sm transition-colors duration-200 focus:outline-none focus:ring-2\" onclick=\"document.getElementById('modal').innerHTML = ''\">Cancel</button> <button type=\"submit\" class=\"bg-blue text-background hover:bg-blue-light focus:ring-blue-light rounded-md px-4 py-2 text-sm transition-colors duration-200 focus:outline-none focus:ring-2\">Add Event</button></div></form></div>")
+			templ_7745c5c3_Err = templruntime.WriteString(templ_7745c5c3_Buffer, 7, "</fieldset><div class=\"flex justify-end\"><button type=\"button\" class=\"bg-gray text-background hover:bg-gray-light focus:ring-gray-light mr-2 rounded-md px-4 py-2 text-sm transition-colors duration-200 focus:outline-none focus:ring-2\" onclick=\"document.getElementById('modal').innerHTML = ''\">Cancel</button> <button type=\"submit\" class=\"bg-blue text-background hover:bg-blue-light focus:ring-blue-light rounded-md px-4 py-2 text-sm transition-colors duration-200 focus:outline-none focus:ring-2\">Add Event</button></div></form></div>")
 			if templ_7745c5c3_Err != nil {
 				return templ_7745c5c3_Err
 			}
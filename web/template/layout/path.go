@@ -0,0 +1,29 @@
+package layout
+
+import (
+	"context"
+	"strings"
+)
+
+type basePathKey struct{}
+
+// WithBasePath returns a context carrying the path prefix the application is
+// served under, for Path to use.
+func WithBasePath(ctx context.Context, basePath string) context.Context {
+	return context.WithValue(ctx, basePathKey{}, basePath)
+}
+
+// Path prefixes an absolute path of the application with the base path from
+// the context. Anything else, like external URLs, is returned unchanged.
+func Path(ctx context.Context, path string) string {
+	basePath, _ := ctx.Value(basePathKey{}).(string)
+	return JoinBasePath(basePath, path)
+}
+
+// JoinBasePath prefixes path with basePath, see Path.
+func JoinBasePath(basePath, path string) string {
+	if basePath == "" || !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") {
+		return path
+	}
+	return basePath + path
+}
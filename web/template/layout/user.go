@@ -0,0 +1,21 @@
+package layout
+
+import (
+	"context"
+
+	"xiazki/internal/model"
+)
+
+type userKey struct{}
+
+// WithUser returns a context carrying the signed in user.
+func WithUser(ctx context.Context, user *model.User) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+// CurrentUser returns the signed in user, or nil on pages which do not
+// require signing in.
+func CurrentUser(ctx context.Context) *model.User {
+	user, _ := ctx.Value(userKey{}).(*model.User)
+	return user
+}